@@ -18,13 +18,13 @@ func NewDeleteCmd() *cobra.Command {
 	deleteCmd := &cobra.Command{
 		Use:   "delete",
 		Short: "Delete a container",
-		RunE: func(_ *cobra.Command, args []string) error {
+		RunE: func(cobraCmd *cobra.Command, args []string) error {
 			options, err := options.FromEnv()
 			if err != nil {
 				return err
 			}
 
-			return cmd.Run(context.Background(), options, log.Default)
+			return cmd.Run(cobraCmd.Context(), options, log.Default)
 		},
 	}
 
@@ -33,7 +33,7 @@ func NewDeleteCmd() *cobra.Command {
 
 // Run runs the command logic
 func (cmd *DeleteCmd) Run(ctx context.Context, options *options.Options, log log.Logger) error {
-	ecsProvider, err := ecs.NewProvider(ctx, options, log)
+	ecsProvider, err := ecs.NewProvider(ctx, options, log, nil)
 	if err != nil {
 		return err
 	}
@@ -50,7 +50,7 @@ func (cmd *EntrypointCmd) Run() error {
 	go func() {
 		err = server.ListenAndServe()
 		if err != nil {
-			log.Default.Fatal("SSH server failed: %v", err)
+			log.Default.Fatalf("SSH server failed: %v", err)
 		} else {
 			log.Default.Fatal("SSH server ended unexpectedly")
 		}
@@ -78,7 +78,6 @@ func (cmd *EntrypointCmd) Run() error {
 	if len(args) == 0 {
 		// wait indefinitely
 		select {}
-		return nil
 	}
 
 	// run entrypoint
@@ -20,13 +20,13 @@ func NewFindCmd() *cobra.Command {
 	findCmd := &cobra.Command{
 		Use:   "find",
 		Short: "Find a container",
-		RunE: func(_ *cobra.Command, args []string) error {
+		RunE: func(cobraCmd *cobra.Command, args []string) error {
 			options, err := options.FromEnv()
 			if err != nil {
 				return err
 			}
 
-			return cmd.Run(context.Background(), options, log.Default.ErrorStreamOnly())
+			return cmd.Run(cobraCmd.Context(), options, log.Default.ErrorStreamOnly())
 		},
 	}
 
@@ -35,7 +35,7 @@ func NewFindCmd() *cobra.Command {
 
 // Run runs the command logic
 func (cmd *FindCmd) Run(ctx context.Context, options *options.Options, log log.Logger) error {
-	ecsProvider, err := ecs.NewProvider(ctx, options, log)
+	ecsProvider, err := ecs.NewProvider(ctx, options, log, nil)
 	if err != nil {
 		return err
 	}
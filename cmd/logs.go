@@ -0,0 +1,64 @@
+package cmd
+
+import (
+	"context"
+	"fmt"
+	"os"
+
+	"github.com/loft-sh/devpod-provider-ecs/pkg/ecs"
+	"github.com/loft-sh/devpod-provider-ecs/pkg/options"
+	"github.com/loft-sh/log"
+	"github.com/spf13/cobra"
+)
+
+// LogsCmd holds the cmd flags
+type LogsCmd struct {
+	Follow bool
+	Tail   int32
+}
+
+// NewLogsCmd defines a command
+func NewLogsCmd() *cobra.Command {
+	cmd := &LogsCmd{}
+	logsCmd := &cobra.Command{
+		Use:   "logs [workspace-id]",
+		Short: "Print the container logs of a workspace",
+		Args:  cobra.MaximumNArgs(1),
+		RunE: func(cobraCmd *cobra.Command, args []string) error {
+			options, err := options.FromProviderEnv()
+			if err != nil {
+				return err
+			}
+
+			if len(args) > 0 {
+				options.DevContainerID = args[0]
+			} else {
+				options.DevContainerID = os.Getenv("DEVCONTAINER_ID")
+				if options.DevContainerID == "" {
+					return fmt.Errorf("please specify the workspace id")
+				}
+			}
+
+			return cmd.Run(cobraCmd.Context(), options, log.Default.ErrorStreamOnly())
+		},
+	}
+	logsCmd.Flags().BoolVarP(&cmd.Follow, "follow", "f", false, "Keep printing new log lines")
+	logsCmd.Flags().Int32VarP(&cmd.Tail, "tail", "n", 100, "Number of lines to print from the end of the logs, 0 prints all lines")
+	return logsCmd
+}
+
+// Run runs the command logic
+func (cmd *LogsCmd) Run(ctx context.Context, options *options.Options, log log.Logger) error {
+	if !options.CloudWatchLogs {
+		return fmt.Errorf("CLOUDWATCH_LOGS is disabled, there are no logs to print")
+	} else if cmd.Tail < 0 {
+		return fmt.Errorf("--tail must not be negative")
+	}
+
+	ecsProvider, err := ecs.NewProvider(ctx, options, log, nil)
+	if err != nil {
+		return err
+	}
+
+	return ecsProvider.Logs(ctx, options.DevContainerID, cmd.Tail, cmd.Follow, os.Stdout)
+}
@@ -1,8 +1,11 @@
 package cmd
 
 import (
+	"context"
 	"os"
 	"os/exec"
+	"os/signal"
+	"syscall"
 
 	"github.com/loft-sh/devpod-provider-ecs/pkg/version"
 	"github.com/loft-sh/log"
@@ -34,8 +37,23 @@ func Execute() {
 	// build the root command
 	rootCmd := BuildRoot()
 
+	// cancel the context on SIGINT / SIGTERM, so commands can clean up. The handler stays
+	// installed until the command returned, so further signals don't interrupt a rollback.
+	ctx, cancel := context.WithCancel(context.Background())
+	signals := make(chan os.Signal, 1)
+	signal.Notify(signals, os.Interrupt, syscall.SIGTERM)
+	go func() {
+		<-signals
+		cancel()
+		for range signals {
+			log.Default.Warn("Cleaning up, please wait...")
+		}
+	}()
+
 	// execute command
-	err := rootCmd.Execute()
+	err := rootCmd.ExecuteContext(ctx)
+	signal.Stop(signals)
+	cancel()
 	if err != nil {
 		if exitErr, ok := err.(*ssh.ExitError); ok {
 			os.Exit(exitErr.ExitStatus())
@@ -66,5 +84,7 @@ func BuildRoot() *cobra.Command {
 	rootCmd.AddCommand(NewCommandCmd())
 	rootCmd.AddCommand(NewStopCmd())
 	rootCmd.AddCommand(NewTargetArchitectureCmd())
+	rootCmd.AddCommand(NewLogsCmd())
+	rootCmd.AddCommand(NewTeardownCmd())
 	return rootCmd
 }
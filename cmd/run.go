@@ -22,13 +22,13 @@ func NewRunCmd() *cobra.Command {
 	runCmd := &cobra.Command{
 		Use:   "run",
 		Short: "Run a container",
-		RunE: func(_ *cobra.Command, args []string) error {
+		RunE: func(cobraCmd *cobra.Command, args []string) error {
 			options, err := options.FromEnv()
 			if err != nil {
 				return err
 			}
 
-			return cmd.Run(context.Background(), options, log.Default)
+			return cmd.Run(cobraCmd.Context(), options, log.Default)
 		},
 	}
 
@@ -43,7 +43,7 @@ func (cmd *RunCmd) Run(ctx context.Context, options *options.Options, log log.Lo
 		return fmt.Errorf("unmarshal run options: %w", err)
 	}
 
-	ecsProvider, err := ecs.NewProvider(ctx, options, log)
+	ecsProvider, err := ecs.NewProvider(ctx, options, log, nil)
 	if err != nil {
 		return err
 	}
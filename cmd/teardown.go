@@ -0,0 +1,87 @@
+package cmd
+
+import (
+	"bufio"
+	"context"
+	"fmt"
+	"os"
+	"strings"
+
+	"github.com/loft-sh/devpod-provider-ecs/pkg/ecs"
+	"github.com/loft-sh/devpod-provider-ecs/pkg/options"
+	"github.com/loft-sh/log"
+	"github.com/spf13/cobra"
+)
+
+// TeardownCmd holds the cmd flags
+type TeardownCmd struct {
+	DryRun        bool
+	Yes           bool
+	IncludeShared bool
+}
+
+// NewTeardownCmd defines a command
+func NewTeardownCmd() *cobra.Command {
+	cmd := &TeardownCmd{}
+	teardownCmd := &cobra.Command{
+		Use:     "teardown",
+		Aliases: []string{"uninstall"},
+		Short:   "Delete the AWS resources the provider created",
+		Long: `Delete the AWS resources the provider created for the cluster: task definitions,
+efs access points and the iam roles and policies. Workspaces with a running task are
+skipped, together with the iam resources they need. Resources other clusters might use
+too, like the log group, are only deleted with --include-shared, which doesn't check the
+workspaces of the other clusters.`,
+		Args: cobra.NoArgs,
+		RunE: func(cobraCmd *cobra.Command, args []string) error {
+			options, err := options.FromProviderEnv()
+			if err != nil {
+				return err
+			}
+
+			return cmd.Run(cobraCmd.Context(), options, log.Default.ErrorStreamOnly())
+		},
+	}
+	teardownCmd.Flags().BoolVar(&cmd.DryRun, "dry-run", false, "Only print the resources that would be deleted")
+	teardownCmd.Flags().BoolVarP(&cmd.Yes, "yes", "y", false, "Delete the resources without asking for confirmation")
+	teardownCmd.Flags().BoolVar(&cmd.IncludeShared, "include-shared", false, "Also delete the resources other clusters might use, like the log group and the EBS infrastructure role")
+	return teardownCmd
+}
+
+// Run runs the command logic
+func (cmd *TeardownCmd) Run(ctx context.Context, options *options.Options, log log.Logger) error {
+	ecsProvider, err := ecs.NewProvider(ctx, options, log, nil)
+	if err != nil {
+		return err
+	}
+
+	resources, err := ecsProvider.ListResources(ctx, cmd.IncludeShared)
+	if err != nil {
+		return err
+	} else if len(resources) == 0 {
+		fmt.Println("No resources found")
+		return nil
+	}
+
+	for _, resource := range resources {
+		fmt.Printf("%s\t%s\n", resource.Type, resource.ID)
+	}
+	if cmd.DryRun {
+		return nil
+	}
+
+	if !cmd.Yes {
+		fmt.Printf("Delete %d resources? [y/N] ", len(resources))
+		answer, err := bufio.NewReader(os.Stdin).ReadString('\n')
+		if err != nil && answer == "" {
+			return fmt.Errorf("read confirmation: %w", err)
+		}
+
+		answer = strings.ToLower(strings.TrimSpace(answer))
+		if answer != "y" && answer != "yes" {
+			return fmt.Errorf("aborted")
+		}
+	}
+
+	return ecsProvider.DeleteResources(ctx, resources)
+}
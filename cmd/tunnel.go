@@ -39,10 +39,10 @@ func (cmd *TunnelCmd) Run(ctx context.Context) error {
 		return err
 	}
 
-	ecsProvider, err := ecs.NewProvider(ctx, awsOptions, log.Default.ErrorStreamOnly())
+	ecsProvider, err := ecs.NewProvider(ctx, awsOptions, log.Default.ErrorStreamOnly(), nil)
 	if err != nil {
 		return err
 	}
 
-	return ecsProvider.StartSession(cmd.Target, cmd.Port)
+	return ecsProvider.StartSession(ctx, cmd.Target, cmd.Port)
 }
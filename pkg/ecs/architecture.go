@@ -0,0 +1,124 @@
+package ecs
+
+import (
+	"context"
+	"fmt"
+
+	"github.com/aws/aws-sdk-go-v2/service/ecs"
+	"github.com/aws/aws-sdk-go-v2/service/ecs/types"
+	"github.com/loft-sh/devpod-provider-ecs/pkg/options"
+)
+
+const (
+	architectureAuto  = "auto"
+	architectureAmd64 = "amd64"
+	architectureArm64 = "arm64"
+
+	// cpuArchitectureAttribute is set by the ecs agent on every container instance
+	cpuArchitectureAttribute = "ecs.cpu-architecture"
+)
+
+// TargetArchitecture returns the architecture devpod should inject the agent for. With auto
+// detection this prefers the architecture the workspace task definition was registered with.
+func (p *EcsProvider) TargetArchitecture(ctx context.Context, workspaceId string) (string, error) {
+	if p.Config.ClusterArchitecture != architectureAuto {
+		return p.Config.ClusterArchitecture, nil
+	}
+
+	arn, err := p.getLatestTaskDefinition(ctx, newWorkspace(workspaceId))
+	if err != nil {
+		return "", err
+	} else if arn != "" {
+		taskDefinition, err := p.client.DescribeTaskDefinition(ctx, &ecs.DescribeTaskDefinitionInput{
+			TaskDefinition: options.Ptr(arn),
+		})
+		if err != nil {
+			return "", fmt.Errorf("describe task definition: %w", err)
+		}
+
+		architecture := getArchitecture(taskDefinition.TaskDefinition.RuntimePlatform)
+		if architecture != "" {
+			return architecture, nil
+		}
+	}
+
+	return p.getClusterArchitecture(ctx)
+}
+
+// getClusterArchitecture returns the configured architecture or, if set to auto, the
+// architecture of the majority of the active container instances in the cluster. Fargate
+// has no instances to look at, so auto is fixed to amd64 there.
+func (p *EcsProvider) getClusterArchitecture(ctx context.Context) (string, error) {
+	if p.Config.ClusterArchitecture != architectureAuto {
+		return p.Config.ClusterArchitecture, nil
+	} else if p.Config.LaunchType == string(types.LaunchTypeFargate) {
+		// fargate runs both and the cluster doesn't prefer either, so new workspaces use
+		// amd64. Existing ones keep the architecture of their task definition.
+		return architectureAmd64, nil
+	}
+
+	containerInstances, err := p.getContainerInstances(ctx)
+	if err != nil {
+		return "", err
+	}
+
+	counts := map[string]int{}
+	for _, containerInstance := range containerInstances {
+		if containerInstance.Status == nil || *containerInstance.Status != "ACTIVE" {
+			continue
+		}
+
+		switch getAttribute(containerInstance.Attributes, cpuArchitectureAttribute) {
+		case "x86_64":
+			counts[architectureAmd64]++
+		case "arm64":
+			counts[architectureArm64]++
+		}
+	}
+	if len(counts) == 0 {
+		p.Log.Warnf("Couldn't detect the cluster architecture as there are no active container instances, using %s", architectureAmd64)
+		return architectureAmd64, nil
+	} else if counts[architectureArm64] > counts[architectureAmd64] {
+		return architectureArm64, nil
+	}
+
+	return architectureAmd64, nil
+}
+
+// getRuntimePlatform returns the runtime platform for the given architecture
+func getRuntimePlatform(architecture string) *types.RuntimePlatform {
+	cpuArchitecture := types.CPUArchitectureX8664
+	if architecture == architectureArm64 {
+		cpuArchitecture = types.CPUArchitectureArm64
+	}
+
+	return &types.RuntimePlatform{
+		CpuArchitecture:       cpuArchitecture,
+		OperatingSystemFamily: types.OSFamilyLinux,
+	}
+}
+
+func getArchitecture(runtimePlatform *types.RuntimePlatform) string {
+	if runtimePlatform == nil {
+		return ""
+	}
+
+	switch runtimePlatform.CpuArchitecture {
+	case types.CPUArchitectureX8664:
+		return architectureAmd64
+	case types.CPUArchitectureArm64:
+		return architectureArm64
+	}
+
+	return ""
+}
+
+func getAttribute(attributes []types.Attribute, name string) string {
+	for _, attribute := range attributes {
+		if attribute.Name != nil && *attribute.Name == name && attribute.Value != nil {
+			return *attribute.Value
+		}
+	}
+
+	return ""
+}
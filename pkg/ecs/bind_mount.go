@@ -0,0 +1,124 @@
+package ecs
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"io"
+	"os"
+	"path"
+	"strings"
+	"time"
+
+	"github.com/loft-sh/devpod-provider-ecs/pkg/hash"
+	"github.com/loft-sh/devpod/pkg/devcontainer/config"
+	"github.com/loft-sh/devpod/pkg/driver"
+	"github.com/loft-sh/devpod/pkg/extract"
+)
+
+// copyBindMounts copies the local sources of the bind mounts into the running task, as
+// devpod expects bind mounts to be copied from local to remote once. Completion is recorded
+// as a marker file in the workspace dir, so a later run doesn't overwrite the remote copy.
+func (p *EcsProvider) copyBindMounts(ctx context.Context, workspaceId string, runOptions *driver.RunOptions) error {
+	mounts := []*config.Mount{}
+	for _, mount := range runOptions.Mounts {
+		if mount.Type != "bind" || mount.Source == "" || mount.Target == "" {
+			continue
+		}
+
+		_, err := os.Stat(mount.Source)
+		if err != nil {
+			p.Log.Warnf("Skip copying bind mount %s: %v", mount.Source, err)
+			continue
+		}
+
+		mounts = append(mounts, mount)
+	}
+	if len(mounts) == 0 {
+		return nil
+	}
+
+	err := p.waitForContainer(ctx, workspaceId)
+	if err != nil {
+		return err
+	}
+
+	workspaceDir := getWorkspaceDir(runOptions)
+	for _, mount := range mounts {
+		p.Log.Infof("Copy %s to %s", mount.Source, mount.Target)
+		err = p.copyBindMount(ctx, workspaceId, workspaceDir, mount)
+		if err != nil {
+			return fmt.Errorf("copy bind mount %s: %w", mount.Source, err)
+		}
+	}
+
+	return nil
+}
+
+func (p *EcsProvider) copyBindMount(ctx context.Context, workspaceId, workspaceDir string, mount *config.Mount) error {
+	stat, err := os.Stat(mount.Source)
+	if err != nil {
+		return err
+	}
+
+	reader, writer := io.Pipe()
+	defer reader.Close()
+	go func() {
+		_ = writer.CloseWithError(extract.WriteTar(writer, mount.Source, false))
+	}()
+
+	stderr := &bytes.Buffer{}
+	script := copyBindMountScript(mount.Target, bindMountMarker(workspaceDir, mount), stat.IsDir())
+	err = p.ExecuteCommand(ctx, workspaceId, "", script, reader, io.Discard, stderr)
+	if err != nil {
+		return fmt.Errorf("%w: %s", err, strings.TrimSpace(stderr.String()))
+	}
+
+	return nil
+}
+
+// waitForContainer waits until commands can be executed in the container, which is some
+// time after the task is running as the ssh server is installed by the entrypoint
+func (p *EcsProvider) waitForContainer(ctx context.Context, workspaceId string) error {
+	p.Log.Infof("Wait for container to accept commands...")
+	now := time.Now()
+	for {
+		stderr := &bytes.Buffer{}
+		err := p.ExecuteCommand(ctx, workspaceId, "", "true", nil, io.Discard, stderr)
+		if err == nil {
+			return nil
+		} else if time.Since(now) > p.Config.StartTimeout {
+			return fmt.Errorf("timed out waiting for container to accept commands: %w: %s", err, strings.TrimSpace(stderr.String()))
+		}
+
+		p.Log.Debugf("Container not ready yet: %v", err)
+		err = sleep(ctx, p.pollInterval)
+		if err != nil {
+			return err
+		}
+	}
+}
+
+func bindMountMarker(workspaceDir string, mount *config.Mount) string {
+	return path.Join(workspaceDir, ".devpod-mounts", hash.String(mount.Source + ":" + mount.Target)[:16])
+}
+
+// copyBindMountScript extracts a tar stream from stdin to the target, unless the marker
+// exists already. Files are streamed as a tar with a single entry.
+func copyBindMountScript(target, marker string, isDir bool) string {
+	extractCmd := "mkdir -p " + shellQuote(target) + " && tar -xf - -C " + shellQuote(target)
+	if !isDir {
+		extractCmd = "mkdir -p " + shellQuote(path.Dir(target)) + " && tar -xOf - > " + shellQuote(target)
+	}
+
+	return fmt.Sprintf(
+		"set -e; if [ -f %[1]s ]; then exit 0; fi; %[2]s; mkdir -p %[3]s && touch %[1]s",
+		shellQuote(marker),
+		extractCmd,
+		shellQuote(path.Dir(marker)),
+	)
+}
+
+func shellQuote(s string) string {
+	return "'" + strings.ReplaceAll(s, "'", `'\''`) + "'"
+}
@@ -0,0 +1,84 @@
+package ecs
+
+import (
+	"bytes"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"testing"
+
+	"github.com/loft-sh/devpod/pkg/extract"
+)
+
+func runCopyBindMountScript(t *testing.T, source, target, marker string) {
+	t.Helper()
+
+	stat, err := os.Stat(source)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	stdin := &bytes.Buffer{}
+	err = extract.WriteTar(stdin, source, false)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	cmd := exec.Command("sh", "-c", copyBindMountScript(target, marker, stat.IsDir()))
+	cmd.Stdin = stdin
+	out, err := cmd.CombinedOutput()
+	if err != nil {
+		t.Fatalf("run copy script: %v: %s", err, out)
+	}
+}
+
+func TestCopyBindMountScript(t *testing.T) {
+	if _, err := exec.LookPath("tar"); err != nil {
+		t.Skip("tar is not installed")
+	}
+
+	local := t.TempDir()
+	remote := t.TempDir()
+	marker := filepath.Join(remote, "workspaces", ".devpod-mounts", "marker")
+
+	// directory
+	err := os.MkdirAll(filepath.Join(local, "dir", "nested"), 0755)
+	if err != nil {
+		t.Fatal(err)
+	}
+	err = os.WriteFile(filepath.Join(local, "dir", "nested", "file"), []byte("content"), 0644)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	target := filepath.Join(remote, "it's", "dir")
+	runCopyBindMountScript(t, filepath.Join(local, "dir"), target, marker)
+	out, err := os.ReadFile(filepath.Join(target, "nested", "file"))
+	if err != nil || string(out) != "content" {
+		t.Fatalf("expected copied file, got %q: %v", out, err)
+	}
+
+	// the marker prevents a second copy from overwriting the remote
+	err = os.WriteFile(filepath.Join(target, "nested", "file"), []byte("changed"), 0644)
+	if err != nil {
+		t.Fatal(err)
+	}
+	runCopyBindMountScript(t, filepath.Join(local, "dir"), target, marker)
+	out, _ = os.ReadFile(filepath.Join(target, "nested", "file"))
+	if string(out) != "changed" {
+		t.Fatalf("expected remote file to be kept, got %q", out)
+	}
+
+	// single file to a different name
+	err = os.WriteFile(filepath.Join(local, ".gitconfig"), []byte("[user]"), 0644)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	target = filepath.Join(remote, "home", "gitconfig")
+	runCopyBindMountScript(t, filepath.Join(local, ".gitconfig"), target, marker+"-file")
+	out, err = os.ReadFile(target)
+	if err != nil || string(out) != "[user]" {
+		t.Fatalf("expected copied file, got %q: %v", out, err)
+	}
+}
@@ -0,0 +1,51 @@
+package ecs
+
+import (
+	"fmt"
+	"slices"
+	"strings"
+
+	"github.com/aws/aws-sdk-go-v2/service/ecs/types"
+)
+
+// linuxCapabilities are the capabilities ECS accepts in LinuxParameters.Capabilities
+var linuxCapabilities = []string{
+	"ALL", "AUDIT_CONTROL", "AUDIT_WRITE", "BLOCK_SUSPEND", "CHOWN", "DAC_OVERRIDE",
+	"DAC_READ_SEARCH", "FOWNER", "FSETID", "IPC_LOCK", "IPC_OWNER", "KILL", "LEASE",
+	"LINUX_IMMUTABLE", "MAC_ADMIN", "MAC_OVERRIDE", "MKNOD", "NET_ADMIN", "NET_BIND_SERVICE",
+	"NET_BROADCAST", "NET_RAW", "SETFCAP", "SETGID", "SETPCAP", "SETUID", "SYS_ADMIN",
+	"SYS_BOOT", "SYS_CHROOT", "SYS_MODULE", "SYS_NICE", "SYS_PACCT", "SYS_PTRACE",
+	"SYS_RAWIO", "SYS_RESOURCE", "SYS_TIME", "SYS_TTY_CONFIG", "SYSLOG", "WAKE_ALARM",
+}
+
+// fargateCapabilities are the only capabilities that can be added on fargate
+var fargateCapabilities = []string{"SYS_PTRACE"}
+
+// getCapabilities converts the capAdd of the devcontainer.json into ecs capabilities and
+// validates them, as ecs would only fail with an opaque error when starting the task
+func (p *EcsProvider) getCapabilities(capAdd []string) (*types.KernelCapabilities, error) {
+	if len(capAdd) == 0 {
+		return nil, nil
+	}
+
+	capabilities := []string{}
+	for _, capability := range capAdd {
+		capability = strings.TrimPrefix(strings.ToUpper(strings.TrimSpace(capability)), "CAP_")
+		if capability == "" || slices.Contains(capabilities, capability) {
+			continue
+		} else if !slices.Contains(linuxCapabilities, capability) {
+			return nil, fmt.Errorf("unknown linux capability %s in capAdd", capability)
+		} else if p.Config.LaunchType == string(types.LaunchTypeFargate) && !slices.Contains(fargateCapabilities, capability) {
+			return nil, fmt.Errorf("capability %s in capAdd is not supported on fargate, only %s can be added", capability, strings.Join(fargateCapabilities, ", "))
+		}
+
+		capabilities = append(capabilities, capability)
+	}
+	if len(capabilities) == 0 {
+		return nil, nil
+	}
+
+	return &types.KernelCapabilities{
+		Add: capabilities,
+	}, nil
+}
@@ -0,0 +1,26 @@
+package ecs
+
+import (
+	"github.com/aws/aws-sdk-go-v2/service/ecs/types"
+	"github.com/loft-sh/devpod-provider-ecs/pkg/options"
+)
+
+// getCapacityProviderStrategy converts the configured strategy for RunTask, which replaces
+// the launch type if set
+func (p *EcsProvider) getCapacityProviderStrategy() []types.CapacityProviderStrategyItem {
+	strategy := []types.CapacityProviderStrategyItem{}
+	for _, capacityProvider := range p.Config.CapacityProviderStrategy {
+		strategy = append(strategy, types.CapacityProviderStrategyItem{
+			CapacityProvider: options.Ptr(capacityProvider.Name),
+			Weight:           capacityProvider.Weight,
+			Base:             capacityProvider.Base,
+		})
+	}
+
+	return strategy
+}
+
+// isSpotInterrupted returns true if the task was stopped because its spot capacity was reclaimed
+func isSpotInterrupted(task *types.Task) bool {
+	return task.StopCode == types.TaskStopCodeSpotInterruption
+}
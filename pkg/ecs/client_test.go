@@ -0,0 +1,26 @@
+package ecs
+
+import (
+	"testing"
+
+	"github.com/aws/aws-sdk-go-v2/aws"
+	"github.com/aws/aws-sdk-go-v2/service/ecs"
+	"github.com/aws/aws-sdk-go-v2/service/iam"
+	"github.com/loft-sh/devpod-provider-ecs/pkg/options"
+)
+
+func TestNewClientsEndpoints(t *testing.T) {
+	clients := NewClients(aws.Config{Region: "us-east-1"}, &options.Options{
+		EcsEndpoint: "http://localhost:4566",
+	})
+
+	ecsEndpoint := clients.ECS.(*ecs.Client).Options().BaseEndpoint
+	if ecsEndpoint == nil || *ecsEndpoint != "http://localhost:4566" {
+		t.Fatalf("expected ecs endpoint to be overridden, got %v", ecsEndpoint)
+	}
+
+	iamEndpoint := clients.IAM.(*iam.Client).Options().BaseEndpoint
+	if iamEndpoint != nil {
+		t.Fatalf("expected default iam endpoint, got %s", *iamEndpoint)
+	}
+}
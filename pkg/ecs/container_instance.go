@@ -0,0 +1,52 @@
+package ecs
+
+import (
+	"context"
+	"fmt"
+
+	"github.com/aws/aws-sdk-go-v2/service/ecs"
+	"github.com/aws/aws-sdk-go-v2/service/ecs/types"
+	"github.com/loft-sh/devpod-provider-ecs/pkg/options"
+)
+
+// getContainerInstances returns all container instances registered in the cluster
+func (p *EcsProvider) getContainerInstances(ctx context.Context) ([]types.ContainerInstance, error) {
+	containerInstanceArns := []string{}
+	paginator := ecs.NewListContainerInstancesPaginator(p.client, &ecs.ListContainerInstancesInput{
+		Cluster: options.Ptr(p.Config.ClusterID),
+	})
+	for paginator.HasMorePages() {
+		output, err := paginator.NextPage(ctx)
+		if err != nil {
+			return nil, fmt.Errorf("list container instances: %w", err)
+		}
+
+		containerInstanceArns = append(containerInstanceArns, output.ContainerInstanceArns...)
+	}
+
+	return p.describeContainerInstances(ctx, containerInstanceArns)
+}
+
+func (p *EcsProvider) describeContainerInstances(ctx context.Context, containerInstanceArns []string) ([]types.ContainerInstance, error) {
+	containerInstances := []types.ContainerInstance{}
+	for len(containerInstanceArns) > 0 {
+		// ecs describes at most 100 container instances at once
+		batch := containerInstanceArns
+		if len(batch) > 100 {
+			batch = batch[:100]
+		}
+		containerInstanceArns = containerInstanceArns[len(batch):]
+
+		output, err := p.client.DescribeContainerInstances(ctx, &ecs.DescribeContainerInstancesInput{
+			Cluster:            options.Ptr(p.Config.ClusterID),
+			ContainerInstances: batch,
+		})
+		if err != nil {
+			return nil, fmt.Errorf("describe container instances: %w", err)
+		}
+
+		containerInstances = append(containerInstances, output.ContainerInstances...)
+	}
+
+	return containerInstances, nil
+}
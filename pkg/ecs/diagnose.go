@@ -0,0 +1,161 @@
+package ecs
+
+import (
+	"context"
+	"fmt"
+	"strings"
+
+	"github.com/aws/aws-sdk-go-v2/aws"
+	"github.com/aws/aws-sdk-go-v2/service/ecs/types"
+)
+
+// diagnoseLogLines is the number of log lines that are added to the error of a failed task
+const diagnoseLogLines = 20
+
+// diagnoseTask builds the error for a task that stopped or didn't become running. Besides
+// the stopped reason it contains the stop code, the container reasons and exit codes, the
+// last log lines if cloudwatch logs are enabled and hints on common causes.
+func (p *EcsProvider) diagnoseTask(ctx context.Context, workspaceId string, task *types.Task, message string) error {
+	details := []string{}
+	if task.StopCode != "" {
+		details = append(details, "stop code: "+string(task.StopCode))
+	}
+	for _, container := range task.Containers {
+		if container.Reason == nil && container.ExitCode == nil {
+			continue
+		}
+
+		detail := "container " + aws.ToString(container.Name) + ":"
+		if container.Reason != nil {
+			detail += " " + *container.Reason
+		}
+		if container.ExitCode != nil {
+			detail += fmt.Sprintf(" (exit code %d)", *container.ExitCode)
+		}
+		details = append(details, detail)
+	}
+
+	// the logs only exist if the container was started
+	logs := ""
+	if task.StartedAt != nil || hasExitCode(task) {
+		logs = p.getLastLogLines(ctx, workspaceId, task)
+	}
+
+	b := &strings.Builder{}
+	b.WriteString(message)
+	for _, detail := range details {
+		b.WriteString("\n  " + detail)
+	}
+	if logs != "" {
+		b.WriteString("\n  last log lines:")
+		for _, line := range strings.Split(strings.TrimRight(logs, "\n"), "\n") {
+			b.WriteString("\n    " + line)
+		}
+	}
+
+	hints := p.getHints(append([]string{message, logs}, details...)...)
+	if len(hints) == 0 && hasExitCode(task) {
+		if p.Config.CloudWatchLogs {
+			hints = append(hints, "The workspace container exited, check its output above or with the logs command.")
+		} else {
+			hints = append(hints, "The workspace container exited, set CLOUDWATCH_LOGS to ENABLED to see its output.")
+		}
+	}
+	for _, hint := range hints {
+		b.WriteString("\nHint: " + hint)
+	}
+
+	return fmt.Errorf("%s", b.String())
+}
+
+// withHints adds hints on common causes to an error returned by ECS
+func (p *EcsProvider) withHints(err error) error {
+	if err == nil {
+		return nil
+	}
+
+	hints := p.getHints(err.Error())
+	if len(hints) == 0 {
+		return err
+	}
+
+	return fmt.Errorf("%w\nHint: %s", err, strings.Join(hints, "\nHint: "))
+}
+
+func (p *EcsProvider) getLastLogLines(ctx context.Context, workspaceId string, task *types.Task) string {
+	if !p.Config.CloudWatchLogs {
+		return ""
+	}
+
+	b := &strings.Builder{}
+	err := p.printLogs(ctx, getLogStreamName(workspaceId, task), diagnoseLogLines, false, b)
+	if err != nil {
+		p.Log.Debugf("Error retrieving task logs: %v", err)
+		return ""
+	}
+
+	return b.String()
+}
+
+func hasExitCode(task *types.Task) bool {
+	for _, container := range task.Containers {
+		if container.ExitCode != nil {
+			return true
+		}
+	}
+
+	return false
+}
+
+// getHints classifies the messages of a failed task and returns an actionable hint for
+// every known cause
+func (p *EcsProvider) getHints(messages ...string) []string {
+	message := strings.ToLower(strings.Join(messages, "\n"))
+	hints := []string{}
+
+	// image pull
+	isPullError := strings.Contains(message, "cannotpullcontainer") || strings.Contains(message, "pull image") || strings.Contains(message, "resourceinitializationerror")
+	if isPullError && containsAny(message, "timeout", "timed out", "i/o timeout", "dial tcp", "context deadline exceeded", "unable to retrieve") {
+		if p.Config.AssignPublicIp != string(types.AssignPublicIpEnabled) {
+			hints = append(hints, "The task has no public ip and can't reach the registry. Set ASSIGN_PUBLIC_IP to ENABLED or route the subnets through a NAT gateway or VPC endpoints for ECR, S3 and Secrets Manager.")
+		} else {
+			hints = append(hints, "The task can't reach the registry. Make sure the subnets route to an internet gateway and the security groups allow outbound traffic.")
+		}
+	}
+	if isPullError && containsAny(message, "unauthorized", "access denied", "denied:", "authentication required", "asm fetching secret") {
+		hints = append(hints, "The image can't be pulled with the given credentials. Check REGISTRY_CREDENTIALS_SECRET_ARN and that the execution role may read the secret.")
+	} else if isPullError && containsAny(message, "manifest unknown", "not found") && !strings.Contains(message, "no matching manifest") {
+		hints = append(hints, "The image doesn't exist. Check the image name and tag of the devcontainer.")
+	}
+
+	// network interfaces and capacity
+	if containsAny(message, "network interface provisioning", "failed to configure eni", "resource:eni", "insufficientfreeaddressesinsubnet", "no free ip addresses") {
+		hints = append(hints, "No network interface could be created for the task. Make sure the subnets have free ip addresses and the account is below its network interface limit, or add more subnets to SUBNET_ID.")
+	} else if containsAny(message, "capacity is unavailable", "insufficient capacity") {
+		hints = append(hints, "The availability zones of the subnets ran out of capacity. Add subnets in other availability zones to SUBNET_ID or use a CAPACITY_PROVIDER_STRATEGY.")
+	}
+
+	// cpu and memory
+	if containsAny(message, "no fargate configuration exists", "invalid 'cpu'", "invalid 'memory'", "invalid cpu or memory") {
+		hints = append(hints, "TASK_CPU and TASK_MEMORY are not a valid combination, see https://docs.aws.amazon.com/AmazonECS/latest/developerguide/fargate-tasks-services.html#fargate-tasks-size for the supported values.")
+	} else if containsAny(message, "resource:cpu", "resource:memory") {
+		hints = append(hints, "No container instance in the cluster has enough free cpu or memory. Lower TASK_CPU and TASK_MEMORY or add container instances.")
+	}
+
+	// architecture
+	if containsAny(message, "exec format error", "no matching manifest", "image platform", "does not match the detected host platform") {
+		hints = append(hints, fmt.Sprintf("The image isn't built for the architecture of the task (CLUSTER_ARCHITECTURE=%s). Use a multi-arch image or set CLUSTER_ARCHITECTURE to the architecture of the image.", p.Config.ClusterArchitecture))
+	}
+
+	return hints
+}
+
+func containsAny(s string, substrings ...string) bool {
+	for _, substring := range substrings {
+		if strings.Contains(s, substring) {
+			return true
+		}
+	}
+
+	return false
+}
@@ -0,0 +1,104 @@
+package ecs
+
+import (
+	"context"
+	"fmt"
+	"strings"
+	"time"
+
+	"github.com/aws/aws-sdk-go-v2/service/ssm"
+	"github.com/loft-sh/devpod-provider-ecs/pkg/options"
+)
+
+// removeDockerVolumesScript removes the docker volumes with the workspace label and the
+// containers that still use them
+const removeDockerVolumesScript = `docker volume ls -q --filter %s | while read -r volume; do
+  docker ps -aq --filter "volume=$volume" | xargs -r docker rm -f >/dev/null
+  if docker volume rm "$volume" >/dev/null; then echo "removed $volume"; fi
+done`
+
+// deleteDockerVolumes removes the shared docker volumes of the workspace from the container
+// instance it is pinned to through a ssm command, as ecs never removes them by itself. The
+// volumes are selected by their workspace label, as their names are prefixes of the volume
+// names of other workspaces.
+func (p *EcsProvider) deleteDockerVolumes(ctx context.Context, workspaceId string) error {
+	taskDefinitionArn, err := p.getLatestTaskDefinition(ctx, newWorkspace(workspaceId))
+	if err != nil {
+		return err
+	} else if taskDefinitionArn == "" {
+		return nil
+	}
+
+	containerInstanceArn, err := p.getPinnedContainerInstance(ctx, taskDefinitionArn)
+	if err != nil {
+		return err
+	} else if containerInstanceArn == "" {
+		// the task never ran on a container instance
+		return nil
+	}
+
+	containerInstances, err := p.describeContainerInstances(ctx, []string{containerInstanceArn})
+	if err != nil {
+		return err
+	} else if len(containerInstances) == 0 || containerInstances[0].Ec2InstanceId == nil {
+		p.Log.Infof("Container instance %s that holds the docker volumes is gone, nothing to remove", containerInstanceArn)
+		return nil
+	}
+	instanceID := *containerInstances[0].Ec2InstanceId
+
+	p.Log.Infof("Removing docker volumes from %s...", instanceID)
+	output, err := p.ssmClient.SendCommand(ctx, &ssm.SendCommandInput{
+		DocumentName: options.Ptr("AWS-RunShellScript"),
+		Comment:      options.Ptr("Remove docker volumes of DevPod workspace " + workspaceId),
+		InstanceIds:  []string{instanceID},
+		Parameters: map[string][]string{
+			"commands": {removeDockerVolumesCommand(workspaceId)},
+		},
+	})
+	if err != nil {
+		return fmt.Errorf("send remove docker volumes command: %w", err)
+	}
+
+	volumes, err := p.getRemovedDockerVolumes(ctx, *output.Command.CommandId, instanceID)
+	if err != nil {
+		return fmt.Errorf("remove docker volumes on %s: %w", instanceID, err)
+	}
+	for _, volume := range volumes {
+		p.Log.Infof("Removed docker volume %s on %s", volume, instanceID)
+	}
+
+	p.Log.Infof("Removed %d docker volumes", len(volumes))
+	return nil
+}
+
+func removeDockerVolumesCommand(workspaceId string) string {
+	return fmt.Sprintf(removeDockerVolumesScript, shellQuote("label="+workspaceTagKey+"="+workspaceId))
+}
+
+func (p *EcsProvider) getRemovedDockerVolumes(ctx context.Context, commandID, instanceID string) ([]string, error) {
+	input := &ssm.GetCommandInvocationInput{
+		CommandId:  options.Ptr(commandID),
+		InstanceId: options.Ptr(instanceID),
+	}
+	err := ssm.NewCommandExecutedWaiter(p.ssmClient).Wait(ctx, input, time.Minute*2)
+	if err != nil {
+		return nil, err
+	}
+
+	invocation, err := p.ssmClient.GetCommandInvocation(ctx, input)
+	if err != nil {
+		return nil, err
+	}
+
+	volumes := []string{}
+	if invocation.StandardOutputContent != nil {
+		for _, line := range strings.Split(*invocation.StandardOutputContent, "\n") {
+			volume, ok := strings.CutPrefix(strings.TrimSpace(line), "removed ")
+			if ok {
+				volumes = append(volumes, volume)
+			}
+		}
+	}
+
+	return volumes, nil
+}
@@ -0,0 +1,99 @@
+package ecs
+
+import (
+	"context"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"slices"
+	"strings"
+	"testing"
+
+	"github.com/aws/aws-sdk-go-v2/service/ecs/types"
+	"github.com/loft-sh/devpod-provider-ecs/pkg/options"
+)
+
+// fakeDocker implements the docker commands of the remove script on the volumes in
+// $DOCKER_STATE/volumes, one "<name> <label>,<label>" per line
+const fakeDocker = `#!/bin/sh
+case "$1 $2" in
+"volume ls")
+  label="${5#label=}"
+  while read -r name labels; do
+    case ",$labels," in *",$label,"*) echo "$name";; esac
+  done < "$DOCKER_STATE/volumes"
+  ;;
+"volume rm")
+  echo "$3" >> "$DOCKER_STATE/removed"
+  ;;
+esac
+`
+
+func TestDeleteDockerVolumes(t *testing.T) {
+	if _, err := exec.LookPath("sh"); err != nil {
+		t.Skip("sh is not installed")
+	}
+
+	env := newTestEnv(t, func(o *options.Options) {
+		o.LaunchType = string(types.LaunchTypeEc2)
+	})
+	env.ecs.ContainerInstances = []types.ContainerInstance{
+		testContainerInstance("instance-1", "i-1", "x86_64"),
+		testContainerInstance("instance-2", "i-2", "x86_64"),
+	}
+	ctx := context.Background()
+
+	state := t.TempDir()
+	err := os.WriteFile(filepath.Join(state, "docker"), []byte(fakeDocker), 0755)
+	if err != nil {
+		t.Fatal(err)
+	}
+	err = os.WriteFile(filepath.Join(state, "volumes"), []byte(strings.Join([]string{
+		"devpod-foo devpod-workspace-id=foo",
+		"devpod-foo-ab12c other=label,devpod-workspace-id=foo",
+		"devpod-foo-cafe1 devpod-workspace-id=foo-cafe1",
+		"devpod-foo-cafe1-ab12c devpod-workspace-id=foo-cafe1",
+		"devpod-foo-12345 -",
+	}, "\n")+"\n"), 0644)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	instanceIDs := []string{}
+	env.ssm.RunCommand = func(instanceID string, commands []string) string {
+		instanceIDs = append(instanceIDs, instanceID)
+
+		cmd := exec.Command("sh", "-c", strings.Join(commands, "\n"))
+		cmd.Env = append(os.Environ(), "PATH="+state+string(os.PathListSeparator)+os.Getenv("PATH"), "DOCKER_STATE="+state)
+		out, err := cmd.CombinedOutput()
+		if err != nil {
+			t.Errorf("run remove script: %v: %s", err, out)
+		}
+		return string(out)
+	}
+
+	err = env.provider.RunTask(ctx, "foo", testRunOptions())
+	if err != nil {
+		t.Fatalf("run task: %v", err)
+	}
+	for _, volume := range activeTaskDefinitions(env.ecs)[0].Volumes {
+		if labels := volume.DockerVolumeConfiguration.Labels; labels[workspaceTagKey] != "foo" {
+			t.Fatalf("expected volume %s to be labeled with the workspace, got %v", *volume.Name, labels)
+		}
+	}
+
+	err = env.provider.DeleteTask(ctx, "foo")
+	if err != nil {
+		t.Fatalf("delete task: %v", err)
+	}
+
+	if !slices.Equal(instanceIDs, []string{"i-1"}) {
+		t.Fatalf("expected the command to only run on the pinned instance, got %v", instanceIDs)
+	}
+	removed, err := os.ReadFile(filepath.Join(state, "removed"))
+	if err != nil {
+		t.Fatal(err)
+	} else if string(removed) != "devpod-foo\ndevpod-foo-ab12c\n" {
+		t.Fatalf("expected only the volumes of workspace foo to be removed, got %q", removed)
+	}
+}
@@ -0,0 +1,296 @@
+package ecs
+
+import (
+	"context"
+	"fmt"
+	"sort"
+	"time"
+
+	"github.com/aws/aws-sdk-go-v2/service/ec2"
+	ec2types "github.com/aws/aws-sdk-go-v2/service/ec2/types"
+	"github.com/aws/aws-sdk-go-v2/service/ecs/types"
+	"github.com/loft-sh/devpod-provider-ecs/pkg/options"
+)
+
+func (p *EcsProvider) getEbsVolumeConfiguration(ctx context.Context, workspaceId string) (types.TaskVolumeConfiguration, error) {
+	// make sure we have a value for the infrastructure role arn
+	if p.Config.EbsInfrastructureRoleARN == "" {
+		roleArn, err := p.createInfrastructureRole(ctx)
+		if err != nil {
+			return types.TaskVolumeConfiguration{}, err
+		}
+
+		p.Config.EbsInfrastructureRoleARN = roleArn
+	}
+
+	volumeConfiguration := &types.TaskManagedEBSVolumeConfiguration{
+		RoleArn:        options.Ptr(p.Config.EbsInfrastructureRoleARN),
+		SizeInGiB:      options.Ptr(p.Config.EbsVolumeSize),
+		VolumeType:     options.Ptr(p.Config.EbsVolumeType),
+		FilesystemType: types.TaskFilesystemTypeExt4,
+		TagSpecifications: []types.EBSTagSpecification{
+			{
+				ResourceType: types.EBSResourceTypeVolume,
+				Tags: append(newWorkspace(workspaceId).Tags(), types.Tag{
+					Key:   options.Ptr(clusterTagKey),
+					Value: options.Ptr(p.Config.ClusterID),
+				}),
+			},
+		},
+		TerminationPolicy: &types.TaskManagedEBSVolumeTerminationPolicy{
+			DeleteOnTermination: options.Ptr(false),
+		},
+	}
+	if p.Config.EbsIops > 0 {
+		volumeConfiguration.Iops = options.Ptr(p.Config.EbsIops)
+	}
+	if p.Config.EbsThroughput > 0 {
+		volumeConfiguration.Throughput = options.Ptr(p.Config.EbsThroughput)
+	}
+	if p.Config.EbsKmsKeyID != "" {
+		volumeConfiguration.Encrypted = options.Ptr(true)
+		volumeConfiguration.KmsKeyId = options.Ptr(p.Config.EbsKmsKeyID)
+	}
+
+	// snapshot volumes left behind by a task that was not stopped through devpod
+	err := p.snapshotEbsVolumes(ctx, workspaceId)
+	if err != nil {
+		return types.TaskVolumeConfiguration{}, err
+	}
+
+	// restore from the latest snapshot if there is one
+	snapshots, err := p.getEbsSnapshots(ctx, workspaceId)
+	if err != nil {
+		return types.TaskVolumeConfiguration{}, err
+	}
+	for _, snapshot := range snapshots {
+		if snapshot.State == ec2types.SnapshotStateCompleted {
+			p.Log.Infof("Restoring workspace volume from snapshot %s...", *snapshot.SnapshotId)
+			volumeConfiguration.SnapshotId = snapshot.SnapshotId
+			break
+		}
+	}
+
+	return types.TaskVolumeConfiguration{
+		Name:             options.Ptr("devpod-" + workspaceId),
+		ManagedEBSVolume: volumeConfiguration,
+	}, nil
+}
+
+// snapshotEbsVolumes snapshots the detached workspace volumes and deletes them afterwards, so
+// that the next start can restore the workspace from the snapshot
+func (p *EcsProvider) snapshotEbsVolumes(ctx context.Context, workspaceId string) error {
+	volumes, err := p.waitForEbsVolumes(ctx, workspaceId)
+	if err != nil {
+		return err
+	} else if len(volumes) == 0 {
+		return nil
+	}
+
+	for _, volume := range volumes {
+		p.Log.Infof("Creating snapshot of volume %s...", *volume.VolumeId)
+		snapshot, err := p.ec2Client.CreateSnapshot(ctx, &ec2.CreateSnapshotInput{
+			VolumeId:    volume.VolumeId,
+			Description: options.Ptr("DevPod workspace " + workspaceId),
+			TagSpecifications: []ec2types.TagSpecification{
+				{
+					ResourceType: ec2types.ResourceTypeSnapshot,
+					Tags:         p.getEc2Tags(workspaceId),
+				},
+			},
+		})
+		if err != nil {
+			return fmt.Errorf("create snapshot of volume %s: %w", *volume.VolumeId, err)
+		}
+
+		p.Log.Infof("Waiting for snapshot %s to complete...", *snapshot.SnapshotId)
+		err = ec2.NewSnapshotCompletedWaiter(p.ec2Client).Wait(ctx, &ec2.DescribeSnapshotsInput{
+			SnapshotIds: []string{*snapshot.SnapshotId},
+		}, time.Hour)
+		if err != nil {
+			return fmt.Errorf("wait for snapshot %s: %w", *snapshot.SnapshotId, err)
+		}
+
+		p.Log.Infof("Deleting volume %s...", *volume.VolumeId)
+		_, err = p.ec2Client.DeleteVolume(ctx, &ec2.DeleteVolumeInput{
+			VolumeId: volume.VolumeId,
+		})
+		if err != nil {
+			return fmt.Errorf("delete volume %s: %w", *volume.VolumeId, err)
+		}
+	}
+
+	// only keep the latest snapshot
+	snapshots, err := p.getEbsSnapshots(ctx, workspaceId)
+	if err != nil {
+		return err
+	} else if len(snapshots) > 1 {
+		return p.deleteEbsSnapshots(ctx, snapshots[1:])
+	}
+
+	return nil
+}
+
+// deleteEbsVolumes deletes all workspace volumes and, if withSnapshots is set, all of their snapshots
+func (p *EcsProvider) deleteEbsVolumes(ctx context.Context, workspaceId string, withSnapshots bool) error {
+	volumes, err := p.waitForEbsVolumes(ctx, workspaceId)
+	if err != nil {
+		return err
+	}
+
+	for _, volume := range volumes {
+		p.Log.Infof("Deleting volume %s...", *volume.VolumeId)
+		_, err = p.ec2Client.DeleteVolume(ctx, &ec2.DeleteVolumeInput{
+			VolumeId: volume.VolumeId,
+		})
+		if err != nil {
+			return fmt.Errorf("delete volume %s: %w", *volume.VolumeId, err)
+		}
+	}
+	if !withSnapshots {
+		return nil
+	}
+
+	snapshots, err := p.getEbsSnapshots(ctx, workspaceId)
+	if err != nil {
+		return err
+	}
+
+	return p.deleteEbsSnapshots(ctx, snapshots)
+}
+
+func (p *EcsProvider) deleteEbsSnapshots(ctx context.Context, snapshots []ec2types.Snapshot) error {
+	for _, snapshot := range snapshots {
+		p.Log.Infof("Deleting snapshot %s...", *snapshot.SnapshotId)
+		_, err := p.ec2Client.DeleteSnapshot(ctx, &ec2.DeleteSnapshotInput{
+			SnapshotId: snapshot.SnapshotId,
+		})
+		if err != nil {
+			return fmt.Errorf("delete snapshot %s: %w", *snapshot.SnapshotId, err)
+		}
+	}
+
+	return nil
+}
+
+// waitForEbsVolumes waits until ecs has detached all workspace volumes from the stopped task
+func (p *EcsProvider) waitForEbsVolumes(ctx context.Context, workspaceId string) ([]ec2types.Volume, error) {
+	volumes, err := p.getEbsVolumes(ctx, workspaceId)
+	if err != nil {
+		return nil, err
+	}
+
+	volumeIDs := []string{}
+	for _, volume := range volumes {
+		if volume.State != ec2types.VolumeStateAvailable {
+			volumeIDs = append(volumeIDs, *volume.VolumeId)
+		}
+	}
+	if len(volumeIDs) == 0 {
+		return volumes, nil
+	}
+
+	p.Log.Infof("Waiting for volumes to be detached...")
+	err = ec2.NewVolumeAvailableWaiter(p.ec2Client).Wait(ctx, &ec2.DescribeVolumesInput{
+		VolumeIds: volumeIDs,
+	}, time.Minute*10)
+	if err != nil {
+		return nil, fmt.Errorf("wait for volumes to be detached: %w", err)
+	}
+
+	return p.getEbsVolumes(ctx, workspaceId)
+}
+
+func (p *EcsProvider) getEbsVolumes(ctx context.Context, workspaceId string) ([]ec2types.Volume, error) {
+	volumes := []ec2types.Volume{}
+	paginator := ec2.NewDescribeVolumesPaginator(p.ec2Client, &ec2.DescribeVolumesInput{
+		Filters: getEc2Filters(workspaceId),
+	})
+	for paginator.HasMorePages() {
+		output, err := paginator.NextPage(ctx)
+		if err != nil {
+			return nil, fmt.Errorf("list volumes: %w", err)
+		}
+
+		for _, volume := range output.Volumes {
+			if volume.State == ec2types.VolumeStateDeleting || volume.State == ec2types.VolumeStateDeleted {
+				continue
+			} else if !p.ownsEc2Resource(volume.Tags) {
+				continue
+			}
+
+			volumes = append(volumes, volume)
+		}
+	}
+
+	return volumes, nil
+}
+
+// getEbsSnapshots returns the snapshots of the workspace, newest first
+func (p *EcsProvider) getEbsSnapshots(ctx context.Context, workspaceId string) ([]ec2types.Snapshot, error) {
+	snapshots := []ec2types.Snapshot{}
+	paginator := ec2.NewDescribeSnapshotsPaginator(p.ec2Client, &ec2.DescribeSnapshotsInput{
+		OwnerIds: []string{"self"},
+		Filters:  getEc2Filters(workspaceId),
+	})
+	for paginator.HasMorePages() {
+		output, err := paginator.NextPage(ctx)
+		if err != nil {
+			return nil, fmt.Errorf("list snapshots: %w", err)
+		}
+
+		for _, snapshot := range output.Snapshots {
+			if p.ownsEc2Resource(snapshot.Tags) {
+				snapshots = append(snapshots, snapshot)
+			}
+		}
+	}
+
+	sort.SliceStable(snapshots, func(i, j int) bool {
+		return snapshots[i].StartTime.After(*snapshots[j].StartTime)
+	})
+
+	return snapshots, nil
+}
+
+// getEc2Filters matches the volumes and snapshots of the workspace id on any cluster, use
+// ownsEc2Resource to only keep the ones of this cluster
+func getEc2Filters(workspaceId string) []ec2types.Filter {
+	return []ec2types.Filter{
+		{
+			Name:   options.Ptr("tag:devpod-workspace-id"),
+			Values: []string{workspaceId},
+		},
+	}
+}
+
+func (p *EcsProvider) getEc2Tags(workspaceId string) []ec2types.Tag {
+	return []ec2types.Tag{
+		{
+			Key:   options.Ptr("devpod-workspace-id"),
+			Value: options.Ptr(workspaceId),
+		},
+		{
+			Key:   options.Ptr(clusterTagKey),
+			Value: options.Ptr(p.Config.ClusterID),
+		},
+	}
+}
+
+// ownsEc2Resource returns true if the volume or snapshot belongs to the cluster. Resources
+// without a cluster tag were created by an older version and are still used, they are
+// replaced by tagged snapshots on the next stop.
+func (p *EcsProvider) ownsEc2Resource(tags []ec2types.Tag) bool {
+	cluster := getEc2Tag(tags, clusterTagKey)
+	return cluster == "" || cluster == p.Config.ClusterID
+}
+
+func getEc2Tag(tags []ec2types.Tag, key string) string {
+	for _, tag := range tags {
+		if tag.Key != nil && *tag.Key == key && tag.Value != nil {
+			return *tag.Value
+		}
+	}
+
+	return ""
+}
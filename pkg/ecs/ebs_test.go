@@ -0,0 +1,142 @@
+package ecs
+
+import (
+	"context"
+	"testing"
+
+	"github.com/aws/aws-sdk-go-v2/aws"
+	"github.com/aws/aws-sdk-go-v2/service/ec2"
+	ec2types "github.com/aws/aws-sdk-go-v2/service/ec2/types"
+	"github.com/aws/aws-sdk-go-v2/service/ecs/types"
+	"github.com/loft-sh/devpod-provider-ecs/pkg/options"
+)
+
+func testEbsTags(workspaceId, cluster string) []ec2types.Tag {
+	tags := []ec2types.Tag{{Key: options.Ptr("devpod-workspace-id"), Value: options.Ptr(workspaceId)}}
+	if cluster != "" {
+		tags = append(tags, ec2types.Tag{Key: options.Ptr(clusterTagKey), Value: options.Ptr(cluster)})
+	}
+
+	return tags
+}
+
+func TestEbsVolumesOfOtherClusters(t *testing.T) {
+	env := newTestEnv(t, func(o *options.Options) {
+		o.EbsVolumeSize = 10
+	})
+	ctx := context.Background()
+
+	// the same workspace id on this cluster, on another cluster and from an older version
+	for volumeID, cluster := range map[string]string{"vol-own": "devpod", "vol-other": "other", "vol-legacy": ""} {
+		env.ec2.AddVolume(ec2types.Volume{
+			VolumeId: options.Ptr(volumeID),
+			State:    ec2types.VolumeStateAvailable,
+			Tags:     testEbsTags("api", cluster),
+		})
+	}
+	_, err := env.ec2.CreateSnapshot(ctx, &ec2.CreateSnapshotInput{
+		VolumeId: options.Ptr("vol-other"),
+		TagSpecifications: []ec2types.TagSpecification{
+			{ResourceType: ec2types.ResourceTypeSnapshot, Tags: testEbsTags("api", "other")},
+		},
+	})
+	if err != nil {
+		t.Fatalf("create snapshot: %v", err)
+	}
+
+	// stopping snapshots the own and the legacy volume and tags the snapshots with the cluster
+	err = env.provider.snapshotEbsVolumes(ctx, "api")
+	if err != nil {
+		t.Fatalf("snapshot volumes: %v", err)
+	}
+	snapshots, err := env.provider.getEbsSnapshots(ctx, "api")
+	if err != nil {
+		t.Fatalf("get snapshots: %v", err)
+	} else if len(snapshots) != 1 || getEc2Tag(snapshots[0].Tags, clusterTagKey) != "devpod" {
+		t.Fatalf("expected 1 snapshot of this cluster, got %v", snapshots)
+	}
+
+	err = env.provider.deleteEbsVolumes(ctx, "api", true)
+	if err != nil {
+		t.Fatalf("delete volumes: %v", err)
+	}
+
+	volumes := env.ec2.Volumes()
+	if len(volumes) != 1 || *volumes[0].VolumeId != "vol-other" {
+		t.Fatalf("expected only the volume of the other cluster to be kept, got %v", volumes)
+	}
+	snapshots = env.ec2.Snapshots()
+	if len(snapshots) != 1 || *snapshots[0].VolumeId != "vol-other" {
+		t.Fatalf("expected only the snapshot of the other cluster to be kept, got %v", snapshots)
+	}
+}
+
+func TestEbsVolumeRestoredFromSnapshot(t *testing.T) {
+	env := newTestEnv(t, func(o *options.Options) {
+		o.EbsVolumeSize = 10
+	})
+	ctx := context.Background()
+
+	// ecs creates a volume for every task, which is kept after the task stopped
+	env.ecs.OnRunTask = func(task types.Task) {
+		env.ec2.AddVolume(ec2types.Volume{
+			State: ec2types.VolumeStateAvailable,
+			Tags:  testEbsTags("workspace", "devpod"),
+		})
+	}
+	restoredSnapshotId := func() string {
+		runTaskInput := env.ecs.RunTaskInputs[len(env.ecs.RunTaskInputs)-1]
+		if len(runTaskInput.VolumeConfigurations) != 1 || runTaskInput.VolumeConfigurations[0].ManagedEBSVolume == nil {
+			t.Fatalf("expected 1 ebs volume configuration, got %v", runTaskInput.VolumeConfigurations)
+		}
+
+		return aws.ToString(runTaskInput.VolumeConfigurations[0].ManagedEBSVolume.SnapshotId)
+	}
+
+	err := env.provider.RunTask(ctx, "workspace", testRunOptions())
+	if err != nil {
+		t.Fatalf("run task: %v", err)
+	} else if snapshotId := restoredSnapshotId(); snapshotId != "" {
+		t.Fatalf("expected a new volume, got one restored from %s", snapshotId)
+	}
+
+	// stopping snapshots the volume and deletes it
+	err = env.provider.StopTask(ctx, "workspace")
+	if err != nil {
+		t.Fatalf("stop task: %v", err)
+	}
+	snapshots := env.ec2.Snapshots()
+	if len(snapshots) != 1 {
+		t.Fatalf("expected 1 snapshot, got %v", snapshots)
+	} else if volumes := env.ec2.Volumes(); len(volumes) != 0 {
+		t.Fatalf("expected the volume to be deleted, got %v", volumes)
+	}
+	firstSnapshotId := *snapshots[0].SnapshotId
+
+	// starting restores the volume from the snapshot
+	err = env.provider.StartTask(ctx, "workspace")
+	if err != nil {
+		t.Fatalf("start task: %v", err)
+	} else if snapshotId := restoredSnapshotId(); snapshotId != firstSnapshotId {
+		t.Fatalf("expected the volume to be restored from %s, got %q", firstSnapshotId, snapshotId)
+	}
+
+	// stopping again only keeps the new snapshot
+	err = env.provider.StopTask(ctx, "workspace")
+	if err != nil {
+		t.Fatalf("stop task: %v", err)
+	}
+	snapshots = env.ec2.Snapshots()
+	if len(snapshots) != 1 {
+		t.Fatalf("expected 1 snapshot, got %v", snapshots)
+	} else if *snapshots[0].SnapshotId == firstSnapshotId {
+		t.Fatalf("expected the old snapshot %s to be deleted", firstSnapshotId)
+	}
+
+	err = env.provider.StartTask(ctx, "workspace")
+	if err != nil {
+		t.Fatalf("start task: %v", err)
+	} else if snapshotId := restoredSnapshotId(); snapshotId != *snapshots[0].SnapshotId {
+		t.Fatalf("expected the volume to be restored from %s, got %q", *snapshots[0].SnapshotId, snapshotId)
+	}
+}
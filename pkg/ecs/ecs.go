@@ -18,10 +18,18 @@ import (
 	"github.com/loft-sh/log"
 )
 
-func NewProvider(ctx context.Context, options *options.Options, logs log.Logger) (*EcsProvider, error) {
-	cfg, err := awsConfig.LoadDefaultConfig(ctx)
-	if err != nil {
-		return nil, err
+// NewProvider creates a new provider. If clients is nil, the AWS clients are created from
+// the default AWS config
+func NewProvider(ctx context.Context, options *options.Options, logs log.Logger, clients *Clients) (*EcsProvider, error) {
+	cfg := aws.Config{}
+	if clients == nil {
+		var err error
+		cfg, err = awsConfig.LoadDefaultConfig(ctx)
+		if err != nil {
+			return nil, err
+		}
+
+		clients = NewClients(cfg, options)
 	}
 
 	// create provider
@@ -30,7 +38,14 @@ func NewProvider(ctx context.Context, options *options.Options, logs log.Logger)
 		AwsConfig: cfg,
 		Log:       logs,
 
-		client: ecs.NewFromConfig(cfg),
+		client:     clients.ECS,
+		iamClient:  clients.IAM,
+		ssmClient:  clients.SSM,
+		efsClient:  clients.EFS,
+		ec2Client:  clients.EC2,
+		logsClient: clients.Logs,
+
+		pollInterval: time.Second,
 	}
 
 	return provider, nil
@@ -41,30 +56,50 @@ type EcsProvider struct {
 	AwsConfig aws.Config
 	Log       log.Logger
 
-	client *ecs.Client
-}
+	client     ECSAPI
+	iamClient  IAMAPI
+	ssmClient  SSMAPI
+	efsClient  EFSAPI
+	ec2Client  EC2API
+	logsClient LogsAPI
 
-func (p *EcsProvider) TargetArchitecture(ctx context.Context, workspaceId string) (string, error) {
-	return p.Config.ClusterArchitecture, nil
+	pollInterval time.Duration
 }
 
 func (p *EcsProvider) StartTask(ctx context.Context, workspaceId string) error {
-	// noop operation if running on fargate
-	if p.Config.LaunchType == string(types.LaunchTypeFargate) {
+	// check if the task is already running
+	task, err := p.getTaskID(ctx, workspaceId)
+	if err != nil {
+		return err
+	} else if task != nil && !isTaskStopped(task) {
 		return nil
+	} else if task != nil && isSpotInterrupted(task) {
+		p.Log.Infof("Relaunching task that was interrupted by a Spot capacity reclaim")
 	}
 
+	// run a new task from the existing task definition
 	return p.startTask(ctx, workspaceId)
 }
 
 func (p *EcsProvider) StopTask(ctx context.Context, workspaceId string) error {
-	// noop operation if running on fargate
-	if p.Config.LaunchType == string(types.LaunchTypeFargate) {
-		return nil
+	// without volumes the workspace lives in the ephemeral storage of the fargate task, which
+	// is gone once the task stopped
+	if !p.hasVolumes() {
+		return errors.New("stopping would delete the workspace, as Fargate tasks keep it in their ephemeral storage. Set EFS_FILE_SYSTEM_ID or EBS_VOLUME_SIZE to stop workspaces or delete the workspace instead")
 	}
 
-	// stop the task
-	return p.stopTask(ctx, workspaceId)
+	// stop the task, this keeps the task definition and volumes around
+	err := p.stopTask(ctx, workspaceId)
+	if err != nil {
+		return err
+	}
+
+	// snapshot the ebs volume, so we can restore it on the next start
+	if p.Config.EbsVolumeSize > 0 {
+		return p.snapshotEbsVolumes(ctx, workspaceId)
+	}
+
+	return nil
 }
 
 func (p *EcsProvider) stopTask(ctx context.Context, workspaceId string) error {
@@ -72,7 +107,7 @@ func (p *EcsProvider) stopTask(ctx context.Context, workspaceId string) error {
 	task, err := p.getTaskID(ctx, workspaceId)
 	if err != nil {
 		return err
-	} else if task != nil {
+	} else if task != nil && !isTaskStopped(task) {
 		// delete the task
 		p.Log.Infof("Stopping task...")
 		_, err = p.client.StopTask(ctx, &ecs.StopTaskInput{
@@ -84,31 +119,85 @@ func (p *EcsProvider) stopTask(ctx context.Context, workspaceId string) error {
 		}
 	}
 
+	// wait until the task is actually stopped, so its volumes and network interface are released
+	if task != nil && strings.ToUpper(aws.ToString(task.LastStatus)) != string(types.DesiredStatusStopped) {
+		p.Log.Infof("Waiting for Task to be stopped...")
+		_, err = p.waitForTask(ctx, *task.TaskArn, func(task *types.Task) bool {
+			return task == nil || strings.ToUpper(aws.ToString(task.LastStatus)) == string(types.DesiredStatusStopped)
+		})
+		if errors.Is(err, errWaitTimeout) {
+			return fmt.Errorf("timed out after %s waiting for task to be stopped", p.Config.StartTimeout)
+		} else if err != nil {
+			return err
+		}
+	}
+
 	return nil
 }
 
 func (p *EcsProvider) RunTask(ctx context.Context, workspaceId string, runOptions *driver.RunOptions) error {
-	err := p.registerTaskDefinition(ctx, workspaceId, runOptions)
+	// a failed update of an existing workspace keeps its task definition and task
+	existing, err := p.listTaskDefinitions(ctx, newWorkspace(workspaceId), types.TaskDefinitionStatusActive)
 	if err != nil {
 		return err
 	}
 
+	err = p.registerTaskDefinition(ctx, workspaceId, runOptions)
+	if err != nil {
+		if len(existing) == 0 {
+			p.rollback(ctx, workspaceId)
+		}
+		return p.withHints(err)
+	}
+
 	err = p.startTask(ctx, workspaceId)
+	if err == nil {
+		err = p.copyBindMounts(ctx, workspaceId, runOptions)
+	}
 	if err != nil {
-		_ = p.stopTask(ctx, workspaceId)
-		_ = p.deleteTaskDefinition(ctx, workspaceId)
+		p.rollback(ctx, workspaceId)
 		return err
 	}
 
 	return nil
 }
 
+// rollback removes the task, volumes, task definition and execution role created by a
+// failed or cancelled run. It uses a context that isn't cancelled with ctx, so an interrupted
+// run cleans up too.
+func (p *EcsProvider) rollback(ctx context.Context, workspaceId string) {
+	if ctx.Err() != nil {
+		p.Log.Infof("Run was cancelled, removing the task and task definition...")
+	}
+	ctx = context.WithoutCancel(ctx)
+
+	err := p.stopTask(ctx, workspaceId)
+	if err != nil {
+		p.Log.Warnf("Error stopping task: %v", err)
+	}
+	if p.Config.EbsVolumeSize > 0 {
+		err = p.deleteEbsVolumes(ctx, workspaceId, false)
+		if err != nil {
+			p.Log.Warnf("Error deleting ebs volumes: %v", err)
+		}
+	}
+	err = p.deleteTaskDefinitions(ctx, newWorkspace(workspaceId))
+	if err != nil {
+		p.Log.Warnf("Error deleting task definition: %v", err)
+	}
+	err = p.deleteExecutionRole(ctx, workspaceId)
+	if err != nil {
+		p.Log.Warnf("Error deleting execution role: %v", err)
+	}
+}
+
 func (p *EcsProvider) FindTask(ctx context.Context, workspaceId string) (*config.ContainerDetails, error) {
 	task, err := p.getTaskID(ctx, workspaceId)
 	if err != nil {
 		return nil, err
 	} else if task == nil {
-		return nil, nil
+		// ecs only keeps stopped tasks for a short time, so fall back to the task definition
+		return p.findStoppedTask(ctx, workspaceId)
 	}
 
 	// get labels
@@ -122,7 +211,11 @@ func (p *EcsProvider) FindTask(ctx context.Context, workspaceId string) (*config
 
 	// status
 	status := "created"
-	if task.LastStatus != nil && strings.ToUpper(*task.LastStatus) == string(types.DesiredStatusRunning) {
+	if isSpotInterrupted(task) {
+		// report the task as exited right away, so it gets relaunched on start
+		p.Log.Warnf("Task %s was interrupted by a Spot capacity reclaim: %s", *task.TaskArn, aws.ToString(task.StoppedReason))
+		status = "exited"
+	} else if task.LastStatus != nil && strings.ToUpper(*task.LastStatus) == string(types.DesiredStatusRunning) {
 		status = "running"
 	} else if task.LastStatus != nil && strings.ToUpper(*task.LastStatus) == string(types.DesiredStatusStopped) {
 		status = "exited"
@@ -147,6 +240,38 @@ func (p *EcsProvider) FindTask(ctx context.Context, workspaceId string) (*config
 	}, nil
 }
 
+func (p *EcsProvider) findStoppedTask(ctx context.Context, workspaceId string) (*config.ContainerDetails, error) {
+	arn, err := p.getLatestTaskDefinition(ctx, newWorkspace(workspaceId))
+	if err != nil {
+		return nil, err
+	} else if arn == "" {
+		return nil, nil
+	}
+
+	taskDefinition, err := p.client.DescribeTaskDefinition(ctx, &ecs.DescribeTaskDefinitionInput{
+		TaskDefinition: options.Ptr(arn),
+	})
+	if err != nil {
+		return nil, fmt.Errorf("describe task definition: %w", err)
+	}
+
+	created := ""
+	if taskDefinition.TaskDefinition.RegisteredAt != nil {
+		created = taskDefinition.TaskDefinition.RegisteredAt.String()
+	}
+
+	return &config.ContainerDetails{
+		ID:      *taskDefinition.TaskDefinition.TaskDefinitionArn,
+		Created: created,
+		State: config.ContainerDetailsState{
+			Status: "exited",
+		},
+		Config: config.ContainerDetailsConfig{
+			Labels: taskDefinition.TaskDefinition.ContainerDefinitions[0].DockerLabels,
+		},
+	}, nil
+}
+
 func (p *EcsProvider) DeleteTask(ctx context.Context, workspaceId string) error {
 	// stop the task
 	err := p.stopTask(ctx, workspaceId)
@@ -154,10 +279,43 @@ func (p *EcsProvider) DeleteTask(ctx context.Context, workspaceId string) error
 		return err
 	}
 
-	// TODO: delete ecs volume?
+	// delete docker volumes on the container instances
+	if p.hasDockerVolumes() {
+		err = p.deleteDockerVolumes(ctx, workspaceId)
+		if err != nil {
+			p.Log.Warnf("Error removing docker volumes, please make sure the container instances are managed by ssm: %v", err)
+		}
+
+		err = p.deleteWorkspaceAttributes(ctx, workspaceId)
+		if err != nil {
+			return err
+		}
+	}
+
+	// delete ebs volumes and snapshots
+	if p.Config.EbsVolumeSize > 0 {
+		err = p.deleteEbsVolumes(ctx, workspaceId, true)
+		if err != nil {
+			return err
+		}
+	}
+
+	// delete efs access points
+	if p.Config.EfsFileSystemID != "" {
+		err = p.deleteAccessPoints(ctx, workspaceId)
+		if err != nil {
+			return err
+		}
+	}
 
 	// delete task definition
-	err = p.deleteTaskDefinition(ctx, workspaceId)
+	err = p.deleteTaskDefinitions(ctx, newWorkspace(workspaceId))
+	if err != nil {
+		return err
+	}
+
+	// delete the execution role of the workspace
+	err = p.deleteExecutionRole(ctx, workspaceId)
 	if err != nil {
 		return err
 	}
@@ -165,55 +323,96 @@ func (p *EcsProvider) DeleteTask(ctx context.Context, workspaceId string) error
 	return nil
 }
 
+// getTaskID returns the newest task of the workspace that should be running or, if there is
+// none, the newest stopped task ecs still knows about
 func (p *EcsProvider) getTaskID(ctx context.Context, workspaceId string) (*types.Task, error) {
-	runningTaskArns, err := p.client.ListTasks(ctx, &ecs.ListTasksInput{
-		Cluster:       options.Ptr(p.Config.ClusterID),
-		Family:        options.Ptr("devpod-" + workspaceId),
-		DesiredStatus: types.DesiredStatusRunning,
-		MaxResults:    options.Ptr(int32(10)),
-	})
+	family := newWorkspace(workspaceId).Family
+	taskArns, err := p.listTaskArns(ctx, family, types.DesiredStatusRunning)
 	if err != nil {
 		return nil, fmt.Errorf("list running tasks: %w", err)
 	}
 
 	// search stopped if there is no desired running
-	taskArns := runningTaskArns.TaskArns
 	if len(taskArns) == 0 {
-		stoppedTaskArns, err := p.client.ListTasks(ctx, &ecs.ListTasksInput{
-			Cluster:       options.Ptr(p.Config.ClusterID),
-			Family:        options.Ptr("devpod-" + workspaceId),
-			DesiredStatus: types.DesiredStatusStopped,
-			MaxResults:    options.Ptr(int32(10)),
-		})
+		taskArns, err = p.listTaskArns(ctx, family, types.DesiredStatusStopped)
 		if err != nil {
 			return nil, fmt.Errorf("list stopped tasks: %w", err)
 		}
-
-		taskArns = stoppedTaskArns.TaskArns
 	}
 	if len(taskArns) == 0 {
 		return nil, nil
 	}
 
 	// get tasks
-	tasks, err := p.client.DescribeTasks(ctx, &ecs.DescribeTasksInput{
-		Tasks:   taskArns,
-		Cluster: options.Ptr(p.Config.ClusterID),
-	})
+	tasks, err := p.describeTasks(ctx, taskArns)
 	if err != nil {
-		return nil, fmt.Errorf("describe tasks: %w", err)
-	} else if len(tasks.Failures) > 0 {
-		return nil, fmt.Errorf("describe tasks failures: %s", *tasks.Failures[0].Reason)
-	} else if len(tasks.Tasks) == 0 {
+		return nil, err
+	} else if len(tasks) == 0 {
 		return nil, nil
 	}
 
-	// sort tasks by revision
-	sort.SliceStable(tasks.Tasks, func(i, j int) bool {
-		return tasks.Tasks[i].CreatedAt.Unix() > tasks.Tasks[j].CreatedAt.Unix()
+	// newest first, the arn breaks ties so the result doesn't depend on the listing order
+	sort.SliceStable(tasks, func(i, j int) bool {
+		if !tasks[i].CreatedAt.Equal(*tasks[j].CreatedAt) {
+			return tasks[i].CreatedAt.After(*tasks[j].CreatedAt)
+		}
+
+		return *tasks[i].TaskArn > *tasks[j].TaskArn
 	})
 
-	return &tasks.Tasks[0], nil
+	return &tasks[0], nil
+}
+
+func (p *EcsProvider) listTaskArns(ctx context.Context, family string, desiredStatus types.DesiredStatus) ([]string, error) {
+	taskArns := []string{}
+	input := &ecs.ListTasksInput{
+		Cluster:       options.Ptr(p.Config.ClusterID),
+		DesiredStatus: desiredStatus,
+	}
+	if family != "" {
+		input.Family = options.Ptr(family)
+	}
+	paginator := ecs.NewListTasksPaginator(p.client, input)
+	for paginator.HasMorePages() {
+		output, err := paginator.NextPage(ctx)
+		if err != nil {
+			return nil, err
+		}
+
+		taskArns = append(taskArns, output.TaskArns...)
+	}
+
+	return taskArns, nil
+}
+
+func (p *EcsProvider) describeTasks(ctx context.Context, taskArns []string) ([]types.Task, error) {
+	tasks := []types.Task{}
+	for len(taskArns) > 0 {
+		// ecs describes at most 100 tasks at once
+		batch := taskArns
+		if len(batch) > 100 {
+			batch = batch[:100]
+		}
+		taskArns = taskArns[len(batch):]
+
+		output, err := p.client.DescribeTasks(ctx, &ecs.DescribeTasksInput{
+			Tasks:   batch,
+			Cluster: options.Ptr(p.Config.ClusterID),
+		})
+		if err != nil {
+			return nil, fmt.Errorf("describe tasks: %w", err)
+		} else if len(output.Failures) > 0 {
+			return nil, fmt.Errorf("describe tasks failures: %s", *output.Failures[0].Reason)
+		}
+
+		tasks = append(tasks, output.Tasks...)
+	}
+
+	return tasks, nil
+}
+
+func isTaskStopped(task *types.Task) bool {
+	return task.DesiredStatus != nil && strings.ToUpper(*task.DesiredStatus) == string(types.DesiredStatusStopped)
 }
 
 func (p *EcsProvider) startTask(ctx context.Context, workspaceId string) error {
@@ -222,56 +421,117 @@ func (p *EcsProvider) startTask(ctx context.Context, workspaceId string) error {
 		return err
 	}
 
-	securityGroups := []string{}
-	if p.Config.SecurityGroupID != "" {
-		securityGroups = append(securityGroups, p.Config.SecurityGroupID)
+	volumeConfigurations := []types.TaskVolumeConfiguration{}
+	if p.Config.EbsVolumeSize > 0 {
+		volumeConfiguration, err := p.getEbsVolumeConfiguration(ctx, workspaceId)
+		if err != nil {
+			return err
+		}
+
+		volumeConfigurations = append(volumeConfigurations, volumeConfiguration)
 	}
 
-	p.Log.Infof("Running Task...")
-	taskOutput, err := p.client.RunTask(ctx, &ecs.RunTaskInput{
-		TaskDefinition:       options.Ptr(taskDefinitionID),
-		Cluster:              options.Ptr(p.Config.ClusterID),
-		Count:                options.Ptr(int32(1)),
-		EnableExecuteCommand: true,
-		LaunchType:           types.LaunchType(p.Config.LaunchType),
-		NetworkConfiguration: &types.NetworkConfiguration{
-			AwsvpcConfiguration: &types.AwsVpcConfiguration{
-				Subnets:        []string{p.Config.SubnetID},
-				SecurityGroups: securityGroups,
-				AssignPublicIp: types.AssignPublicIp(p.Config.AssignPublicIp),
+	placementConstraints, err := p.getPlacementConstraints(ctx, workspaceId, taskDefinitionID)
+	if err != nil {
+		return err
+	}
+
+	// try all subnets first and fall back to single subnets if an availability zone is out of capacity
+	attempts := [][]string{p.Config.SubnetIDs}
+	if len(p.Config.SubnetIDs) > 1 {
+		for _, subnetID := range p.Config.SubnetIDs {
+			attempts = append(attempts, []string{subnetID})
+		}
+	}
+
+	var task *types.Task
+	for i, subnetIDs := range attempts {
+		task, err = p.runTask(ctx, workspaceId, &ecs.RunTaskInput{
+			TaskDefinition:       options.Ptr(taskDefinitionID),
+			Cluster:              options.Ptr(p.Config.ClusterID),
+			Count:                options.Ptr(int32(1)),
+			EnableExecuteCommand: true,
+			NetworkConfiguration: &types.NetworkConfiguration{
+				AwsvpcConfiguration: &types.AwsVpcConfiguration{
+					Subnets:        subnetIDs,
+					SecurityGroups: p.Config.SecurityGroupIDs,
+					AssignPublicIp: types.AssignPublicIp(p.Config.AssignPublicIp),
+				},
 			},
-		},
-		Tags: getTags(workspaceId),
-	})
+			PlacementConstraints: placementConstraints,
+			VolumeConfigurations: volumeConfigurations,
+			Tags:                 newWorkspace(workspaceId).Tags(),
+		})
+		if err == nil {
+			break
+		} else if i == len(attempts)-1 || !isCapacityError(err) {
+			return err
+		}
+
+		p.Log.Warnf("Availability zone is out of capacity (%v), retrying in subnet %s", err, attempts[i+1][0])
+	}
+
+	p.Log.Infof("Task successfully started in availability zone %s", aws.ToString(task.AvailabilityZone))
+	return p.pinContainerInstance(ctx, workspaceId, taskDefinitionID, task)
+}
+
+// runTask runs a single task and waits for it to be running
+func (p *EcsProvider) runTask(ctx context.Context, workspaceId string, runTaskInput *ecs.RunTaskInput) (*types.Task, error) {
+	if len(p.Config.CapacityProviderStrategy) > 0 {
+		runTaskInput.CapacityProviderStrategy = p.getCapacityProviderStrategy()
+	} else {
+		runTaskInput.LaunchType = types.LaunchType(p.Config.LaunchType)
+	}
+
+	p.Log.Infof("Running Task...")
+	taskOutput, err := p.client.RunTask(ctx, runTaskInput)
 	if err != nil {
-		return fmt.Errorf("run task: %w", err)
+		return nil, p.withHints(fmt.Errorf("run task: %w", err))
 	} else if len(taskOutput.Failures) > 0 {
-		return fmt.Errorf("run task failure: %w", errors.New(*taskOutput.Failures[0].Reason))
+		return nil, p.withHints(fmt.Errorf("run task failure: %w", errors.New(*taskOutput.Failures[0].Reason)))
+	} else if len(taskOutput.Tasks) == 0 {
+		return nil, fmt.Errorf("run task failed, no task was started")
 	}
 
 	// wait for task to come up
-	timeout := time.Minute * 5
-	now := time.Now()
-	for time.Since(now) < timeout {
-		p.Log.Infof("Waiting for Task to become running...")
-		task, err := p.getTaskID(ctx, workspaceId)
-		if err != nil {
-			return fmt.Errorf("error retrieving task: %w", err)
-		} else if task != nil {
-			if task.DesiredStatus != nil && strings.ToLower(*task.DesiredStatus) != "running" {
-				if task.StoppedReason != nil {
-					return fmt.Errorf("run task failed, task was stopped: %s", *task.StoppedReason)
-				}
-
-				return fmt.Errorf("run task failed, task was stopped without a reason")
-			} else if task.LastStatus != nil && strings.ToLower(*task.LastStatus) == "running" {
-				p.Log.Info("Task successfully started")
-				return nil
-			}
+	p.Log.Infof("Waiting for Task to become running...")
+	task, err := p.waitForTask(ctx, *taskOutput.Tasks[0].TaskArn, func(task *types.Task) bool {
+		return task != nil && (isTaskStopped(task) || strings.ToUpper(aws.ToString(task.LastStatus)) == string(types.DesiredStatusRunning))
+	})
+	if errors.Is(err, errWaitTimeout) {
+		if task == nil {
+			task = &taskOutput.Tasks[0]
 		}
 
-		time.Sleep(time.Second * 5)
+		return nil, p.diagnoseTask(ctx, workspaceId, task, fmt.Sprintf("run task failed, timed out after %s waiting for task to be running, last status %s", p.Config.StartTimeout, aws.ToString(task.LastStatus)))
+	} else if err != nil {
+		return nil, fmt.Errorf("error retrieving task: %w", err)
+	} else if isTaskStopped(task) {
+		if task.StoppedReason != nil {
+			return nil, p.diagnoseTask(ctx, workspaceId, task, "run task failed, task was stopped: "+*task.StoppedReason)
+		}
+
+		return nil, p.diagnoseTask(ctx, workspaceId, task, "run task failed, task was stopped without a reason")
+	}
+
+	return task, nil
+}
+
+// isCapacityError returns true if the task couldn't be started because the availability
+// zone ran out of fargate capacity or network interfaces
+func isCapacityError(err error) bool {
+	message := strings.ToLower(err.Error())
+	for _, capacityError := range []string{
+		"capacity is unavailable",
+		"insufficient capacity",
+		"resource:eni",
+		"insufficientfreeaddressesinsubnet",
+		"no free ip addresses",
+	} {
+		if strings.Contains(message, capacityError) {
+			return true
+		}
 	}
 
-	return fmt.Errorf("run task failed, timed out waiting for task to be running")
+	return false
 }
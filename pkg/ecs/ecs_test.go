@@ -0,0 +1,1095 @@
+package ecs
+
+import (
+	"context"
+	"errors"
+	"reflect"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/aws/aws-sdk-go-v2/service/cloudwatchlogs"
+	"github.com/aws/aws-sdk-go-v2/service/ecs"
+	"github.com/aws/aws-sdk-go-v2/service/ecs/types"
+	"github.com/loft-sh/devpod-provider-ecs/pkg/ecs/fake"
+	"github.com/loft-sh/devpod-provider-ecs/pkg/options"
+	"github.com/loft-sh/devpod/pkg/devcontainer/config"
+	"github.com/loft-sh/devpod/pkg/driver"
+	"github.com/loft-sh/log"
+)
+
+type testEnv struct {
+	provider *EcsProvider
+
+	ecs  *fake.ECS
+	iam  *fake.IAM
+	ssm  *fake.SSM
+	efs  *fake.EFS
+	ec2  *fake.EC2
+	logs *fake.Logs
+}
+
+func newTestEnv(t *testing.T, modify ...func(o *options.Options)) *testEnv {
+	t.Helper()
+
+	opts := &options.Options{
+		DevContainerID:      "workspace",
+		ClusterID:           "devpod",
+		ClusterArchitecture: "amd64",
+		SubnetIDs:           []string{"subnet-1"},
+		TaskRoleARN:         "arn:aws:iam::" + fake.AccountID + ":role/task",
+		ExecutionRoleARN:    "arn:aws:iam::" + fake.AccountID + ":role/execution",
+		TaskCpu:             "1 vcpu",
+		TaskMemory:          "2 gb",
+		LaunchType:          string(types.LaunchTypeFargate),
+		AssignPublicIp:      "ENABLED",
+		EfsRootDirectory:    "/devpod",
+		EbsVolumeType:       "gp3",
+		StartTimeout:        time.Minute,
+		RoleNamePrefix:      "devpod-ecs",
+		IamPath:             "/",
+	}
+	for _, m := range modify {
+		m(opts)
+	}
+
+	env := &testEnv{
+		ecs:  fake.NewECS(),
+		iam:  fake.NewIAM(),
+		ssm:  fake.NewSSM(),
+		efs:  fake.NewEFS(),
+		ec2:  fake.NewEC2(),
+		logs: fake.NewLogs(),
+	}
+
+	provider, err := NewProvider(context.Background(), opts, log.Discard, &Clients{
+		ECS:  env.ecs,
+		IAM:  env.iam,
+		SSM:  env.ssm,
+		EFS:  env.efs,
+		EC2:  env.ec2,
+		Logs: env.logs,
+	})
+	if err != nil {
+		t.Fatalf("create provider: %v", err)
+	}
+	provider.pollInterval = 0
+	provider.AwsConfig.Region = fake.Region
+
+	env.provider = provider
+	return env
+}
+
+func testRunOptions() *driver.RunOptions {
+	return &driver.RunOptions{
+		Image:  "mcr.microsoft.com/devcontainers/base:ubuntu",
+		Labels: []string{"dev.containers.id=workspace"},
+		Env: map[string]string{
+			"FOO": "bar",
+		},
+	}
+}
+
+func testContainerInstance(arn, ec2InstanceID, cpuArchitecture string) types.ContainerInstance {
+	return types.ContainerInstance{
+		ContainerInstanceArn: options.Ptr(arn),
+		Ec2InstanceId:        options.Ptr(ec2InstanceID),
+		Status:               options.Ptr("ACTIVE"),
+		Attributes: []types.Attribute{
+			{Name: options.Ptr("ecs.cpu-architecture"), Value: options.Ptr(cpuArchitecture)},
+		},
+	}
+}
+
+func activeTaskDefinitions(e *fake.ECS) []types.TaskDefinition {
+	taskDefinitions := []types.TaskDefinition{}
+	for _, taskDefinition := range e.TaskDefinitions() {
+		if taskDefinition.Status == types.TaskDefinitionStatusActive {
+			taskDefinitions = append(taskDefinitions, taskDefinition)
+		}
+	}
+
+	return taskDefinitions
+}
+
+func TestRunTask(t *testing.T) {
+	env := newTestEnv(t)
+	ctx := context.Background()
+
+	err := env.provider.RunTask(ctx, "workspace", testRunOptions())
+	if err != nil {
+		t.Fatalf("run task: %v", err)
+	}
+
+	taskDefinitions := activeTaskDefinitions(env.ecs)
+	if len(taskDefinitions) != 1 {
+		t.Fatalf("expected 1 task definition, got %d", len(taskDefinitions))
+	} else if *taskDefinitions[0].Family != "devpod-workspace" {
+		t.Fatalf("unexpected task definition family %s", *taskDefinitions[0].Family)
+	} else if len(taskDefinitions[0].Volumes) != 0 {
+		t.Fatalf("expected no volumes on fargate without efs, got %d", len(taskDefinitions[0].Volumes))
+	}
+
+	tasks := env.ecs.Tasks()
+	if len(tasks) != 1 {
+		t.Fatalf("expected 1 task, got %d", len(tasks))
+	} else if *tasks[0].LastStatus != "RUNNING" {
+		t.Fatalf("expected task to be running, got %s", *tasks[0].LastStatus)
+	}
+
+	runTaskInput := env.ecs.RunTaskInputs[0]
+	if !runTaskInput.EnableExecuteCommand {
+		t.Fatalf("expected execute command to be enabled")
+	} else if runTaskInput.LaunchType != types.LaunchTypeFargate {
+		t.Fatalf("unexpected launch type %s", runTaskInput.LaunchType)
+	}
+}
+
+func TestRunTaskReusesTaskDefinition(t *testing.T) {
+	env := newTestEnv(t, func(o *options.Options) {
+		o.LaunchType = string(types.LaunchTypeEc2)
+	})
+	env.ecs.ContainerInstances = []types.ContainerInstance{
+		testContainerInstance("instance-1", "i-1", "x86_64"),
+	}
+	ctx := context.Background()
+
+	runOptions := testRunOptions()
+	runOptions.Env = map[string]string{"A": "1", "B": "2", "C": "3"}
+	err := env.provider.RunTask(ctx, "workspace", runOptions)
+	if err != nil {
+		t.Fatalf("run task: %v", err)
+	}
+	first := *activeTaskDefinitions(env.ecs)[0].TaskDefinitionArn
+
+	// nothing changed, so the revision is reused
+	err = env.provider.StopTask(ctx, "workspace")
+	if err != nil {
+		t.Fatalf("stop task: %v", err)
+	}
+	err = env.provider.RunTask(ctx, "workspace", runOptions)
+	if err != nil {
+		t.Fatalf("run task again: %v", err)
+	}
+	taskDefinitions := env.ecs.TaskDefinitions()
+	if len(taskDefinitions) != 1 || *taskDefinitions[0].TaskDefinitionArn != first {
+		t.Fatalf("expected task definition %s to be reused, got %d task definitions", first, len(taskDefinitions))
+	}
+
+	// a change registers a new revision, which keeps the pinned container instance
+	err = env.provider.StopTask(ctx, "workspace")
+	if err != nil {
+		t.Fatalf("stop task: %v", err)
+	}
+	env.provider.Config.TaskCpu = "2 vcpu"
+	err = env.provider.RunTask(ctx, "workspace", runOptions)
+	if err != nil {
+		t.Fatalf("run task with changes: %v", err)
+	}
+	taskDefinitions = env.ecs.TaskDefinitions()
+	if len(taskDefinitions) != 1 || taskDefinitions[0].Revision != 2 {
+		t.Fatalf("expected only revision 2 to be left, got %d task definitions", len(taskDefinitions))
+	}
+
+	output, err := env.ecs.DescribeTaskDefinition(ctx, &ecs.DescribeTaskDefinitionInput{
+		TaskDefinition: taskDefinitions[0].TaskDefinitionArn,
+		Include:        []types.TaskDefinitionField{types.TaskDefinitionFieldTags},
+	})
+	if err != nil {
+		t.Fatalf("describe task definition: %v", err)
+	} else if getTag(output.Tags, containerInstanceTagKey) != "instance-1" {
+		t.Fatalf("expected the pinned container instance to be carried over, got %v", output.Tags)
+	} else if getTag(output.Tags, hashTagKey) == "" {
+		t.Fatalf("expected a hash tag, got %v", output.Tags)
+	}
+}
+
+func TestRunTaskRollback(t *testing.T) {
+	env := newTestEnv(t)
+	ctx := context.Background()
+
+	env.ecs.FailNextTask("CannotPullContainerError: pull image manifest has been retried 5 time(s)")
+	err := env.provider.RunTask(ctx, "workspace", testRunOptions())
+	if err == nil {
+		t.Fatalf("expected run task to fail")
+	} else if !strings.Contains(err.Error(), "CannotPullContainerError") {
+		t.Fatalf("expected stopped reason in error, got %v", err)
+	}
+
+	if len(env.ecs.TaskDefinitions()) != 0 {
+		t.Fatalf("expected task definitions to be deleted, got %d", len(env.ecs.TaskDefinitions()))
+	}
+	for _, task := range env.ecs.Tasks() {
+		if *task.DesiredStatus != "STOPPED" {
+			t.Fatalf("expected task %s to be stopped", *task.TaskArn)
+		}
+	}
+}
+
+func TestRunTaskRollbackOnRunTaskError(t *testing.T) {
+	env := newTestEnv(t)
+	ctx := context.Background()
+
+	env.ecs.FailOn("RunTask", errors.New("AccessDeniedException"))
+	err := env.provider.RunTask(ctx, "workspace", testRunOptions())
+	if err == nil || !strings.Contains(err.Error(), "AccessDeniedException") {
+		t.Fatalf("expected run task error, got %v", err)
+	} else if len(env.ecs.TaskDefinitions()) != 0 {
+		t.Fatalf("expected task definitions to be deleted, got %d", len(env.ecs.TaskDefinitions()))
+	}
+}
+
+// cancellingECS cancels the run once the task definition is registered, like an interrupted run
+type cancellingECS struct {
+	ECSAPI
+
+	cancel context.CancelFunc
+}
+
+func (c *cancellingECS) RegisterTaskDefinition(ctx context.Context, params *ecs.RegisterTaskDefinitionInput, optFns ...func(*ecs.Options)) (*ecs.RegisterTaskDefinitionOutput, error) {
+	_, err := c.ECSAPI.RegisterTaskDefinition(ctx, params, optFns...)
+	if err != nil {
+		return nil, err
+	}
+
+	c.cancel()
+	return nil, ctx.Err()
+}
+
+func TestRunTaskRollbackOnInterruptedRegistration(t *testing.T) {
+	env := newTestEnv(t)
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	env.provider.client = &cancellingECS{ECSAPI: env.ecs, cancel: cancel}
+	err := env.provider.RunTask(ctx, "workspace", testRunOptions())
+	if !errors.Is(err, context.Canceled) {
+		t.Fatalf("expected cancelled run, got %v", err)
+	} else if len(env.ecs.TaskDefinitions()) != 0 {
+		t.Fatalf("expected task definitions to be deleted, got %d", len(env.ecs.TaskDefinitions()))
+	}
+}
+
+func TestRunTaskKeepsExistingWorkspaceOnFailedRegistration(t *testing.T) {
+	env := newTestEnv(t)
+	ctx := context.Background()
+
+	err := env.provider.RunTask(ctx, "workspace", testRunOptions())
+	if err != nil {
+		t.Fatalf("run task: %v", err)
+	}
+
+	env.ecs.FailOn("RegisterTaskDefinition", errors.New("AccessDeniedException"))
+	runOptions := testRunOptions()
+	runOptions.Env = map[string]string{"A": "1"}
+	err = env.provider.RunTask(ctx, "workspace", runOptions)
+	if err == nil || !strings.Contains(err.Error(), "AccessDeniedException") {
+		t.Fatalf("expected register task definition error, got %v", err)
+	} else if len(activeTaskDefinitions(env.ecs)) != 1 {
+		t.Fatalf("expected the task definition to be kept, got %d", len(activeTaskDefinitions(env.ecs)))
+	} else if tasks := env.ecs.Tasks(); len(tasks) != 1 || *tasks[0].DesiredStatus != "RUNNING" {
+		t.Fatalf("expected the task to keep running, got %v", tasks)
+	}
+}
+
+func TestFindTask(t *testing.T) {
+	env := newTestEnv(t, func(o *options.Options) {
+		o.EfsFileSystemID = "fs-1"
+	})
+	ctx := context.Background()
+
+	details, err := env.provider.FindTask(ctx, "workspace")
+	if err != nil {
+		t.Fatalf("find task: %v", err)
+	} else if details != nil {
+		t.Fatalf("expected no task before run, got %v", details)
+	}
+
+	err = env.provider.RunTask(ctx, "workspace", testRunOptions())
+	if err != nil {
+		t.Fatalf("run task: %v", err)
+	}
+
+	details, err = env.provider.FindTask(ctx, "workspace")
+	if err != nil {
+		t.Fatalf("find task: %v", err)
+	} else if details == nil || details.State.Status != "running" {
+		t.Fatalf("expected running task, got %v", details)
+	} else if details.Config.Labels["dev.containers.id"] != "workspace" {
+		t.Fatalf("expected labels of the task definition, got %v", details.Config.Labels)
+	}
+
+	err = env.provider.StopTask(ctx, "workspace")
+	if err != nil {
+		t.Fatalf("stop task: %v", err)
+	}
+
+	// the task takes a few describe calls to reach STOPPED
+	for i := 0; i < 10; i++ {
+		details, err = env.provider.FindTask(ctx, "workspace")
+		if err != nil {
+			t.Fatalf("find task: %v", err)
+		} else if details != nil && details.State.Status == "exited" {
+			break
+		}
+	}
+	if details == nil || details.State.Status != "exited" {
+		t.Fatalf("expected exited task, got %v", details)
+	}
+
+	// ecs forgets about stopped tasks after a while
+	env.ecs.ForgetStoppedTasks()
+	details, err = env.provider.FindTask(ctx, "workspace")
+	if err != nil {
+		t.Fatalf("find task: %v", err)
+	} else if details == nil || details.State.Status != "exited" {
+		t.Fatalf("expected exited task from the task definition, got %v", details)
+	} else if details.Config.Labels["dev.containers.id"] != "workspace" {
+		t.Fatalf("expected labels of the task definition, got %v", details.Config.Labels)
+	}
+}
+
+func TestStartTask(t *testing.T) {
+	env := newTestEnv(t, func(o *options.Options) {
+		o.EfsFileSystemID = "fs-1"
+	})
+	ctx := context.Background()
+
+	err := env.provider.RunTask(ctx, "workspace", testRunOptions())
+	if err != nil {
+		t.Fatalf("run task: %v", err)
+	}
+
+	// starting a running workspace is a noop
+	err = env.provider.StartTask(ctx, "workspace")
+	if err != nil {
+		t.Fatalf("start task: %v", err)
+	} else if len(env.ecs.RunTaskInputs) != 1 {
+		t.Fatalf("expected no new task, got %d RunTask calls", len(env.ecs.RunTaskInputs))
+	}
+
+	err = env.provider.StopTask(ctx, "workspace")
+	if err != nil {
+		t.Fatalf("stop task: %v", err)
+	}
+
+	err = env.provider.StartTask(ctx, "workspace")
+	if err != nil {
+		t.Fatalf("start task: %v", err)
+	}
+
+	details, err := env.provider.FindTask(ctx, "workspace")
+	if err != nil {
+		t.Fatalf("find task: %v", err)
+	} else if details == nil || details.State.Status != "running" {
+		t.Fatalf("expected running task after start, got %v", details)
+	}
+}
+
+func TestDeleteTask(t *testing.T) {
+	env := newTestEnv(t, func(o *options.Options) {
+		o.EfsFileSystemID = "fs-1"
+	})
+	ctx := context.Background()
+
+	err := env.provider.RunTask(ctx, "workspace", testRunOptions())
+	if err != nil {
+		t.Fatalf("run task: %v", err)
+	} else if len(env.efs.AccessPoints()) != 1 {
+		t.Fatalf("expected 1 efs access point, got %d", len(env.efs.AccessPoints()))
+	} else if permissions := *env.efs.AccessPoints()[0].RootDirectory.CreationInfo.Permissions; permissions != "0777" {
+		t.Fatalf("expected the workspace directory to be writable by any user, got %s", permissions)
+	}
+
+	err = env.provider.DeleteTask(ctx, "workspace")
+	if err != nil {
+		t.Fatalf("delete task: %v", err)
+	}
+
+	details, err := env.provider.FindTask(ctx, "workspace")
+	if err != nil {
+		t.Fatalf("find task: %v", err)
+	} else if details != nil && details.State.Status == "running" {
+		t.Fatalf("expected no running task after delete, got %v", details)
+	}
+
+	if len(env.ecs.TaskDefinitions()) != 0 {
+		t.Fatalf("expected task definitions to be deleted, got %d", len(env.ecs.TaskDefinitions()))
+	} else if len(env.efs.AccessPoints()) != 0 {
+		t.Fatalf("expected efs access points to be deleted, got %d", len(env.efs.AccessPoints()))
+	}
+}
+
+func TestRunTaskEfsVolumes(t *testing.T) {
+	env := newTestEnv(t, func(o *options.Options) {
+		o.EfsFileSystemID = "fs-1"
+		o.EfsTransitEncrypted = true
+		o.EfsIamAuthorization = true
+	})
+	ctx := context.Background()
+
+	runOptions := testRunOptions()
+	runOptions.WorkspaceMount = &config.Mount{Target: "/workspaces/project"}
+	runOptions.Mounts = []*config.Mount{{Type: "volume", Source: "cache", Target: "/cache"}}
+	err := env.provider.RunTask(ctx, "workspace", runOptions)
+	if err != nil {
+		t.Fatalf("run task: %v", err)
+	}
+
+	accessPoints := map[string]string{}
+	for _, accessPoint := range env.efs.AccessPoints() {
+		accessPoints[getEfsTag(accessPoint.Tags, volumeTagKey)] = *accessPoint.AccessPointId
+	}
+	if len(accessPoints) != 2 {
+		t.Fatalf("expected an access point for the workspace and the mount, got %v", accessPoints)
+	}
+
+	taskDefinition := activeTaskDefinitions(env.ecs)[0]
+	for _, name := range []string{"devpod-workspace", volumeName("workspace", "cache")} {
+		var volume *types.Volume
+		for i := range taskDefinition.Volumes {
+			if *taskDefinition.Volumes[i].Name == name {
+				volume = &taskDefinition.Volumes[i]
+			}
+		}
+		if volume == nil || volume.EfsVolumeConfiguration == nil {
+			t.Fatalf("expected efs volume %s, got %v", name, taskDefinition.Volumes)
+		}
+
+		configuration := volume.EfsVolumeConfiguration
+		if *configuration.FileSystemId != "fs-1" {
+			t.Fatalf("expected volume %s on fs-1, got %s", name, *configuration.FileSystemId)
+		} else if configuration.TransitEncryption != types.EFSTransitEncryptionEnabled {
+			t.Fatalf("expected transit encryption for volume %s, got %s", name, configuration.TransitEncryption)
+		} else if *configuration.AuthorizationConfig.AccessPointId != accessPoints[name] {
+			t.Fatalf("expected volume %s to use access point %s, got %s", name, accessPoints[name], *configuration.AuthorizationConfig.AccessPointId)
+		} else if configuration.AuthorizationConfig.Iam != types.EFSAuthorizationConfigIAMEnabled {
+			t.Fatalf("expected iam authorization for volume %s, got %s", name, configuration.AuthorizationConfig.Iam)
+		}
+	}
+
+	mountPoints := map[string]string{}
+	for _, mountPoint := range taskDefinition.ContainerDefinitions[0].MountPoints {
+		mountPoints[*mountPoint.SourceVolume] = *mountPoint.ContainerPath
+	}
+	expected := map[string]string{"devpod-workspace": "/workspaces/project", volumeName("workspace", "cache"): "/cache"}
+	if !reflect.DeepEqual(mountPoints, expected) {
+		t.Fatalf("expected mount points %v, got %v", expected, mountPoints)
+	}
+}
+
+func TestStartTaskPinsContainerInstance(t *testing.T) {
+	env := newTestEnv(t, func(o *options.Options) {
+		o.LaunchType = string(types.LaunchTypeEc2)
+	})
+	env.ecs.ContainerInstances = []types.ContainerInstance{
+		testContainerInstance("instance-1", "i-1", "x86_64"),
+		testContainerInstance("instance-2", "i-2", "x86_64"),
+	}
+	ctx := context.Background()
+
+	err := env.provider.RunTask(ctx, "workspace", testRunOptions())
+	if err != nil {
+		t.Fatalf("run task: %v", err)
+	}
+
+	attributes := env.ecs.Attributes()
+	if len(attributes) != 1 || *attributes[0].TargetId != "instance-1" {
+		t.Fatalf("expected workspace attribute on instance-1, got %v", attributes)
+	}
+
+	// move the attribute to simulate that the workspace lives on the second instance
+	err = env.provider.StopTask(ctx, "workspace")
+	if err != nil {
+		t.Fatalf("stop task: %v", err)
+	}
+	err = env.provider.StartTask(ctx, "workspace")
+	if err != nil {
+		t.Fatalf("start task: %v", err)
+	}
+
+	runTaskInput := env.ecs.RunTaskInputs[len(env.ecs.RunTaskInputs)-1]
+	if len(runTaskInput.PlacementConstraints) != 2 || *runTaskInput.PlacementConstraints[1].Expression != "attribute:devpod-workspace-workspace exists" {
+		t.Fatalf("expected architecture and workspace placement constraints on restart, got %v", runTaskInput.PlacementConstraints)
+	}
+
+	// fail if the instance is gone
+	env.ecs.ContainerInstances = env.ecs.ContainerInstances[1:]
+	err = env.provider.StopTask(ctx, "workspace")
+	if err != nil {
+		t.Fatalf("stop task: %v", err)
+	}
+	err = env.provider.StartTask(ctx, "workspace")
+	if err == nil || !strings.Contains(err.Error(), "ALLOW_INSTANCE_MIGRATION") {
+		t.Fatalf("expected error about the missing instance, got %v", err)
+	}
+
+	// migrate if allowed
+	env.provider.Config.AllowInstanceMigration = true
+	err = env.provider.StartTask(ctx, "workspace")
+	if err != nil {
+		t.Fatalf("start task: %v", err)
+	}
+	tasks := env.ecs.Tasks()
+	if *tasks[len(tasks)-1].ContainerInstanceArn != "instance-2" {
+		t.Fatalf("expected task to be migrated to instance-2, got %s", *tasks[len(tasks)-1].ContainerInstanceArn)
+	}
+}
+
+func TestDeleteTaskRemovesDockerVolumes(t *testing.T) {
+	env := newTestEnv(t, func(o *options.Options) {
+		o.LaunchType = string(types.LaunchTypeEc2)
+	})
+	env.ecs.ContainerInstances = []types.ContainerInstance{
+		testContainerInstance("instance-1", "i-1", "x86_64"),
+	}
+	sentTo := []string{}
+	env.ssm.RunCommand = func(instanceID string, commands []string) string {
+		sentTo = append(sentTo, instanceID)
+		return "removed devpod-workspace\n"
+	}
+	ctx := context.Background()
+
+	err := env.provider.RunTask(ctx, "workspace", testRunOptions())
+	if err != nil {
+		t.Fatalf("run task: %v", err)
+	}
+
+	err = env.provider.DeleteTask(ctx, "workspace")
+	if err != nil {
+		t.Fatalf("delete task: %v", err)
+	} else if len(sentTo) != 1 || sentTo[0] != "i-1" {
+		t.Fatalf("expected remove command to be sent to i-1, got %v", sentTo)
+	} else if len(env.ecs.Attributes()) != 0 {
+		t.Fatalf("expected workspace attributes to be deleted, got %v", env.ecs.Attributes())
+	}
+}
+
+func TestRunTaskCapabilities(t *testing.T) {
+	env := newTestEnv(t)
+	ctx := context.Background()
+
+	runOptions := testRunOptions()
+	runOptions.CapAdd = []string{"cap_sys_ptrace", "SYS_PTRACE"}
+	err := env.provider.RunTask(ctx, "workspace", runOptions)
+	if err != nil {
+		t.Fatalf("run task: %v", err)
+	}
+
+	capabilities := activeTaskDefinitions(env.ecs)[0].ContainerDefinitions[0].LinuxParameters.Capabilities
+	if capabilities == nil || len(capabilities.Add) != 1 || capabilities.Add[0] != "SYS_PTRACE" {
+		t.Fatalf("expected SYS_PTRACE capability, got %v", capabilities)
+	}
+
+	runOptions.CapAdd = []string{"NET_ADMIN"}
+	err = env.provider.RunTask(ctx, "workspace", runOptions)
+	if err == nil || !strings.Contains(err.Error(), "not supported on fargate") {
+		t.Fatalf("expected fargate capability error, got %v", err)
+	}
+
+	env.provider.Config.LaunchType = string(types.LaunchTypeEc2)
+	runOptions.CapAdd = []string{"NET_ADMIN", "NO_SUCH_CAP"}
+	err = env.provider.RunTask(ctx, "workspace", runOptions)
+	if err == nil || !strings.Contains(err.Error(), "unknown linux capability NO_SUCH_CAP") {
+		t.Fatalf("expected unknown capability error, got %v", err)
+	}
+}
+
+func TestGetWorkspaceDir(t *testing.T) {
+	tests := map[string]string{
+		"":                          "/workspaces",
+		"relative/project":          "/workspaces",
+		"/workspaces/project":       "/workspaces/project",
+		"/home/vscode/src/project/": "/home/vscode/src/project",
+		"/project":                  "/project",
+	}
+	for target, expected := range tests {
+		runOptions := &driver.RunOptions{}
+		if target != "" {
+			runOptions.WorkspaceMount = &config.Mount{Target: target}
+		}
+
+		workspaceDir := getWorkspaceDir(runOptions)
+		if workspaceDir != expected {
+			t.Errorf("expected workspace dir %s for target %q, got %s", expected, target, workspaceDir)
+		}
+	}
+}
+
+func TestRunTaskWorkspaceMount(t *testing.T) {
+	env := newTestEnv(t, func(o *options.Options) {
+		o.LaunchType = string(types.LaunchTypeEc2)
+	})
+	env.ecs.ContainerInstances = []types.ContainerInstance{
+		testContainerInstance("instance-1", "i-1", "x86_64"),
+	}
+	ctx := context.Background()
+
+	runOptions := testRunOptions()
+	runOptions.WorkspaceMount = &config.Mount{Target: "/home/vscode/project"}
+	err := env.provider.RunTask(ctx, "workspace", runOptions)
+	if err != nil {
+		t.Fatalf("run task: %v", err)
+	}
+
+	containerDefinition := activeTaskDefinitions(env.ecs)[0].ContainerDefinitions[0]
+	if mountPoints := containerDefinition.MountPoints; len(mountPoints) != 1 || *mountPoints[0].ContainerPath != "/home/vscode/project" {
+		t.Fatalf("expected the workspace volume at the workspace mount target, got %v", mountPoints)
+	}
+	command := strings.Join(containerDefinition.Command, " ")
+	if !strings.Contains(command, "/home/vscode/devpod-provider-ecs entrypoint") {
+		t.Fatalf("expected the binary to be installed next to the workspace, got %s", command)
+	}
+
+	// without a workspace mount the binary is installed to /workspaces
+	err = env.provider.RunTask(ctx, "other", testRunOptions())
+	if err != nil {
+		t.Fatalf("run task: %v", err)
+	}
+	command = ""
+	for _, taskDefinition := range activeTaskDefinitions(env.ecs) {
+		if *taskDefinition.Family == "devpod-other" {
+			command = strings.Join(taskDefinition.ContainerDefinitions[0].Command, " ")
+		}
+	}
+	if !strings.Contains(command, "/workspaces/devpod-provider-ecs entrypoint") {
+		t.Fatalf("expected the binary to be installed to /workspaces, got %s", command)
+	}
+}
+
+func TestClusterArchitectureAuto(t *testing.T) {
+	env := newTestEnv(t, func(o *options.Options) {
+		o.ClusterArchitecture = "auto"
+		o.LaunchType = string(types.LaunchTypeEc2)
+	})
+	env.ecs.ContainerInstances = []types.ContainerInstance{
+		testContainerInstance("instance-1", "i-1", "x86_64"),
+		testContainerInstance("instance-2", "i-2", "arm64"),
+		testContainerInstance("instance-3", "i-3", "arm64"),
+	}
+	ctx := context.Background()
+
+	architecture, err := env.provider.TargetArchitecture(ctx, "workspace")
+	if err != nil {
+		t.Fatalf("target architecture: %v", err)
+	} else if architecture != "arm64" {
+		t.Fatalf("expected arm64, got %s", architecture)
+	}
+
+	err = env.provider.RunTask(ctx, "workspace", testRunOptions())
+	if err != nil {
+		t.Fatalf("run task: %v", err)
+	}
+
+	runtimePlatform := activeTaskDefinitions(env.ecs)[0].RuntimePlatform
+	if runtimePlatform == nil || runtimePlatform.CpuArchitecture != types.CPUArchitectureArm64 {
+		t.Fatalf("expected arm64 runtime platform, got %v", runtimePlatform)
+	}
+	tasks := env.ecs.Tasks()
+	if *tasks[0].ContainerInstanceArn != "instance-2" {
+		t.Fatalf("expected task to be placed on an arm64 instance, got %s", *tasks[0].ContainerInstanceArn)
+	}
+
+	// the workspace keeps its architecture
+	env.ecs.ContainerInstances = append(env.ecs.ContainerInstances, testContainerInstance("instance-4", "i-4", "x86_64"), testContainerInstance("instance-5", "i-5", "x86_64"))
+	architecture, err = env.provider.TargetArchitecture(ctx, "workspace")
+	if err != nil {
+		t.Fatalf("target architecture: %v", err)
+	} else if architecture != "arm64" {
+		t.Fatalf("expected arm64 of the existing task definition, got %s", architecture)
+	}
+}
+
+func TestSpotInterruption(t *testing.T) {
+	env := newTestEnv(t, func(o *options.Options) {
+		o.CapacityProviderStrategy = []options.CapacityProvider{{Name: "FARGATE_SPOT", Weight: 3}, {Name: "FARGATE", Weight: 1, Base: 1}}
+	})
+	ctx := context.Background()
+
+	err := env.provider.RunTask(ctx, "workspace", testRunOptions())
+	if err != nil {
+		t.Fatalf("run task: %v", err)
+	}
+
+	runTaskInput := env.ecs.RunTaskInputs[0]
+	if runTaskInput.LaunchType != "" || len(runTaskInput.CapacityProviderStrategy) != 2 {
+		t.Fatalf("expected capacity provider strategy instead of launch type, got %v %v", runTaskInput.LaunchType, runTaskInput.CapacityProviderStrategy)
+	} else if runTaskInput.CapacityProviderStrategy[1].Base != 1 {
+		t.Fatalf("expected base of the strategy to be set, got %v", runTaskInput.CapacityProviderStrategy[1])
+	}
+
+	env.ecs.InterruptTask(*env.ecs.Tasks()[0].TaskArn)
+	details, err := env.provider.FindTask(ctx, "workspace")
+	if err != nil {
+		t.Fatalf("find task: %v", err)
+	} else if details == nil || details.State.Status != "exited" {
+		t.Fatalf("expected interrupted task to be exited, got %v", details)
+	}
+
+	err = env.provider.StartTask(ctx, "workspace")
+	if err != nil {
+		t.Fatalf("start task: %v", err)
+	} else if len(env.ecs.RunTaskInputs) != 2 {
+		t.Fatalf("expected interrupted task to be relaunched, got %d RunTask calls", len(env.ecs.RunTaskInputs))
+	}
+}
+
+func TestRunTaskSubnetFallback(t *testing.T) {
+	env := newTestEnv(t, func(o *options.Options) {
+		o.SubnetIDs = []string{"subnet-1", "subnet-2"}
+	})
+	env.ecs.SubnetAvailabilityZones = map[string]string{
+		"subnet-1": "us-east-1a",
+		"subnet-2": "us-east-1b",
+	}
+	env.ecs.UnavailableZones = map[string]bool{"us-east-1a": true}
+	ctx := context.Background()
+
+	err := env.provider.RunTask(ctx, "workspace", testRunOptions())
+	if err != nil {
+		t.Fatalf("run task: %v", err)
+	}
+
+	tasks := env.ecs.Tasks()
+	if len(tasks) != 3 {
+		t.Fatalf("expected 3 attempts, got %d", len(tasks))
+	} else if *tasks[2].AvailabilityZone != "us-east-1b" || *tasks[2].LastStatus != "RUNNING" {
+		t.Fatalf("expected task to run in us-east-1b, got %s %s", *tasks[2].AvailabilityZone, *tasks[2].LastStatus)
+	}
+
+	// other errors are not retried
+	err = env.provider.DeleteTask(ctx, "workspace")
+	if err != nil {
+		t.Fatalf("delete task: %v", err)
+	}
+	env.ecs.UnavailableZones = nil
+	env.ecs.FailNextTask("CannotPullContainerError: pull access denied")
+	err = env.provider.RunTask(ctx, "workspace", testRunOptions())
+	if err == nil || !strings.Contains(err.Error(), "CannotPullContainerError") {
+		t.Fatalf("expected pull error, got %v", err)
+	} else if len(env.ecs.RunTaskInputs) != 4 {
+		t.Fatalf("expected no retry, got %d RunTask calls", len(env.ecs.RunTaskInputs))
+	}
+}
+
+func TestRunTaskRegistryCredentials(t *testing.T) {
+	secretArn := "arn:aws:secretsmanager:us-east-1:" + fake.AccountID + ":secret:registry-AbCdEf"
+	env := newTestEnv(t, func(o *options.Options) {
+		o.TaskRoleARN = ""
+		o.ExecutionRoleARN = ""
+		o.RegistryCredentialsSecretARN = secretArn
+	})
+	ctx := context.Background()
+
+	err := env.provider.RunTask(ctx, "workspace", testRunOptions())
+	if err != nil {
+		t.Fatalf("run task: %v", err)
+	}
+
+	repositoryCredentials := activeTaskDefinitions(env.ecs)[0].ContainerDefinitions[0].RepositoryCredentials
+	if repositoryCredentials == nil || *repositoryCredentials.CredentialsParameter != secretArn {
+		t.Fatalf("expected repository credentials, got %v", repositoryCredentials)
+	}
+
+	document := env.iam.RolePolicy(testExecutionRoleName("workspace"), "devpod-workspace")
+	if !strings.Contains(document, "secretsmanager:GetSecretValue") || !strings.Contains(document, secretArn) {
+		t.Fatalf("expected execution policy to allow reading the secret, got %s", document)
+	} else if strings.Contains(document, "kms:Decrypt") {
+		t.Fatalf("expected execution policy to not allow decrypting without a key, got %s", document)
+	}
+}
+
+func TestRunTaskRegistryCredentialsKmsKey(t *testing.T) {
+	keyArn := "arn:aws:kms:us-east-1:" + fake.AccountID + ":key/1234abcd-12ab-34cd-56ef-1234567890ab"
+	env := newTestEnv(t, func(o *options.Options) {
+		o.TaskRoleARN = ""
+		o.ExecutionRoleARN = ""
+		o.RegistryCredentialsSecretARN = "arn:aws:secretsmanager:us-east-1:" + fake.AccountID + ":secret:registry-AbCdEf"
+		o.RegistryCredentialsKmsKeyARN = keyArn
+	})
+	ctx := context.Background()
+
+	err := env.provider.RunTask(ctx, "workspace", testRunOptions())
+	if err != nil {
+		t.Fatalf("run task: %v", err)
+	}
+
+	document := env.iam.RolePolicy(testExecutionRoleName("workspace"), "devpod-workspace")
+	if !strings.Contains(document, "kms:Decrypt") || !strings.Contains(document, keyArn) {
+		t.Fatalf("expected execution policy to allow decrypting with the key, got %s", document)
+	}
+}
+
+func TestRunTaskSecrets(t *testing.T) {
+	env := newTestEnv(t, func(o *options.Options) {
+		o.TaskRoleARN = ""
+		o.ExecutionRoleARN = ""
+		o.Secrets = []options.Secret{
+			{Name: "FOO", ValueFrom: "arn:aws:secretsmanager:us-east-1:" + fake.AccountID + ":secret:foo-AbCdEf:password::"},
+			{Name: "TOKEN", ValueFrom: "arn:aws:ssm:us-east-1:" + fake.AccountID + ":parameter/devpod/token"},
+		}
+	})
+	ctx := context.Background()
+
+	err := env.provider.RunTask(ctx, "workspace", testRunOptions())
+	if err != nil {
+		t.Fatalf("run task: %v", err)
+	}
+
+	containerDefinition := activeTaskDefinitions(env.ecs)[0].ContainerDefinitions[0]
+	if len(containerDefinition.Secrets) != 2 || *containerDefinition.Secrets[0].Name != "FOO" {
+		t.Fatalf("expected secrets in the container definition, got %v", containerDefinition.Secrets)
+	}
+	for _, environment := range containerDefinition.Environment {
+		if *environment.Name == "FOO" {
+			t.Fatalf("expected FOO to be only set as secret, got value %s", *environment.Value)
+		}
+	}
+
+	document := env.iam.RolePolicy(testExecutionRoleName("workspace"), "devpod-workspace")
+	if !strings.Contains(document, `"arn:aws:secretsmanager:us-east-1:`+fake.AccountID+`:secret:foo-AbCdEf"`) {
+		t.Fatalf("expected role to read the secret without json key, got %s", document)
+	} else if !strings.Contains(document, "ssm:GetParameters") {
+		t.Fatalf("expected role to read the parameter, got %s", document)
+	}
+}
+
+func TestRunTaskLogConfiguration(t *testing.T) {
+	env := newTestEnv(t, func(o *options.Options) {
+		o.CloudWatchLogs = true
+		o.LogGroup = "/devpod/ecs"
+		o.LogRetentionDays = 7
+	})
+	ctx := context.Background()
+
+	err := env.provider.RunTask(ctx, "workspace", testRunOptions())
+	if err != nil {
+		t.Fatalf("run task: %v", err)
+	}
+
+	logGroup := env.logs.LogGroup("/devpod/ecs")
+	if logGroup == nil {
+		t.Fatalf("expected log group to be created")
+	} else if logGroup.RetentionInDays == nil || *logGroup.RetentionInDays != 7 {
+		t.Fatalf("expected log group retention of 7 days, got %v", logGroup.RetentionInDays)
+	}
+
+	logConfiguration := activeTaskDefinitions(env.ecs)[0].ContainerDefinitions[0].LogConfiguration
+	if logConfiguration == nil || logConfiguration.LogDriver != types.LogDriverAwslogs {
+		t.Fatalf("expected awslogs log configuration, got %v", logConfiguration)
+	} else if logConfiguration.Options["awslogs-group"] != "/devpod/ecs" || logConfiguration.Options["awslogs-region"] != fake.Region || logConfiguration.Options["awslogs-stream-prefix"] != "devpod-workspace" {
+		t.Fatalf("unexpected log configuration options %v", logConfiguration.Options)
+	}
+
+	// a second run reuses the log group
+	err = env.provider.RunTask(ctx, "workspace", testRunOptions())
+	if err != nil {
+		t.Fatalf("run task again: %v", err)
+	}
+}
+
+func TestLogs(t *testing.T) {
+	env := newTestEnv(t, func(o *options.Options) {
+		o.CloudWatchLogs = true
+		o.LogGroup = "/devpod/ecs"
+	})
+	ctx := context.Background()
+
+	out := &strings.Builder{}
+	err := env.provider.Logs(ctx, "workspace", 0, false, out)
+	if err == nil {
+		t.Fatalf("expected error without log group")
+	}
+
+	_, err = env.logs.CreateLogGroup(ctx, &cloudwatchlogs.CreateLogGroupInput{LogGroupName: options.Ptr("/devpod/ecs")})
+	if err != nil {
+		t.Fatalf("create log group: %v", err)
+	}
+	env.logs.PutEvents("/devpod/ecs", "devpod-workspace/devpod/old", "old")
+	env.logs.PutEvents("/devpod/ecs", "devpod-workspace-2/devpod/other", "other")
+	time.Sleep(5 * time.Millisecond)
+	env.logs.PutEvents("/devpod/ecs", "devpod-workspace/devpod/new", "one", "two", "three")
+
+	err = env.provider.Logs(ctx, "workspace", 0, false, out)
+	if err != nil {
+		t.Fatalf("logs: %v", err)
+	} else if out.String() != "one\ntwo\nthree\n" {
+		t.Fatalf("unexpected logs %q", out.String())
+	}
+
+	out.Reset()
+	err = env.provider.Logs(ctx, "workspace", 2, false, out)
+	if err != nil {
+		t.Fatalf("logs with tail: %v", err)
+	} else if out.String() != "two\nthree\n" {
+		t.Fatalf("unexpected logs with tail %q", out.String())
+	}
+}
+
+func TestRunTaskDiagnostics(t *testing.T) {
+	testCases := []struct {
+		name     string
+		modify   func(o *options.Options)
+		failure  fake.TaskFailure
+		logs     []string
+		expected []string
+	}{
+		{
+			name: "no public ip",
+			modify: func(o *options.Options) {
+				o.AssignPublicIp = "DISABLED"
+			},
+			failure: fake.TaskFailure{
+				StoppedReason: "CannotPullContainerError: pull image manifest has been retried 5 time(s): failed to resolve ref: dial tcp 1.2.3.4:443: i/o timeout",
+			},
+			expected: []string{"stop code: TaskFailedToStart", "Set ASSIGN_PUBLIC_IP to ENABLED"},
+		},
+		{
+			name: "eni capacity",
+			failure: fake.TaskFailure{
+				StoppedReason: "Timeout waiting for network interface provisioning to complete.",
+				StopCode:      types.TaskStopCodeTaskFailedToStart,
+			},
+			expected: []string{"run task failed, task was stopped: Timeout waiting for network interface provisioning", "No network interface could be created"},
+		},
+		{
+			name: "wrong architecture",
+			modify: func(o *options.Options) {
+				o.CloudWatchLogs = true
+				o.LogGroup = "/devpod/ecs"
+			},
+			failure: fake.TaskFailure{
+				StoppedReason: "Essential container in task exited",
+				StopCode:      types.TaskStopCodeEssentialContainerExited,
+				ExitCode:      options.Ptr(int32(255)),
+			},
+			logs:     []string{"exec /bin/sh: exec format error"},
+			expected: []string{"container devpod: (exit code 255)", "last log lines:\n    exec /bin/sh: exec format error", "set CLUSTER_ARCHITECTURE"},
+		},
+		{
+			name: "container exited",
+			failure: fake.TaskFailure{
+				StoppedReason: "Essential container in task exited",
+				StopCode:      types.TaskStopCodeEssentialContainerExited,
+				ExitCode:      options.Ptr(int32(1)),
+			},
+			expected: []string{"(exit code 1)", "set CLOUDWATCH_LOGS to ENABLED"},
+		},
+	}
+
+	for _, testCase := range testCases {
+		t.Run(testCase.name, func(t *testing.T) {
+			modify := []func(o *options.Options){}
+			if testCase.modify != nil {
+				modify = append(modify, testCase.modify)
+			}
+			env := newTestEnv(t, modify...)
+			ctx := context.Background()
+
+			env.ecs.FailNextTaskWith(testCase.failure)
+			if len(testCase.logs) > 0 {
+				// the log stream is named after the task, so write the logs when it is started
+				env.ecs.OnRunTask = func(task types.Task) {
+					env.logs.PutEvents("/devpod/ecs", getLogStreamName("workspace", &task), testCase.logs...)
+				}
+			}
+
+			err := env.provider.RunTask(ctx, "workspace", testRunOptions())
+			if err == nil {
+				t.Fatalf("expected run task to fail")
+			}
+			for _, expected := range testCase.expected {
+				if !strings.Contains(err.Error(), expected) {
+					t.Fatalf("expected error to contain %q, got:\n%s", expected, err.Error())
+				}
+			}
+		})
+	}
+}
+
+func TestStopTaskWithoutPersistentStorage(t *testing.T) {
+	env := newTestEnv(t)
+	ctx := context.Background()
+
+	err := env.provider.RunTask(ctx, "workspace", testRunOptions())
+	if err != nil {
+		t.Fatalf("run task: %v", err)
+	}
+
+	err = env.provider.StopTask(ctx, "workspace")
+	if err == nil || !strings.Contains(err.Error(), "EFS_FILE_SYSTEM_ID or EBS_VOLUME_SIZE") {
+		t.Fatalf("expected stopping a fargate workspace without volumes to fail, got %v", err)
+	}
+
+	details, err := env.provider.FindTask(ctx, "workspace")
+	if err != nil {
+		t.Fatalf("find task: %v", err)
+	} else if details == nil || details.State.Status != "running" {
+		t.Fatalf("expected the task to keep running, got %v", details)
+	}
+}
+
+func TestStopTaskWaitsForStopped(t *testing.T) {
+	env := newTestEnv(t, func(o *options.Options) {
+		o.EfsFileSystemID = "fs-1"
+	})
+	ctx := context.Background()
+
+	err := env.provider.RunTask(ctx, "workspace", testRunOptions())
+	if err != nil {
+		t.Fatalf("run task: %v", err)
+	}
+
+	err = env.provider.StopTask(ctx, "workspace")
+	if err != nil {
+		t.Fatalf("stop task: %v", err)
+	}
+
+	tasks := env.ecs.Tasks()
+	if *tasks[0].LastStatus != "STOPPED" {
+		t.Fatalf("expected task to be stopped when stop returns, got %s", *tasks[0].LastStatus)
+	}
+}
+
+func TestRunTaskStartTimeout(t *testing.T) {
+	env := newTestEnv(t, func(o *options.Options) {
+		o.StartTimeout = time.Nanosecond
+	})
+
+	err := env.provider.RunTask(context.Background(), "workspace", testRunOptions())
+	if err == nil || !strings.Contains(err.Error(), "timed out after 1ns waiting for task to be running, last status PENDING") {
+		t.Fatalf("expected timeout error, got %v", err)
+	}
+}
+
+func TestRunTaskCancel(t *testing.T) {
+	env := newTestEnv(t, func(o *options.Options) {
+		// bounds the wait for the task to be stopped during the rollback
+		o.StartTimeout = time.Millisecond * 100
+	})
+	env.provider.pollInterval = time.Hour
+	ctx, cancel := context.WithCancel(context.Background())
+
+	done := make(chan error)
+	go func() {
+		done <- env.provider.RunTask(ctx, "workspace", testRunOptions())
+	}()
+
+	time.Sleep(10 * time.Millisecond)
+	cancel()
+	select {
+	case err := <-done:
+		if !errors.Is(err, context.Canceled) {
+			t.Fatalf("expected context canceled, got %v", err)
+		}
+	case <-time.After(5 * time.Second):
+		t.Fatalf("run task didn't return after the context was canceled")
+	}
+
+	// the task and task definition are rolled back
+	if len(activeTaskDefinitions(env.ecs)) != 0 {
+		t.Fatalf("expected task definition to be deleted")
+	} else if tasks := env.ecs.Tasks(); len(tasks) != 1 || *tasks[0].DesiredStatus != "STOPPED" {
+		t.Fatalf("expected task to be stopped")
+	}
+}
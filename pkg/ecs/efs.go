@@ -0,0 +1,191 @@
+package ecs
+
+import (
+	"context"
+	"fmt"
+	"path"
+	"time"
+
+	"github.com/aws/aws-sdk-go-v2/service/ecs/types"
+	"github.com/aws/aws-sdk-go-v2/service/efs"
+	efstypes "github.com/aws/aws-sdk-go-v2/service/efs/types"
+	"github.com/google/uuid"
+	"github.com/loft-sh/devpod-provider-ecs/pkg/options"
+)
+
+const volumeTagKey = "devpod-volume"
+
+func (p *EcsProvider) getEfsVolume(ctx context.Context, workspaceId, name string) (types.Volume, error) {
+	accessPointID, err := p.ensureAccessPoint(ctx, workspaceId, name)
+	if err != nil {
+		return types.Volume{}, err
+	}
+
+	efsVolumeConfiguration := &types.EFSVolumeConfiguration{
+		FileSystemId:      options.Ptr(p.Config.EfsFileSystemID),
+		TransitEncryption: types.EFSTransitEncryptionDisabled,
+		AuthorizationConfig: &types.EFSAuthorizationConfig{
+			AccessPointId: options.Ptr(accessPointID),
+			Iam:           types.EFSAuthorizationConfigIAMDisabled,
+		},
+	}
+	if p.Config.EfsTransitEncrypted {
+		efsVolumeConfiguration.TransitEncryption = types.EFSTransitEncryptionEnabled
+	}
+	if p.Config.EfsIamAuthorization {
+		efsVolumeConfiguration.AuthorizationConfig.Iam = types.EFSAuthorizationConfigIAMEnabled
+	}
+
+	return types.Volume{
+		Name:                   options.Ptr(name),
+		EfsVolumeConfiguration: efsVolumeConfiguration,
+	}, nil
+}
+
+func (p *EcsProvider) ensureAccessPoint(ctx context.Context, workspaceId, name string) (string, error) {
+	accessPoints, err := p.getAccessPoints(ctx, workspaceId)
+	if err != nil {
+		return "", err
+	}
+	for _, accessPoint := range accessPoints {
+		if getEfsTag(accessPoint.Tags, volumeTagKey) != name {
+			continue
+		}
+
+		// tag access points of older versions with the cluster, so teardown finds them
+		if getEfsTag(accessPoint.Tags, clusterTagKey) == "" {
+			_, err = p.efsClient.TagResource(ctx, &efs.TagResourceInput{
+				ResourceId: accessPoint.AccessPointId,
+				Tags: []efstypes.Tag{
+					{
+						Key:   options.Ptr(clusterTagKey),
+						Value: options.Ptr(p.Config.ClusterID),
+					},
+				},
+			})
+			if err != nil {
+				return "", fmt.Errorf("tag efs access point %s: %w", *accessPoint.AccessPointId, err)
+			}
+		}
+
+		return *accessPoint.AccessPointId, nil
+	}
+
+	// create access point
+	p.Log.Infof("Create efs access point for volume %s...", name)
+	accessPoint, err := p.efsClient.CreateAccessPoint(ctx, &efs.CreateAccessPointInput{
+		ClientToken:  options.Ptr(uuid.NewString()),
+		FileSystemId: options.Ptr(p.Config.EfsFileSystemID),
+		RootDirectory: &efstypes.RootDirectory{
+			Path: options.Ptr(path.Join(p.Config.EfsRootDirectory, name)),
+			// the container user is only known by name, so let any user write to the
+			// directory, e.g. the vscode or node user of a devcontainer image
+			CreationInfo: &efstypes.CreationInfo{
+				OwnerUid:    options.Ptr(int64(0)),
+				OwnerGid:    options.Ptr(int64(0)),
+				Permissions: options.Ptr("0777"),
+			},
+		},
+		Tags: []efstypes.Tag{
+			{
+				Key:   options.Ptr("Name"),
+				Value: options.Ptr(name),
+			},
+			{
+				Key:   options.Ptr(workspaceTagKey),
+				Value: options.Ptr(workspaceId),
+			},
+			{
+				Key:   options.Ptr(clusterTagKey),
+				Value: options.Ptr(p.Config.ClusterID),
+			},
+			{
+				Key:   options.Ptr(volumeTagKey),
+				Value: options.Ptr(name),
+			},
+		},
+	})
+	if err != nil {
+		return "", fmt.Errorf("create efs access point: %w", err)
+	}
+
+	// wait for access point to become available
+	timeout := time.Minute
+	now := time.Now()
+	for accessPoint.LifeCycleState != efstypes.LifeCycleStateAvailable {
+		if time.Since(now) > timeout {
+			return "", fmt.Errorf("timed out waiting for efs access point %s to become available", *accessPoint.AccessPointId)
+		}
+
+		err = sleep(ctx, time.Second*2)
+		if err != nil {
+			return "", err
+		}
+		output, err := p.efsClient.DescribeAccessPoints(ctx, &efs.DescribeAccessPointsInput{
+			AccessPointId: accessPoint.AccessPointId,
+		})
+		if err != nil {
+			return "", fmt.Errorf("describe efs access point: %w", err)
+		} else if len(output.AccessPoints) == 0 {
+			return "", fmt.Errorf("efs access point %s not found", *accessPoint.AccessPointId)
+		}
+
+		accessPoint.LifeCycleState = output.AccessPoints[0].LifeCycleState
+	}
+
+	return *accessPoint.AccessPointId, nil
+}
+
+// deleteAccessPoints deletes the access points of the workspace. EFS keeps the directories
+// of deleted access points, so their data stays on the file system.
+func (p *EcsProvider) deleteAccessPoints(ctx context.Context, workspaceId string) error {
+	accessPoints, err := p.getAccessPoints(ctx, workspaceId)
+	if err != nil {
+		return err
+	}
+
+	for _, accessPoint := range accessPoints {
+		p.Log.Infof("Deleting efs access point %s...", *accessPoint.AccessPointId)
+		_, err = p.efsClient.DeleteAccessPoint(ctx, &efs.DeleteAccessPointInput{
+			AccessPointId: accessPoint.AccessPointId,
+		})
+		if err != nil {
+			return fmt.Errorf("delete efs access point %s: %w", *accessPoint.AccessPointId, err)
+		}
+	}
+
+	return nil
+}
+
+func (p *EcsProvider) getAccessPoints(ctx context.Context, workspaceId string) ([]efstypes.AccessPointDescription, error) {
+	accessPoints := []efstypes.AccessPointDescription{}
+	paginator := efs.NewDescribeAccessPointsPaginator(p.efsClient, &efs.DescribeAccessPointsInput{
+		FileSystemId: options.Ptr(p.Config.EfsFileSystemID),
+	})
+	for paginator.HasMorePages() {
+		output, err := paginator.NextPage(ctx)
+		if err != nil {
+			return nil, fmt.Errorf("list efs access points: %w", err)
+		}
+
+		for _, accessPoint := range output.AccessPoints {
+			// the same workspace id can exist on another cluster using the file system
+			cluster := getEfsTag(accessPoint.Tags, clusterTagKey)
+			if getEfsTag(accessPoint.Tags, workspaceTagKey) == workspaceId && (cluster == "" || cluster == p.Config.ClusterID) {
+				accessPoints = append(accessPoints, accessPoint)
+			}
+		}
+	}
+
+	return accessPoints, nil
+}
+
+func getEfsTag(tags []efstypes.Tag, key string) string {
+	for _, tag := range tags {
+		if tag.Key != nil && *tag.Key == key && tag.Value != nil {
+			return *tag.Value
+		}
+	}
+
+	return ""
+}
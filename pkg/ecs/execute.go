@@ -8,6 +8,7 @@ import (
 	"os/exec"
 	"strconv"
 	"strings"
+	"time"
 
 	"github.com/aws/aws-sdk-go-v2/service/ssm"
 	"github.com/aws/session-manager-plugin/src/datachannel"
@@ -100,6 +101,17 @@ func startProxyCommand(ctx context.Context, target string, stdin io.Reader, stdo
 	cmd.Stdin = stdin
 	cmd.Stdout = stdout
 	cmd.Stderr = stderr
+
+	// interrupt the tunnel instead of killing it, so it can terminate the ssm session
+	cmd.Cancel = func() error {
+		err := cmd.Process.Signal(os.Interrupt)
+		if err != nil && !errors.Is(err, os.ErrProcessDone) {
+			return cmd.Process.Kill()
+		}
+
+		return err
+	}
+	cmd.WaitDelay = time.Second * 5
 	return cmd.Run()
 }
 
@@ -112,8 +124,10 @@ func getIDFromArn(arn string) string {
 	return taskArnSplitted[len(taskArnSplitted)-1]
 }
 
-func (p *EcsProvider) StartSession(target string, port int) error {
-	out, err := ssm.NewFromConfig(p.AwsConfig).StartSession(context.Background(), &ssm.StartSessionInput{
+// StartSession forwards stdin and stdout to the ssh server of the target until the session
+// ends or ctx is done, in which case the session is terminated
+func (p *EcsProvider) StartSession(ctx context.Context, target string, port int) error {
+	out, err := p.ssmClient.StartSession(ctx, &ssm.StartSessionInput{
 		Target:       options.Ptr(target),
 		DocumentName: options.Ptr("AWS-StartSSHSession"),
 		Parameters: map[string][]string{
@@ -131,5 +145,23 @@ func (p *EcsProvider) StartSession(target string, port int) error {
 	ssmSession.ClientId = uuid.NewString()
 	ssmSession.TargetId = target
 	ssmSession.DataChannel = &datachannel.DataChannel{}
-	return ssmSession.Execute(log.Logger(false, ssmSession.ClientId))
+
+	sessionChan := make(chan error, 1)
+	go func() {
+		sessionChan <- ssmSession.Execute(log.Logger(false, ssmSession.ClientId))
+	}()
+
+	select {
+	case err := <-sessionChan:
+		return err
+	case <-ctx.Done():
+		_, err = p.ssmClient.TerminateSession(context.WithoutCancel(ctx), &ssm.TerminateSessionInput{
+			SessionId: out.SessionId,
+		})
+		if err != nil {
+			return fmt.Errorf("terminate session: %w", err)
+		}
+
+		return nil
+	}
 }
@@ -0,0 +1,216 @@
+package fake
+
+import (
+	"context"
+	"fmt"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/aws/aws-sdk-go-v2/service/ec2"
+	"github.com/aws/aws-sdk-go-v2/service/ec2/types"
+)
+
+// EC2 is an in-memory EC2 API for EBS volumes and snapshots. Snapshots complete immediately.
+type EC2 struct {
+	m sync.Mutex
+
+	errors Errors
+
+	volumes   []types.Volume
+	snapshots []types.Snapshot
+}
+
+// NewEC2 creates an empty in-memory EC2 API
+func NewEC2() *EC2 {
+	return &EC2{
+		errors: Errors{},
+	}
+}
+
+// FailOn makes the given operation return err until it is reset with a nil error
+func (e *EC2) FailOn(operation string, err error) {
+	e.m.Lock()
+	defer e.m.Unlock()
+
+	e.errors.Set(operation, err)
+}
+
+// AddVolume adds a volume, e.g. one that ecs created for a task
+func (e *EC2) AddVolume(volume types.Volume) {
+	e.m.Lock()
+	defer e.m.Unlock()
+
+	if volume.VolumeId == nil {
+		volume.VolumeId = ptr(fmt.Sprintf("vol-%017d", len(e.volumes)))
+	}
+	e.volumes = append(e.volumes, volume)
+}
+
+// Volumes returns a copy of all volumes
+func (e *EC2) Volumes() []types.Volume {
+	e.m.Lock()
+	defer e.m.Unlock()
+
+	return append([]types.Volume{}, e.volumes...)
+}
+
+// Snapshots returns a copy of all snapshots
+func (e *EC2) Snapshots() []types.Snapshot {
+	e.m.Lock()
+	defer e.m.Unlock()
+
+	return append([]types.Snapshot{}, e.snapshots...)
+}
+
+func (e *EC2) DescribeVolumes(ctx context.Context, params *ec2.DescribeVolumesInput, optFns ...func(*ec2.Options)) (*ec2.DescribeVolumesOutput, error) {
+	e.m.Lock()
+	defer e.m.Unlock()
+	if err := e.errors.Get("DescribeVolumes"); err != nil {
+		return nil, err
+	}
+
+	volumes := []types.Volume{}
+	for _, volume := range e.volumes {
+		if len(params.VolumeIds) > 0 && !contains(params.VolumeIds, *volume.VolumeId) {
+			continue
+		} else if !matchesFilters(volume.Tags, params.Filters) {
+			continue
+		}
+
+		volumes = append(volumes, volume)
+	}
+
+	return &ec2.DescribeVolumesOutput{
+		Volumes: volumes,
+	}, nil
+}
+
+func (e *EC2) DeleteVolume(ctx context.Context, params *ec2.DeleteVolumeInput, optFns ...func(*ec2.Options)) (*ec2.DeleteVolumeOutput, error) {
+	e.m.Lock()
+	defer e.m.Unlock()
+	if err := e.errors.Get("DeleteVolume"); err != nil {
+		return nil, err
+	}
+
+	volumes := []types.Volume{}
+	for _, volume := range e.volumes {
+		if *volume.VolumeId == *params.VolumeId {
+			if volume.State != types.VolumeStateAvailable {
+				return nil, fmt.Errorf("volume %s is %s", *volume.VolumeId, volume.State)
+			}
+
+			continue
+		}
+
+		volumes = append(volumes, volume)
+	}
+	e.volumes = volumes
+
+	return &ec2.DeleteVolumeOutput{}, nil
+}
+
+func (e *EC2) CreateSnapshot(ctx context.Context, params *ec2.CreateSnapshotInput, optFns ...func(*ec2.Options)) (*ec2.CreateSnapshotOutput, error) {
+	e.m.Lock()
+	defer e.m.Unlock()
+	if err := e.errors.Get("CreateSnapshot"); err != nil {
+		return nil, err
+	}
+
+	tags := []types.Tag{}
+	for _, tagSpecification := range params.TagSpecifications {
+		tags = append(tags, tagSpecification.Tags...)
+	}
+
+	snapshot := types.Snapshot{
+		SnapshotId:  ptr(fmt.Sprintf("snap-%017d", len(e.snapshots))),
+		VolumeId:    params.VolumeId,
+		Description: params.Description,
+		State:       types.SnapshotStateCompleted,
+		StartTime:   ptr(time.Now()),
+		OwnerId:     ptr(AccountID),
+		Tags:        tags,
+	}
+	e.snapshots = append(e.snapshots, snapshot)
+
+	return &ec2.CreateSnapshotOutput{
+		SnapshotId: snapshot.SnapshotId,
+		VolumeId:   snapshot.VolumeId,
+		State:      snapshot.State,
+		StartTime:  snapshot.StartTime,
+		Tags:       snapshot.Tags,
+	}, nil
+}
+
+func (e *EC2) DescribeSnapshots(ctx context.Context, params *ec2.DescribeSnapshotsInput, optFns ...func(*ec2.Options)) (*ec2.DescribeSnapshotsOutput, error) {
+	e.m.Lock()
+	defer e.m.Unlock()
+	if err := e.errors.Get("DescribeSnapshots"); err != nil {
+		return nil, err
+	}
+
+	snapshots := []types.Snapshot{}
+	for _, snapshot := range e.snapshots {
+		if len(params.SnapshotIds) > 0 && !contains(params.SnapshotIds, *snapshot.SnapshotId) {
+			continue
+		} else if !matchesFilters(snapshot.Tags, params.Filters) {
+			continue
+		}
+
+		snapshots = append(snapshots, snapshot)
+	}
+
+	return &ec2.DescribeSnapshotsOutput{
+		Snapshots: snapshots,
+	}, nil
+}
+
+func (e *EC2) DeleteSnapshot(ctx context.Context, params *ec2.DeleteSnapshotInput, optFns ...func(*ec2.Options)) (*ec2.DeleteSnapshotOutput, error) {
+	e.m.Lock()
+	defer e.m.Unlock()
+	if err := e.errors.Get("DeleteSnapshot"); err != nil {
+		return nil, err
+	}
+
+	snapshots := []types.Snapshot{}
+	for _, snapshot := range e.snapshots {
+		if *snapshot.SnapshotId != *params.SnapshotId {
+			snapshots = append(snapshots, snapshot)
+		}
+	}
+	e.snapshots = snapshots
+
+	return &ec2.DeleteSnapshotOutput{}, nil
+}
+
+// matchesFilters supports tag:<key> filters
+func matchesFilters(tags []types.Tag, filters []types.Filter) bool {
+	for _, filter := range filters {
+		key, ok := strings.CutPrefix(*filter.Name, "tag:")
+		if !ok {
+			continue
+		}
+
+		found := false
+		for _, tag := range tags {
+			if *tag.Key == key && contains(filter.Values, *tag.Value) {
+				found = true
+			}
+		}
+		if !found {
+			return false
+		}
+	}
+
+	return true
+}
+
+func contains(values []string, value string) bool {
+	for _, v := range values {
+		if v == value {
+			return true
+		}
+	}
+
+	return false
+}
@@ -0,0 +1,843 @@
+package fake
+
+import (
+	"context"
+	"fmt"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/aws/aws-sdk-go-v2/service/ecs"
+	"github.com/aws/aws-sdk-go-v2/service/ecs/types"
+)
+
+const (
+	Region    = "us-east-1"
+	AccountID = "123456789012"
+)
+
+var (
+	startTransitions = []string{"PROVISIONING", "PENDING", "ACTIVATING", "RUNNING"}
+	stopTransitions  = []string{"DEACTIVATING", "STOPPING", "DEPROVISIONING", "STOPPED"}
+)
+
+// ECS is an in-memory ECS API. Tasks move one status further every time they are described,
+// so callers that poll see the same transitions as on a real cluster.
+type ECS struct {
+	m sync.Mutex
+
+	errors Errors
+
+	taskDefinitions []*types.TaskDefinition
+	// deletedTaskDefinitions can still be described while tasks reference them
+	deletedTaskDefinitions []*types.TaskDefinition
+	tags                   map[string][]types.Tag
+	tasks                  []*types.Task
+	taskFailures           []TaskFailure
+
+	// ContainerInstances are the instances tasks of the EC2 and EXTERNAL launch types are placed on
+	ContainerInstances []types.ContainerInstance
+	attributes         []types.Attribute
+
+	// SubnetAvailabilityZones maps subnets to availability zones, unknown subnets are in <Region>a
+	SubnetAvailabilityZones map[string]string
+	// UnavailableZones are availability zones without capacity, tasks placed there fail to start
+	UnavailableZones map[string]bool
+
+	// RunTaskInputs records every RunTask call
+	RunTaskInputs []*ecs.RunTaskInput
+	// OnRunTask is called with every task that is started
+	OnRunTask func(task types.Task)
+}
+
+// NewECS creates an empty in-memory ECS API
+func NewECS() *ECS {
+	return &ECS{
+		errors: Errors{},
+		tags:   map[string][]types.Tag{},
+	}
+}
+
+// FailOn makes the given operation return err until it is reset with a nil error
+func (e *ECS) FailOn(operation string, err error) {
+	e.m.Lock()
+	defer e.m.Unlock()
+
+	e.errors.Set(operation, err)
+}
+
+// TaskFailure describes how a task stops before it is running
+type TaskFailure struct {
+	StoppedReason string
+	StopCode      types.TaskStopCode
+
+	// ContainerReason and ExitCode are set on the devpod container if not empty
+	ContainerReason string
+	ExitCode        *int32
+}
+
+// FailNextTask makes the next task stop with the given reason before it is running
+func (e *ECS) FailNextTask(reason string) {
+	e.FailNextTaskWith(TaskFailure{StoppedReason: reason})
+}
+
+// FailNextTaskWith makes the next task stop with the given failure before it is running
+func (e *ECS) FailNextTaskWith(failure TaskFailure) {
+	e.m.Lock()
+	defer e.m.Unlock()
+
+	if failure.StopCode == "" {
+		failure.StopCode = types.TaskStopCodeTaskFailedToStart
+	}
+	e.taskFailures = append(e.taskFailures, failure)
+}
+
+// ForgetStoppedTasks removes all stopped tasks, like ECS does a while after they stopped
+func (e *ECS) ForgetStoppedTasks() {
+	e.m.Lock()
+	defer e.m.Unlock()
+
+	tasks := []*types.Task{}
+	for _, task := range e.tasks {
+		if *task.LastStatus != "STOPPED" {
+			tasks = append(tasks, task)
+		}
+	}
+	e.tasks = tasks
+}
+
+// Tasks returns a copy of all known tasks
+func (e *ECS) Tasks() []types.Task {
+	e.m.Lock()
+	defer e.m.Unlock()
+
+	tasks := []types.Task{}
+	for _, task := range e.tasks {
+		tasks = append(tasks, *task)
+	}
+	return tasks
+}
+
+// TaskDefinitions returns a copy of all known task definitions
+func (e *ECS) TaskDefinitions() []types.TaskDefinition {
+	e.m.Lock()
+	defer e.m.Unlock()
+
+	taskDefinitions := []types.TaskDefinition{}
+	for _, taskDefinition := range e.taskDefinitions {
+		taskDefinitions = append(taskDefinitions, *taskDefinition)
+	}
+	return taskDefinitions
+}
+
+// Attributes returns a copy of all container instance attributes
+func (e *ECS) Attributes() []types.Attribute {
+	e.m.Lock()
+	defer e.m.Unlock()
+
+	return append([]types.Attribute{}, e.attributes...)
+}
+
+func (e *ECS) RegisterTaskDefinition(ctx context.Context, params *ecs.RegisterTaskDefinitionInput, optFns ...func(*ecs.Options)) (*ecs.RegisterTaskDefinitionOutput, error) {
+	e.m.Lock()
+	defer e.m.Unlock()
+	if err := e.errors.Get("RegisterTaskDefinition"); err != nil {
+		return nil, err
+	} else if params.Family == nil || *params.Family == "" {
+		return nil, fmt.Errorf("family is required")
+	} else if len(params.ContainerDefinitions) == 0 {
+		return nil, fmt.Errorf("container definitions are required")
+	}
+
+	revision := int32(1)
+	for _, taskDefinition := range e.taskDefinitions {
+		if *taskDefinition.Family == *params.Family && taskDefinition.Revision >= revision {
+			revision = taskDefinition.Revision + 1
+		}
+	}
+
+	arn := fmt.Sprintf("arn:aws:ecs:%s:%s:task-definition/%s:%d", Region, AccountID, *params.Family, revision)
+	taskDefinition := &types.TaskDefinition{
+		TaskDefinitionArn:       &arn,
+		Family:                  params.Family,
+		Revision:                revision,
+		Status:                  types.TaskDefinitionStatusActive,
+		ContainerDefinitions:    params.ContainerDefinitions,
+		Cpu:                     params.Cpu,
+		Memory:                  params.Memory,
+		NetworkMode:             params.NetworkMode,
+		RequiresCompatibilities: params.RequiresCompatibilities,
+		RuntimePlatform:         params.RuntimePlatform,
+		TaskRoleArn:             params.TaskRoleArn,
+		ExecutionRoleArn:        params.ExecutionRoleArn,
+		Volumes:                 params.Volumes,
+		RegisteredAt:            ptr(time.Now()),
+	}
+	e.taskDefinitions = append(e.taskDefinitions, taskDefinition)
+	e.tags[arn] = append([]types.Tag{}, params.Tags...)
+
+	return &ecs.RegisterTaskDefinitionOutput{
+		TaskDefinition: ptr(*taskDefinition),
+		Tags:           params.Tags,
+	}, nil
+}
+
+func (e *ECS) DescribeTaskDefinition(ctx context.Context, params *ecs.DescribeTaskDefinitionInput, optFns ...func(*ecs.Options)) (*ecs.DescribeTaskDefinitionOutput, error) {
+	e.m.Lock()
+	defer e.m.Unlock()
+	if err := e.errors.Get("DescribeTaskDefinition"); err != nil {
+		return nil, err
+	}
+
+	taskDefinition := e.findTaskDefinition(*params.TaskDefinition)
+	if taskDefinition == nil {
+		taskDefinition = e.findDeletedTaskDefinition(*params.TaskDefinition)
+	}
+	if taskDefinition == nil {
+		return nil, &types.ClientException{Message: ptr("Unable to describe task definition.")}
+	}
+
+	output := &ecs.DescribeTaskDefinitionOutput{
+		TaskDefinition: ptr(*taskDefinition),
+	}
+	for _, field := range params.Include {
+		if field == types.TaskDefinitionFieldTags {
+			output.Tags = append([]types.Tag{}, e.tags[*taskDefinition.TaskDefinitionArn]...)
+		}
+	}
+
+	return output, nil
+}
+
+func (e *ECS) ListTaskDefinitions(ctx context.Context, params *ecs.ListTaskDefinitionsInput, optFns ...func(*ecs.Options)) (*ecs.ListTaskDefinitionsOutput, error) {
+	e.m.Lock()
+	defer e.m.Unlock()
+	if err := e.errors.Get("ListTaskDefinitions"); err != nil {
+		return nil, err
+	}
+
+	status := params.Status
+	if status == "" {
+		status = types.TaskDefinitionStatusActive
+	}
+
+	taskDefinitions := []*types.TaskDefinition{}
+	for _, taskDefinition := range e.taskDefinitions {
+		if taskDefinition.Status != status {
+			continue
+		} else if params.FamilyPrefix != nil && !strings.HasPrefix(*taskDefinition.Family, *params.FamilyPrefix) {
+			continue
+		}
+
+		taskDefinitions = append(taskDefinitions, taskDefinition)
+	}
+	sort.SliceStable(taskDefinitions, func(i, j int) bool {
+		if *taskDefinitions[i].Family != *taskDefinitions[j].Family {
+			return *taskDefinitions[i].Family < *taskDefinitions[j].Family
+		}
+
+		return taskDefinitions[i].Revision < taskDefinitions[j].Revision
+	})
+
+	arns := []string{}
+	for _, taskDefinition := range taskDefinitions {
+		arns = append(arns, *taskDefinition.TaskDefinitionArn)
+	}
+	if params.Sort == types.SortOrderDesc {
+		for i, j := 0, len(arns)-1; i < j; i, j = i+1, j-1 {
+			arns[i], arns[j] = arns[j], arns[i]
+		}
+	}
+
+	// like ecs, return 100 results per page by default
+	page, nextToken, err := paginate(arns, defaultMaxResults(params.MaxResults), params.NextToken)
+	if err != nil {
+		return nil, err
+	}
+
+	return &ecs.ListTaskDefinitionsOutput{
+		TaskDefinitionArns: page,
+		NextToken:          nextToken,
+	}, nil
+}
+
+func (e *ECS) DeregisterTaskDefinition(ctx context.Context, params *ecs.DeregisterTaskDefinitionInput, optFns ...func(*ecs.Options)) (*ecs.DeregisterTaskDefinitionOutput, error) {
+	e.m.Lock()
+	defer e.m.Unlock()
+	if err := e.errors.Get("DeregisterTaskDefinition"); err != nil {
+		return nil, err
+	}
+
+	taskDefinition := e.findTaskDefinition(*params.TaskDefinition)
+	if taskDefinition == nil {
+		return nil, &types.ClientException{Message: ptr("The specified task definition does not exist.")}
+	}
+
+	taskDefinition.Status = types.TaskDefinitionStatusInactive
+	return &ecs.DeregisterTaskDefinitionOutput{
+		TaskDefinition: ptr(*taskDefinition),
+	}, nil
+}
+
+func (e *ECS) DeleteTaskDefinitions(ctx context.Context, params *ecs.DeleteTaskDefinitionsInput, optFns ...func(*ecs.Options)) (*ecs.DeleteTaskDefinitionsOutput, error) {
+	e.m.Lock()
+	defer e.m.Unlock()
+	if err := e.errors.Get("DeleteTaskDefinitions"); err != nil {
+		return nil, err
+	} else if len(params.TaskDefinitions) > 10 {
+		return nil, &types.InvalidParameterException{Message: ptr("At most 10 task definitions can be deleted at once.")}
+	}
+
+	output := &ecs.DeleteTaskDefinitionsOutput{}
+	for _, arn := range params.TaskDefinitions {
+		taskDefinition := e.findTaskDefinition(arn)
+		if taskDefinition == nil {
+			output.Failures = append(output.Failures, types.Failure{Arn: ptr(arn), Reason: ptr("TASK_DEFINITION_NOT_FOUND")})
+			continue
+		} else if taskDefinition.Status != types.TaskDefinitionStatusInactive {
+			output.Failures = append(output.Failures, types.Failure{Arn: ptr(arn), Reason: ptr("The specified task definition is still in ACTIVE status. Please deregister the target and try again.")})
+			continue
+		}
+
+		taskDefinitions := []*types.TaskDefinition{}
+		for _, other := range e.taskDefinitions {
+			if other != taskDefinition {
+				taskDefinitions = append(taskDefinitions, other)
+			}
+		}
+		e.taskDefinitions = taskDefinitions
+		delete(e.tags, arn)
+
+		taskDefinition.Status = types.TaskDefinitionStatusDeleteInProgress
+		e.deletedTaskDefinitions = append(e.deletedTaskDefinitions, taskDefinition)
+		output.TaskDefinitions = append(output.TaskDefinitions, *taskDefinition)
+	}
+
+	return output, nil
+}
+
+// InterruptTask stops the given task like a reclaim of fargate spot capacity would
+func (e *ECS) InterruptTask(arn string) {
+	e.m.Lock()
+	defer e.m.Unlock()
+
+	task := e.findTask(arn)
+	if task != nil {
+		task.DesiredStatus = ptr("STOPPED")
+		task.StopCode = types.TaskStopCodeSpotInterruption
+		task.StoppedReason = ptr("Your Spot Task was interrupted.")
+	}
+}
+
+func (e *ECS) RunTask(ctx context.Context, params *ecs.RunTaskInput, optFns ...func(*ecs.Options)) (*ecs.RunTaskOutput, error) {
+	e.m.Lock()
+	defer e.m.Unlock()
+	e.RunTaskInputs = append(e.RunTaskInputs, params)
+	if err := e.errors.Get("RunTask"); err != nil {
+		return nil, err
+	}
+
+	taskDefinition := e.findTaskDefinition(*params.TaskDefinition)
+	if taskDefinition == nil {
+		return nil, &types.ClientException{Message: ptr("TaskDefinition not found.")}
+	} else if taskDefinition.Status != types.TaskDefinitionStatusActive {
+		return nil, &types.ClientException{Message: ptr("TaskDefinition is inactive")}
+	} else if params.LaunchType != "" && len(params.CapacityProviderStrategy) > 0 {
+		return nil, &types.InvalidParameterException{Message: ptr("Specifying both a launch type and capacity provider strategy is not supported.")}
+	}
+
+	// capacity providers other than fargate are backed by container instances
+	launchType := params.LaunchType
+	capacityProviderName := ""
+	if len(params.CapacityProviderStrategy) > 0 {
+		capacityProviderName = *params.CapacityProviderStrategy[0].CapacityProvider
+		launchType = types.LaunchTypeEc2
+		if strings.HasPrefix(capacityProviderName, "FARGATE") {
+			launchType = types.LaunchTypeFargate
+		}
+	}
+
+	task := &types.Task{
+		TaskArn:           ptr(fmt.Sprintf("arn:aws:ecs:%s:%s:task/%s/%d", Region, AccountID, clusterName(params.Cluster), time.Now().UnixNano())),
+		ClusterArn:        ptr(fmt.Sprintf("arn:aws:ecs:%s:%s:cluster/%s", Region, AccountID, clusterName(params.Cluster))),
+		TaskDefinitionArn: taskDefinition.TaskDefinitionArn,
+		Group:             ptr("family:" + *taskDefinition.Family),
+		LaunchType:        launchType,
+		DesiredStatus:     ptr("RUNNING"),
+		LastStatus:        ptr(startTransitions[0]),
+		CreatedAt:         ptr(time.Now()),
+		Tags:              params.Tags,
+		Containers: []types.Container{
+			{
+				Name:      ptr("devpod"),
+				RuntimeId: ptr(fmt.Sprintf("%d-devpod", time.Now().UnixNano())),
+			},
+		},
+	}
+
+	if capacityProviderName != "" {
+		task.CapacityProviderName = ptr(capacityProviderName)
+	}
+
+	// the task is placed in the first subnet
+	if params.NetworkConfiguration != nil && params.NetworkConfiguration.AwsvpcConfiguration != nil && len(params.NetworkConfiguration.AwsvpcConfiguration.Subnets) > 0 {
+		availabilityZone, ok := e.SubnetAvailabilityZones[params.NetworkConfiguration.AwsvpcConfiguration.Subnets[0]]
+		if !ok {
+			availabilityZone = Region + "a"
+		}
+
+		task.AvailabilityZone = ptr(availabilityZone)
+		if e.UnavailableZones[availabilityZone] {
+			e.taskFailures = append([]TaskFailure{{
+				StoppedReason: "Capacity is unavailable at this time. Please try again later or in a different availability zone",
+				StopCode:      types.TaskStopCodeTaskFailedToStart,
+			}}, e.taskFailures...)
+		}
+	}
+
+	// place the task on a container instance
+	if launchType == types.LaunchTypeEc2 || launchType == types.LaunchTypeExternal {
+		containerInstanceArn, ok := e.placeTask(params.PlacementConstraints)
+		if !ok {
+			return &ecs.RunTaskOutput{
+				Failures: []types.Failure{{Reason: ptr("RESOURCE:MEMORY")}},
+			}, nil
+		}
+
+		task.ContainerInstanceArn = ptr(containerInstanceArn)
+	}
+
+	// fail the task if requested
+	if len(e.taskFailures) > 0 {
+		task.DesiredStatus = ptr("STOPPED")
+		task.StoppedReason = ptr(e.taskFailures[0].StoppedReason)
+		task.StopCode = e.taskFailures[0].StopCode
+		if e.taskFailures[0].ContainerReason != "" {
+			task.Containers[0].Reason = ptr(e.taskFailures[0].ContainerReason)
+		}
+		task.Containers[0].ExitCode = e.taskFailures[0].ExitCode
+		e.taskFailures = e.taskFailures[1:]
+	}
+
+	e.tasks = append(e.tasks, task)
+	if e.OnRunTask != nil {
+		e.OnRunTask(*task)
+	}
+	return &ecs.RunTaskOutput{
+		Tasks: []types.Task{*task},
+	}, nil
+}
+
+func (e *ECS) StopTask(ctx context.Context, params *ecs.StopTaskInput, optFns ...func(*ecs.Options)) (*ecs.StopTaskOutput, error) {
+	e.m.Lock()
+	defer e.m.Unlock()
+	if err := e.errors.Get("StopTask"); err != nil {
+		return nil, err
+	}
+
+	task := e.findTask(*params.Task)
+	if task == nil {
+		return nil, &types.InvalidParameterException{Message: ptr("The referenced task was not found.")}
+	}
+
+	if *task.DesiredStatus != "STOPPED" {
+		task.DesiredStatus = ptr("STOPPED")
+		task.StopCode = types.TaskStopCodeUserInitiated
+		task.StoppedReason = ptr("Task stopped by user")
+		if params.Reason != nil {
+			task.StoppedReason = params.Reason
+		}
+	}
+
+	return &ecs.StopTaskOutput{
+		Task: ptr(*task),
+	}, nil
+}
+
+func (e *ECS) ListTasks(ctx context.Context, params *ecs.ListTasksInput, optFns ...func(*ecs.Options)) (*ecs.ListTasksOutput, error) {
+	e.m.Lock()
+	defer e.m.Unlock()
+	if err := e.errors.Get("ListTasks"); err != nil {
+		return nil, err
+	}
+
+	desiredStatus := string(params.DesiredStatus)
+	if desiredStatus == "" {
+		desiredStatus = "RUNNING"
+	}
+
+	arns := []string{}
+	for _, task := range e.tasks {
+		if *task.DesiredStatus != desiredStatus {
+			continue
+		} else if clusterName(task.ClusterArn) != clusterName(params.Cluster) {
+			continue
+		} else if params.Family != nil && *task.Group != "family:"+*params.Family {
+			continue
+		}
+
+		arns = append(arns, *task.TaskArn)
+	}
+
+	page, nextToken, err := paginate(arns, defaultMaxResults(params.MaxResults), params.NextToken)
+	if err != nil {
+		return nil, err
+	}
+
+	return &ecs.ListTasksOutput{
+		TaskArns:  page,
+		NextToken: nextToken,
+	}, nil
+}
+
+func (e *ECS) DescribeTasks(ctx context.Context, params *ecs.DescribeTasksInput, optFns ...func(*ecs.Options)) (*ecs.DescribeTasksOutput, error) {
+	e.m.Lock()
+	defer e.m.Unlock()
+	if err := e.errors.Get("DescribeTasks"); err != nil {
+		return nil, err
+	} else if len(params.Tasks) > 100 {
+		return nil, &types.InvalidParameterException{Message: ptr("Tasks cannot be longer than 100.")}
+	}
+
+	output := &ecs.DescribeTasksOutput{}
+	for _, arn := range params.Tasks {
+		task := e.findTask(arn)
+		if task == nil {
+			output.Failures = append(output.Failures, types.Failure{Arn: ptr(arn), Reason: ptr("MISSING")})
+			continue
+		}
+
+		advance(task)
+		output.Tasks = append(output.Tasks, *task)
+	}
+
+	return output, nil
+}
+
+func (e *ECS) ListContainerInstances(ctx context.Context, params *ecs.ListContainerInstancesInput, optFns ...func(*ecs.Options)) (*ecs.ListContainerInstancesOutput, error) {
+	e.m.Lock()
+	defer e.m.Unlock()
+	if err := e.errors.Get("ListContainerInstances"); err != nil {
+		return nil, err
+	}
+
+	arns := []string{}
+	for _, containerInstance := range e.ContainerInstances {
+		arns = append(arns, *containerInstance.ContainerInstanceArn)
+	}
+
+	page, nextToken, err := paginate(arns, params.MaxResults, params.NextToken)
+	if err != nil {
+		return nil, err
+	}
+
+	return &ecs.ListContainerInstancesOutput{
+		ContainerInstanceArns: page,
+		NextToken:             nextToken,
+	}, nil
+}
+
+func (e *ECS) DescribeContainerInstances(ctx context.Context, params *ecs.DescribeContainerInstancesInput, optFns ...func(*ecs.Options)) (*ecs.DescribeContainerInstancesOutput, error) {
+	e.m.Lock()
+	defer e.m.Unlock()
+	if err := e.errors.Get("DescribeContainerInstances"); err != nil {
+		return nil, err
+	} else if len(params.ContainerInstances) > 100 {
+		return nil, &types.InvalidParameterException{Message: ptr("ContainerInstances cannot be longer than 100.")}
+	}
+
+	output := &ecs.DescribeContainerInstancesOutput{}
+	for _, arn := range params.ContainerInstances {
+		found := false
+		for _, containerInstance := range e.ContainerInstances {
+			if *containerInstance.ContainerInstanceArn == arn {
+				containerInstance.Attributes = append(append([]types.Attribute{}, containerInstance.Attributes...), e.attributesOf(arn)...)
+				output.ContainerInstances = append(output.ContainerInstances, containerInstance)
+				found = true
+				break
+			}
+		}
+		if !found {
+			output.Failures = append(output.Failures, types.Failure{Arn: ptr(arn), Reason: ptr("MISSING")})
+		}
+	}
+
+	return output, nil
+}
+
+func (e *ECS) ListAttributes(ctx context.Context, params *ecs.ListAttributesInput, optFns ...func(*ecs.Options)) (*ecs.ListAttributesOutput, error) {
+	e.m.Lock()
+	defer e.m.Unlock()
+	if err := e.errors.Get("ListAttributes"); err != nil {
+		return nil, err
+	}
+
+	attributes := []types.Attribute{}
+	for _, attribute := range e.attributes {
+		if params.AttributeName != nil && *attribute.Name != *params.AttributeName {
+			continue
+		}
+
+		attributes = append(attributes, attribute)
+	}
+
+	return &ecs.ListAttributesOutput{
+		Attributes: attributes,
+	}, nil
+}
+
+func (e *ECS) PutAttributes(ctx context.Context, params *ecs.PutAttributesInput, optFns ...func(*ecs.Options)) (*ecs.PutAttributesOutput, error) {
+	e.m.Lock()
+	defer e.m.Unlock()
+	if err := e.errors.Get("PutAttributes"); err != nil {
+		return nil, err
+	}
+
+	for _, attribute := range params.Attributes {
+		e.removeAttribute(attribute)
+		e.attributes = append(e.attributes, attribute)
+	}
+
+	return &ecs.PutAttributesOutput{
+		Attributes: params.Attributes,
+	}, nil
+}
+
+func (e *ECS) DeleteAttributes(ctx context.Context, params *ecs.DeleteAttributesInput, optFns ...func(*ecs.Options)) (*ecs.DeleteAttributesOutput, error) {
+	e.m.Lock()
+	defer e.m.Unlock()
+	if err := e.errors.Get("DeleteAttributes"); err != nil {
+		return nil, err
+	}
+
+	for _, attribute := range params.Attributes {
+		e.removeAttribute(attribute)
+	}
+
+	return &ecs.DeleteAttributesOutput{
+		Attributes: params.Attributes,
+	}, nil
+}
+
+// DescribeClusters knows every cluster, as the provider only uses it to get the cluster arn
+func (e *ECS) DescribeClusters(ctx context.Context, params *ecs.DescribeClustersInput, optFns ...func(*ecs.Options)) (*ecs.DescribeClustersOutput, error) {
+	e.m.Lock()
+	defer e.m.Unlock()
+	if err := e.errors.Get("DescribeClusters"); err != nil {
+		return nil, err
+	}
+
+	output := &ecs.DescribeClustersOutput{}
+	for _, cluster := range params.Clusters {
+		output.Clusters = append(output.Clusters, types.Cluster{
+			ClusterName: ptr(clusterName(&cluster)),
+			ClusterArn:  ptr(fmt.Sprintf("arn:aws:ecs:%s:%s:cluster/%s", Region, AccountID, clusterName(&cluster))),
+			Status:      ptr("ACTIVE"),
+		})
+	}
+
+	return output, nil
+}
+
+func (e *ECS) TagResource(ctx context.Context, params *ecs.TagResourceInput, optFns ...func(*ecs.Options)) (*ecs.TagResourceOutput, error) {
+	e.m.Lock()
+	defer e.m.Unlock()
+	if err := e.errors.Get("TagResource"); err != nil {
+		return nil, err
+	}
+
+	tags, ok := e.tags[*params.ResourceArn]
+	if !ok {
+		return nil, &types.ResourceNotFoundException{Message: ptr("The specified resource could not be found.")}
+	}
+
+	for _, tag := range params.Tags {
+		found := false
+		for i := range tags {
+			if *tags[i].Key == *tag.Key {
+				tags[i].Value = tag.Value
+				found = true
+			}
+		}
+		if !found {
+			tags = append(tags, tag)
+		}
+	}
+	e.tags[*params.ResourceArn] = tags
+
+	return &ecs.TagResourceOutput{}, nil
+}
+
+// findTaskDefinition finds a task definition by arn, family:revision or family, in which
+// case the latest active revision is returned
+func (e *ECS) findTaskDefinition(name string) *types.TaskDefinition {
+	var latest *types.TaskDefinition
+	for _, taskDefinition := range e.taskDefinitions {
+		familyRevision := fmt.Sprintf("%s:%d", *taskDefinition.Family, taskDefinition.Revision)
+		if *taskDefinition.TaskDefinitionArn == name || familyRevision == name {
+			return taskDefinition
+		} else if *taskDefinition.Family == name && taskDefinition.Status == types.TaskDefinitionStatusActive {
+			if latest == nil || taskDefinition.Revision > latest.Revision {
+				latest = taskDefinition
+			}
+		}
+	}
+
+	return latest
+}
+
+func (e *ECS) findDeletedTaskDefinition(arn string) *types.TaskDefinition {
+	for _, taskDefinition := range e.deletedTaskDefinitions {
+		if *taskDefinition.TaskDefinitionArn == arn {
+			return taskDefinition
+		}
+	}
+
+	return nil
+}
+
+func (e *ECS) findTask(arn string) *types.Task {
+	for _, task := range e.tasks {
+		if *task.TaskArn == arn || strings.HasSuffix(*task.TaskArn, "/"+arn) {
+			return task
+		}
+	}
+
+	return nil
+}
+
+// placeTask returns the first active container instance that satisfies all memberOf
+// constraints of the form "attribute:<name> exists" or "attribute:<name> == <value>"
+func (e *ECS) placeTask(constraints []types.PlacementConstraint) (string, bool) {
+	for _, containerInstance := range e.ContainerInstances {
+		if containerInstance.Status != nil && *containerInstance.Status != "ACTIVE" {
+			continue
+		}
+
+		attributes := append(append([]types.Attribute{}, containerInstance.Attributes...), e.attributesOf(*containerInstance.ContainerInstanceArn)...)
+		matches := true
+		for _, constraint := range constraints {
+			if constraint.Type != types.PlacementConstraintTypeMemberOf || constraint.Expression == nil {
+				continue
+			}
+
+			expression, ok := strings.CutPrefix(*constraint.Expression, "attribute:")
+			if !ok {
+				continue
+			}
+			name, value, hasValue := strings.Cut(strings.TrimSuffix(expression, " exists"), " == ")
+
+			found := false
+			for _, attribute := range attributes {
+				if *attribute.Name == name && (!hasValue || (attribute.Value != nil && *attribute.Value == value)) {
+					found = true
+				}
+			}
+			if !found {
+				matches = false
+			}
+		}
+		if matches {
+			return *containerInstance.ContainerInstanceArn, true
+		}
+	}
+
+	return "", false
+}
+
+func (e *ECS) attributesOf(targetID string) []types.Attribute {
+	attributes := []types.Attribute{}
+	for _, attribute := range e.attributes {
+		if attribute.TargetId != nil && *attribute.TargetId == targetID {
+			attributes = append(attributes, attribute)
+		}
+	}
+
+	return attributes
+}
+
+func (e *ECS) removeAttribute(remove types.Attribute) {
+	attributes := []types.Attribute{}
+	for _, attribute := range e.attributes {
+		if *attribute.Name == *remove.Name && *attribute.TargetId == *remove.TargetId {
+			continue
+		}
+
+		attributes = append(attributes, attribute)
+	}
+	e.attributes = attributes
+}
+
+// advance moves the task one status closer to its desired status
+func advance(task *types.Task) {
+	transitions := startTransitions
+	if *task.DesiredStatus == "STOPPED" {
+		transitions = stopTransitions
+	}
+
+	for i, status := range transitions {
+		if status == *task.LastStatus {
+			if i+1 < len(transitions) {
+				task.LastStatus = ptr(transitions[i+1])
+			}
+			break
+		} else if i == len(transitions)-1 {
+			// task is not in the transitions yet
+			task.LastStatus = ptr(transitions[0])
+		}
+	}
+
+	if *task.LastStatus == "RUNNING" && task.StartedAt == nil {
+		task.StartedAt = ptr(time.Now())
+	} else if *task.LastStatus == "STOPPED" && task.StoppedAt == nil {
+		task.StoppedAt = ptr(time.Now())
+	}
+}
+
+func clusterName(cluster *string) string {
+	if cluster == nil || *cluster == "" {
+		return "default"
+	}
+
+	splitted := strings.Split(*cluster, "/")
+	return splitted[len(splitted)-1]
+}
+
+// paginate returns the page of items that starts at nextToken
+func paginate[K any](items []K, maxResults *int32, nextToken *string) ([]K, *string, error) {
+	start := 0
+	if nextToken != nil && *nextToken != "" {
+		var err error
+		start, err = strconv.Atoi(*nextToken)
+		if err != nil || start > len(items) {
+			return nil, nil, &types.InvalidParameterException{Message: ptr("Invalid nextToken")}
+		}
+	}
+
+	end := len(items)
+	if maxResults != nil && *maxResults > 0 && start+int(*maxResults) < end {
+		end = start + int(*maxResults)
+	}
+
+	var next *string
+	if end < len(items) {
+		next = ptr(strconv.Itoa(end))
+	}
+
+	return items[start:end], next, nil
+}
+
+// defaultMaxResults returns the page size ecs uses if maxResults isn't set
+func defaultMaxResults(maxResults *int32) *int32 {
+	if maxResults == nil || *maxResults == 0 {
+		return ptr(int32(100))
+	}
+
+	return maxResults
+}
+
+func ptr[K any](m K) *K {
+	return &m
+}
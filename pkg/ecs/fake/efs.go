@@ -0,0 +1,157 @@
+package fake
+
+import (
+	"context"
+	"fmt"
+	"slices"
+	"sync"
+
+	"github.com/aws/aws-sdk-go-v2/service/efs"
+	"github.com/aws/aws-sdk-go-v2/service/efs/types"
+)
+
+// EFS is an in-memory EFS API for access points
+type EFS struct {
+	m sync.Mutex
+
+	errors Errors
+
+	accessPoints []types.AccessPointDescription
+}
+
+// NewEFS creates an empty in-memory EFS API
+func NewEFS() *EFS {
+	return &EFS{
+		errors: Errors{},
+	}
+}
+
+// FailOn makes the given operation return err until it is reset with a nil error
+func (e *EFS) FailOn(operation string, err error) {
+	e.m.Lock()
+	defer e.m.Unlock()
+
+	e.errors.Set(operation, err)
+}
+
+// AccessPoints returns a copy of all access points
+func (e *EFS) AccessPoints() []types.AccessPointDescription {
+	e.m.Lock()
+	defer e.m.Unlock()
+
+	return append([]types.AccessPointDescription{}, e.accessPoints...)
+}
+
+func (e *EFS) CreateAccessPoint(ctx context.Context, params *efs.CreateAccessPointInput, optFns ...func(*efs.Options)) (*efs.CreateAccessPointOutput, error) {
+	e.m.Lock()
+	defer e.m.Unlock()
+	if err := e.errors.Get("CreateAccessPoint"); err != nil {
+		return nil, err
+	}
+
+	id := fmt.Sprintf("fsap-%017d", len(e.accessPoints))
+	accessPoint := types.AccessPointDescription{
+		AccessPointId:  ptr(id),
+		AccessPointArn: ptr(fmt.Sprintf("arn:aws:elasticfilesystem:%s:%s:access-point/%s", Region, AccountID, id)),
+		ClientToken:    params.ClientToken,
+		FileSystemId:   params.FileSystemId,
+		LifeCycleState: types.LifeCycleStateAvailable,
+		PosixUser:      params.PosixUser,
+		RootDirectory:  params.RootDirectory,
+		Tags:           params.Tags,
+		OwnerId:        ptr(AccountID),
+	}
+	e.accessPoints = append(e.accessPoints, accessPoint)
+
+	return &efs.CreateAccessPointOutput{
+		AccessPointId:  accessPoint.AccessPointId,
+		AccessPointArn: accessPoint.AccessPointArn,
+		ClientToken:    accessPoint.ClientToken,
+		FileSystemId:   accessPoint.FileSystemId,
+		LifeCycleState: accessPoint.LifeCycleState,
+		RootDirectory:  accessPoint.RootDirectory,
+		Tags:           accessPoint.Tags,
+	}, nil
+}
+
+func (e *EFS) DescribeAccessPoints(ctx context.Context, params *efs.DescribeAccessPointsInput, optFns ...func(*efs.Options)) (*efs.DescribeAccessPointsOutput, error) {
+	e.m.Lock()
+	defer e.m.Unlock()
+	if err := e.errors.Get("DescribeAccessPoints"); err != nil {
+		return nil, err
+	}
+
+	accessPoints := []types.AccessPointDescription{}
+	for _, accessPoint := range e.accessPoints {
+		if params.AccessPointId != nil && *accessPoint.AccessPointId != *params.AccessPointId {
+			continue
+		} else if params.FileSystemId != nil && *accessPoint.FileSystemId != *params.FileSystemId {
+			continue
+		}
+
+		accessPoints = append(accessPoints, accessPoint)
+	}
+	if params.AccessPointId != nil && len(accessPoints) == 0 {
+		return nil, &types.AccessPointNotFound{Message: ptr("Access point not found")}
+	}
+
+	page, nextToken, err := paginate(accessPoints, params.MaxResults, params.NextToken)
+	if err != nil {
+		return nil, err
+	}
+
+	return &efs.DescribeAccessPointsOutput{
+		AccessPoints: page,
+		NextToken:    nextToken,
+	}, nil
+}
+
+func (e *EFS) DeleteAccessPoint(ctx context.Context, params *efs.DeleteAccessPointInput, optFns ...func(*efs.Options)) (*efs.DeleteAccessPointOutput, error) {
+	e.m.Lock()
+	defer e.m.Unlock()
+	if err := e.errors.Get("DeleteAccessPoint"); err != nil {
+		return nil, err
+	}
+
+	accessPoints := []types.AccessPointDescription{}
+	found := false
+	for _, accessPoint := range e.accessPoints {
+		if *accessPoint.AccessPointId == *params.AccessPointId {
+			found = true
+			continue
+		}
+
+		accessPoints = append(accessPoints, accessPoint)
+	}
+	if !found {
+		return nil, &types.AccessPointNotFound{Message: ptr("Access point not found")}
+	}
+	e.accessPoints = accessPoints
+
+	return &efs.DeleteAccessPointOutput{}, nil
+}
+
+func (e *EFS) TagResource(ctx context.Context, params *efs.TagResourceInput, optFns ...func(*efs.Options)) (*efs.TagResourceOutput, error) {
+	e.m.Lock()
+	defer e.m.Unlock()
+	if err := e.errors.Get("TagResource"); err != nil {
+		return nil, err
+	}
+
+	for i, accessPoint := range e.accessPoints {
+		if *accessPoint.AccessPointId != *params.ResourceId {
+			continue
+		}
+
+		tags := []types.Tag{}
+		for _, tag := range accessPoint.Tags {
+			if !slices.ContainsFunc(params.Tags, func(newTag types.Tag) bool { return *newTag.Key == *tag.Key }) {
+				tags = append(tags, tag)
+			}
+		}
+		e.accessPoints[i].Tags = append(tags, params.Tags...)
+		return &efs.TagResourceOutput{}, nil
+	}
+
+	return nil, &types.AccessPointNotFound{Message: ptr("Access point not found")}
+}
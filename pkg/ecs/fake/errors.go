@@ -0,0 +1,19 @@
+package fake
+
+// Errors holds the errors the fake APIs return per operation
+type Errors map[string]error
+
+// Set makes the operation return err, a nil error resets the operation
+func (e Errors) Set(operation string, err error) {
+	if err == nil {
+		delete(e, operation)
+		return
+	}
+
+	e[operation] = err
+}
+
+// Get returns the error for the operation, if any
+func (e Errors) Get(operation string) error {
+	return e[operation]
+}
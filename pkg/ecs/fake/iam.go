@@ -0,0 +1,564 @@
+package fake
+
+import (
+	"context"
+	"fmt"
+	"net/url"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/aws/aws-sdk-go-v2/service/iam"
+	"github.com/aws/aws-sdk-go-v2/service/iam/types"
+)
+
+// IAM is an in-memory IAM API
+type IAM struct {
+	m sync.Mutex
+
+	errors Errors
+
+	roles          map[string]*types.Role
+	policies       map[string]*types.Policy
+	policyVersions map[string][]types.PolicyVersion
+
+	attachedPolicies map[string][]string
+	rolePolicies     map[string]map[string]string
+}
+
+// NewIAM creates an empty in-memory IAM API
+func NewIAM() *IAM {
+	return &IAM{
+		errors:           Errors{},
+		roles:            map[string]*types.Role{},
+		policies:         map[string]*types.Policy{},
+		policyVersions:   map[string][]types.PolicyVersion{},
+		attachedPolicies: map[string][]string{},
+		rolePolicies:     map[string]map[string]string{},
+	}
+}
+
+// FailOn makes the given operation return err until it is reset with a nil error
+func (i *IAM) FailOn(operation string, err error) {
+	i.m.Lock()
+	defer i.m.Unlock()
+
+	i.errors.Set(operation, err)
+}
+
+// Roles returns the names of all roles
+func (i *IAM) Roles() []string {
+	i.m.Lock()
+	defer i.m.Unlock()
+
+	names := []string{}
+	for name := range i.roles {
+		names = append(names, name)
+	}
+	return names
+}
+
+// Policies returns the arns of all policies
+func (i *IAM) Policies() []string {
+	i.m.Lock()
+	defer i.m.Unlock()
+
+	arns := []string{}
+	for arn := range i.policies {
+		arns = append(arns, arn)
+	}
+	return arns
+}
+
+// AttachedPolicies returns the arns of the policies attached to the role
+func (i *IAM) AttachedPolicies(roleName string) []string {
+	i.m.Lock()
+	defer i.m.Unlock()
+
+	return append([]string{}, i.attachedPolicies[roleName]...)
+}
+
+// PolicyDocument returns the default version of the policy document
+func (i *IAM) PolicyDocument(arn string) string {
+	i.m.Lock()
+	defer i.m.Unlock()
+
+	for _, version := range i.policyVersions[arn] {
+		if version.IsDefaultVersion {
+			return *version.Document
+		}
+	}
+	return ""
+}
+
+// RolePolicy returns the document of the inline policy of the role
+func (i *IAM) RolePolicy(roleName, policyName string) string {
+	i.m.Lock()
+	defer i.m.Unlock()
+
+	return i.rolePolicies[roleName][policyName]
+}
+
+// SetPolicyDocument adds a new default version of the policy document, like a change
+// outside of DevPod would
+func (i *IAM) SetPolicyDocument(arn, document string) {
+	i.m.Lock()
+	defer i.m.Unlock()
+
+	i.addPolicyVersion(arn, document)
+}
+
+// DetachPolicy detaches the policy from the role, like a change outside of DevPod would
+func (i *IAM) DetachPolicy(roleName, arn string) {
+	i.m.Lock()
+	defer i.m.Unlock()
+
+	i.detachPolicy(roleName, arn)
+}
+
+func (i *IAM) GetRole(ctx context.Context, params *iam.GetRoleInput, optFns ...func(*iam.Options)) (*iam.GetRoleOutput, error) {
+	i.m.Lock()
+	defer i.m.Unlock()
+	if err := i.errors.Get("GetRole"); err != nil {
+		return nil, err
+	}
+
+	role, ok := i.roles[*params.RoleName]
+	if !ok {
+		return nil, &types.NoSuchEntityException{Message: ptr(fmt.Sprintf("The role with name %s cannot be found.", *params.RoleName))}
+	}
+
+	return &iam.GetRoleOutput{
+		Role: ptr(*role),
+	}, nil
+}
+
+func (i *IAM) CreateRole(ctx context.Context, params *iam.CreateRoleInput, optFns ...func(*iam.Options)) (*iam.CreateRoleOutput, error) {
+	i.m.Lock()
+	defer i.m.Unlock()
+	if err := i.errors.Get("CreateRole"); err != nil {
+		return nil, err
+	} else if _, ok := i.roles[*params.RoleName]; ok {
+		return nil, &types.EntityAlreadyExistsException{Message: ptr(fmt.Sprintf("Role with name %s already exists.", *params.RoleName))}
+	}
+
+	path := "/"
+	if params.Path != nil {
+		path = *params.Path
+	}
+
+	role := &types.Role{
+		RoleName:                 params.RoleName,
+		Path:                     ptr(path),
+		Arn:                      ptr(fmt.Sprintf("arn:aws:iam::%s:role%s%s", AccountID, path, *params.RoleName)),
+		AssumeRolePolicyDocument: params.AssumeRolePolicyDocument,
+		Tags:                     params.Tags,
+	}
+	if params.PermissionsBoundary != nil {
+		role.PermissionsBoundary = &types.AttachedPermissionsBoundary{
+			PermissionsBoundaryArn:  params.PermissionsBoundary,
+			PermissionsBoundaryType: types.PermissionsBoundaryAttachmentTypePolicy,
+		}
+	}
+	i.roles[*params.RoleName] = role
+
+	return &iam.CreateRoleOutput{
+		Role: ptr(*role),
+	}, nil
+}
+
+func (i *IAM) DeleteRole(ctx context.Context, params *iam.DeleteRoleInput, optFns ...func(*iam.Options)) (*iam.DeleteRoleOutput, error) {
+	i.m.Lock()
+	defer i.m.Unlock()
+	if err := i.errors.Get("DeleteRole"); err != nil {
+		return nil, err
+	} else if _, ok := i.roles[*params.RoleName]; !ok {
+		return nil, &types.NoSuchEntityException{Message: ptr(fmt.Sprintf("The role with name %s cannot be found.", *params.RoleName))}
+	} else if len(i.attachedPolicies[*params.RoleName]) > 0 {
+		return nil, &types.DeleteConflictException{Message: ptr("Cannot delete entity, must detach all policies first.")}
+	} else if len(i.rolePolicies[*params.RoleName]) > 0 {
+		return nil, &types.DeleteConflictException{Message: ptr("Cannot delete entity, must delete policies first.")}
+	}
+
+	delete(i.roles, *params.RoleName)
+	return &iam.DeleteRoleOutput{}, nil
+}
+
+func (i *IAM) CreatePolicy(ctx context.Context, params *iam.CreatePolicyInput, optFns ...func(*iam.Options)) (*iam.CreatePolicyOutput, error) {
+	i.m.Lock()
+	defer i.m.Unlock()
+	if err := i.errors.Get("CreatePolicy"); err != nil {
+		return nil, err
+	}
+
+	path := "/"
+	if params.Path != nil {
+		path = *params.Path
+	}
+
+	arn := fmt.Sprintf("arn:aws:iam::%s:policy%s%s", AccountID, path, *params.PolicyName)
+	if _, ok := i.policies[arn]; ok {
+		return nil, &types.EntityAlreadyExistsException{Message: ptr(fmt.Sprintf("A policy called %s already exists.", *params.PolicyName))}
+	}
+
+	policy := &types.Policy{
+		PolicyName:       params.PolicyName,
+		Path:             ptr(path),
+		Arn:              ptr(arn),
+		DefaultVersionId: ptr("v1"),
+		Tags:             params.Tags,
+	}
+	i.policies[arn] = policy
+	i.addPolicyVersion(arn, *params.PolicyDocument)
+
+	return &iam.CreatePolicyOutput{
+		Policy: ptr(*policy),
+	}, nil
+}
+
+func (i *IAM) DeletePolicy(ctx context.Context, params *iam.DeletePolicyInput, optFns ...func(*iam.Options)) (*iam.DeletePolicyOutput, error) {
+	i.m.Lock()
+	defer i.m.Unlock()
+	if err := i.errors.Get("DeletePolicy"); err != nil {
+		return nil, err
+	} else if _, ok := i.policies[*params.PolicyArn]; !ok {
+		return nil, &types.NoSuchEntityException{Message: ptr(fmt.Sprintf("Policy %s was not found.", *params.PolicyArn))}
+	}
+
+	for _, arns := range i.attachedPolicies {
+		for _, arn := range arns {
+			if arn == *params.PolicyArn {
+				return nil, &types.DeleteConflictException{Message: ptr("Cannot delete a policy attached to entities.")}
+			}
+		}
+	}
+
+	if len(i.policyVersions[*params.PolicyArn]) > 1 {
+		return nil, &types.DeleteConflictException{Message: ptr("Cannot delete a policy with non-default versions.")}
+	}
+
+	delete(i.policies, *params.PolicyArn)
+	delete(i.policyVersions, *params.PolicyArn)
+	return &iam.DeletePolicyOutput{}, nil
+}
+
+func (i *IAM) AttachRolePolicy(ctx context.Context, params *iam.AttachRolePolicyInput, optFns ...func(*iam.Options)) (*iam.AttachRolePolicyOutput, error) {
+	i.m.Lock()
+	defer i.m.Unlock()
+	if err := i.errors.Get("AttachRolePolicy"); err != nil {
+		return nil, err
+	} else if _, ok := i.roles[*params.RoleName]; !ok {
+		return nil, &types.NoSuchEntityException{Message: ptr(fmt.Sprintf("The role with name %s cannot be found.", *params.RoleName))}
+	}
+
+	for _, arn := range i.attachedPolicies[*params.RoleName] {
+		if arn == *params.PolicyArn {
+			return &iam.AttachRolePolicyOutput{}, nil
+		}
+	}
+
+	i.attachedPolicies[*params.RoleName] = append(i.attachedPolicies[*params.RoleName], *params.PolicyArn)
+	return &iam.AttachRolePolicyOutput{}, nil
+}
+
+func (i *IAM) ListRoles(ctx context.Context, params *iam.ListRolesInput, optFns ...func(*iam.Options)) (*iam.ListRolesOutput, error) {
+	i.m.Lock()
+	defer i.m.Unlock()
+	if err := i.errors.Get("ListRoles"); err != nil {
+		return nil, err
+	}
+
+	names := []string{}
+	for name, role := range i.roles {
+		if params.PathPrefix == nil || strings.HasPrefix(*role.Path, *params.PathPrefix) {
+			names = append(names, name)
+		}
+	}
+	sort.Strings(names)
+
+	// like IAM, the roles are listed without their tags
+	output := &iam.ListRolesOutput{}
+	for _, name := range names {
+		role := *i.roles[name]
+		role.Tags = nil
+		output.Roles = append(output.Roles, role)
+	}
+
+	return output, nil
+}
+
+func (i *IAM) ListRoleTags(ctx context.Context, params *iam.ListRoleTagsInput, optFns ...func(*iam.Options)) (*iam.ListRoleTagsOutput, error) {
+	i.m.Lock()
+	defer i.m.Unlock()
+	if err := i.errors.Get("ListRoleTags"); err != nil {
+		return nil, err
+	}
+
+	role, ok := i.roles[*params.RoleName]
+	if !ok {
+		return nil, &types.NoSuchEntityException{Message: ptr(fmt.Sprintf("The role with name %s cannot be found.", *params.RoleName))}
+	}
+
+	return &iam.ListRoleTagsOutput{
+		Tags: append([]types.Tag{}, role.Tags...),
+	}, nil
+}
+
+func (i *IAM) ListPolicies(ctx context.Context, params *iam.ListPoliciesInput, optFns ...func(*iam.Options)) (*iam.ListPoliciesOutput, error) {
+	i.m.Lock()
+	defer i.m.Unlock()
+	if err := i.errors.Get("ListPolicies"); err != nil {
+		return nil, err
+	}
+
+	arns := []string{}
+	for arn, policy := range i.policies {
+		if params.PathPrefix == nil || strings.HasPrefix(*policy.Path, *params.PathPrefix) {
+			arns = append(arns, arn)
+		}
+	}
+	sort.Strings(arns)
+
+	// like IAM, the policies are listed without their tags
+	output := &iam.ListPoliciesOutput{}
+	for _, arn := range arns {
+		policy := *i.policies[arn]
+		policy.Tags = nil
+		output.Policies = append(output.Policies, policy)
+	}
+
+	return output, nil
+}
+
+func (i *IAM) ListPolicyTags(ctx context.Context, params *iam.ListPolicyTagsInput, optFns ...func(*iam.Options)) (*iam.ListPolicyTagsOutput, error) {
+	i.m.Lock()
+	defer i.m.Unlock()
+	if err := i.errors.Get("ListPolicyTags"); err != nil {
+		return nil, err
+	}
+
+	policy, ok := i.policies[*params.PolicyArn]
+	if !ok {
+		return nil, &types.NoSuchEntityException{Message: ptr(fmt.Sprintf("Policy %s was not found.", *params.PolicyArn))}
+	}
+
+	return &iam.ListPolicyTagsOutput{
+		Tags: append([]types.Tag{}, policy.Tags...),
+	}, nil
+}
+
+// PutRolePolicy adds or replaces an inline policy
+func (i *IAM) PutRolePolicy(ctx context.Context, params *iam.PutRolePolicyInput, optFns ...func(*iam.Options)) (*iam.PutRolePolicyOutput, error) {
+	i.m.Lock()
+	defer i.m.Unlock()
+	if err := i.errors.Get("PutRolePolicy"); err != nil {
+		return nil, err
+	} else if _, ok := i.roles[*params.RoleName]; !ok {
+		return nil, &types.NoSuchEntityException{Message: ptr(fmt.Sprintf("The role with name %s cannot be found.", *params.RoleName))}
+	}
+
+	if i.rolePolicies[*params.RoleName] == nil {
+		i.rolePolicies[*params.RoleName] = map[string]string{}
+	}
+	i.rolePolicies[*params.RoleName][*params.PolicyName] = *params.PolicyDocument
+	return &iam.PutRolePolicyOutput{}, nil
+}
+
+func (i *IAM) ListRolePolicies(ctx context.Context, params *iam.ListRolePoliciesInput, optFns ...func(*iam.Options)) (*iam.ListRolePoliciesOutput, error) {
+	i.m.Lock()
+	defer i.m.Unlock()
+	if err := i.errors.Get("ListRolePolicies"); err != nil {
+		return nil, err
+	} else if _, ok := i.roles[*params.RoleName]; !ok {
+		return nil, &types.NoSuchEntityException{Message: ptr(fmt.Sprintf("The role with name %s cannot be found.", *params.RoleName))}
+	}
+
+	names := []string{}
+	for name := range i.rolePolicies[*params.RoleName] {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+
+	return &iam.ListRolePoliciesOutput{
+		PolicyNames: names,
+	}, nil
+}
+
+func (i *IAM) DeleteRolePolicy(ctx context.Context, params *iam.DeleteRolePolicyInput, optFns ...func(*iam.Options)) (*iam.DeleteRolePolicyOutput, error) {
+	i.m.Lock()
+	defer i.m.Unlock()
+	if err := i.errors.Get("DeleteRolePolicy"); err != nil {
+		return nil, err
+	} else if _, ok := i.rolePolicies[*params.RoleName][*params.PolicyName]; !ok {
+		return nil, &types.NoSuchEntityException{Message: ptr(fmt.Sprintf("The role policy with name %s cannot be found.", *params.PolicyName))}
+	}
+
+	delete(i.rolePolicies[*params.RoleName], *params.PolicyName)
+	return &iam.DeleteRolePolicyOutput{}, nil
+}
+
+func (i *IAM) DetachRolePolicy(ctx context.Context, params *iam.DetachRolePolicyInput, optFns ...func(*iam.Options)) (*iam.DetachRolePolicyOutput, error) {
+	i.m.Lock()
+	defer i.m.Unlock()
+	if err := i.errors.Get("DetachRolePolicy"); err != nil {
+		return nil, err
+	} else if !i.detachPolicy(*params.RoleName, *params.PolicyArn) {
+		return nil, &types.NoSuchEntityException{Message: ptr(fmt.Sprintf("Policy %s was not found.", *params.PolicyArn))}
+	}
+
+	return &iam.DetachRolePolicyOutput{}, nil
+}
+
+func (i *IAM) ListAttachedRolePolicies(ctx context.Context, params *iam.ListAttachedRolePoliciesInput, optFns ...func(*iam.Options)) (*iam.ListAttachedRolePoliciesOutput, error) {
+	i.m.Lock()
+	defer i.m.Unlock()
+	if err := i.errors.Get("ListAttachedRolePolicies"); err != nil {
+		return nil, err
+	} else if _, ok := i.roles[*params.RoleName]; !ok {
+		return nil, &types.NoSuchEntityException{Message: ptr(fmt.Sprintf("The role with name %s cannot be found.", *params.RoleName))}
+	}
+
+	output := &iam.ListAttachedRolePoliciesOutput{}
+	for _, arn := range i.attachedPolicies[*params.RoleName] {
+		output.AttachedPolicies = append(output.AttachedPolicies, types.AttachedPolicy{
+			PolicyArn:  ptr(arn),
+			PolicyName: ptr(arn[strings.LastIndex(arn, "/")+1:]),
+		})
+	}
+
+	return output, nil
+}
+
+func (i *IAM) GetPolicy(ctx context.Context, params *iam.GetPolicyInput, optFns ...func(*iam.Options)) (*iam.GetPolicyOutput, error) {
+	i.m.Lock()
+	defer i.m.Unlock()
+	if err := i.errors.Get("GetPolicy"); err != nil {
+		return nil, err
+	}
+
+	policy, ok := i.policies[*params.PolicyArn]
+	if !ok {
+		return nil, &types.NoSuchEntityException{Message: ptr(fmt.Sprintf("Policy %s was not found.", *params.PolicyArn))}
+	}
+
+	return &iam.GetPolicyOutput{
+		Policy: ptr(*policy),
+	}, nil
+}
+
+// GetPolicyVersion returns the document url encoded like IAM does
+func (i *IAM) GetPolicyVersion(ctx context.Context, params *iam.GetPolicyVersionInput, optFns ...func(*iam.Options)) (*iam.GetPolicyVersionOutput, error) {
+	i.m.Lock()
+	defer i.m.Unlock()
+	if err := i.errors.Get("GetPolicyVersion"); err != nil {
+		return nil, err
+	}
+
+	for _, version := range i.policyVersions[*params.PolicyArn] {
+		if *version.VersionId == *params.VersionId {
+			version.Document = ptr(url.QueryEscape(*version.Document))
+			return &iam.GetPolicyVersionOutput{
+				PolicyVersion: &version,
+			}, nil
+		}
+	}
+
+	return nil, &types.NoSuchEntityException{Message: ptr(fmt.Sprintf("Policy %s version %s does not exist.", *params.PolicyArn, *params.VersionId))}
+}
+
+func (i *IAM) ListPolicyVersions(ctx context.Context, params *iam.ListPolicyVersionsInput, optFns ...func(*iam.Options)) (*iam.ListPolicyVersionsOutput, error) {
+	i.m.Lock()
+	defer i.m.Unlock()
+	if err := i.errors.Get("ListPolicyVersions"); err != nil {
+		return nil, err
+	} else if _, ok := i.policies[*params.PolicyArn]; !ok {
+		return nil, &types.NoSuchEntityException{Message: ptr(fmt.Sprintf("Policy %s was not found.", *params.PolicyArn))}
+	}
+
+	output := &iam.ListPolicyVersionsOutput{}
+	for _, version := range i.policyVersions[*params.PolicyArn] {
+		version.Document = nil
+		output.Versions = append(output.Versions, version)
+	}
+
+	return output, nil
+}
+
+func (i *IAM) CreatePolicyVersion(ctx context.Context, params *iam.CreatePolicyVersionInput, optFns ...func(*iam.Options)) (*iam.CreatePolicyVersionOutput, error) {
+	i.m.Lock()
+	defer i.m.Unlock()
+	if err := i.errors.Get("CreatePolicyVersion"); err != nil {
+		return nil, err
+	} else if _, ok := i.policies[*params.PolicyArn]; !ok {
+		return nil, &types.NoSuchEntityException{Message: ptr(fmt.Sprintf("Policy %s was not found.", *params.PolicyArn))}
+	} else if len(i.policyVersions[*params.PolicyArn]) >= 5 {
+		return nil, &types.LimitExceededException{Message: ptr("A managed policy can have up to 5 versions.")}
+	} else if !params.SetAsDefault {
+		return nil, &types.InvalidInputException{Message: ptr("The fake only supports new default versions.")}
+	}
+
+	version := i.addPolicyVersion(*params.PolicyArn, *params.PolicyDocument)
+	return &iam.CreatePolicyVersionOutput{
+		PolicyVersion: &version,
+	}, nil
+}
+
+func (i *IAM) DeletePolicyVersion(ctx context.Context, params *iam.DeletePolicyVersionInput, optFns ...func(*iam.Options)) (*iam.DeletePolicyVersionOutput, error) {
+	i.m.Lock()
+	defer i.m.Unlock()
+	if err := i.errors.Get("DeletePolicyVersion"); err != nil {
+		return nil, err
+	}
+
+	versions := i.policyVersions[*params.PolicyArn]
+	for index, version := range versions {
+		if *version.VersionId != *params.VersionId {
+			continue
+		} else if version.IsDefaultVersion {
+			return nil, &types.DeleteConflictException{Message: ptr("Cannot delete the default version of a policy.")}
+		}
+
+		i.policyVersions[*params.PolicyArn] = append(versions[:index:index], versions[index+1:]...)
+		return &iam.DeletePolicyVersionOutput{}, nil
+	}
+
+	return nil, &types.NoSuchEntityException{Message: ptr(fmt.Sprintf("Policy %s version %s does not exist.", *params.PolicyArn, *params.VersionId))}
+}
+
+// addPolicyVersion adds a new default version, the caller holds the lock
+func (i *IAM) addPolicyVersion(arn, document string) types.PolicyVersion {
+	number := 1
+	for index := range i.policyVersions[arn] {
+		i.policyVersions[arn][index].IsDefaultVersion = false
+
+		var existing int
+		_, _ = fmt.Sscanf(*i.policyVersions[arn][index].VersionId, "v%d", &existing)
+		number = max(number, existing+1)
+	}
+
+	version := types.PolicyVersion{
+		VersionId:        ptr(fmt.Sprintf("v%d", number)),
+		Document:         ptr(document),
+		IsDefaultVersion: true,
+		CreateDate:       ptr(time.Now()),
+	}
+	i.policyVersions[arn] = append(i.policyVersions[arn], version)
+	if policy, ok := i.policies[arn]; ok {
+		policy.DefaultVersionId = version.VersionId
+	}
+
+	return version
+}
+
+// detachPolicy detaches the policy from the role, the caller holds the lock
+func (i *IAM) detachPolicy(roleName, arn string) bool {
+	for index, attached := range i.attachedPolicies[roleName] {
+		if attached == arn {
+			i.attachedPolicies[roleName] = append(i.attachedPolicies[roleName][:index:index], i.attachedPolicies[roleName][index+1:]...)
+			return true
+		}
+	}
+
+	return false
+}
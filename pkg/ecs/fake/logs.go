@@ -0,0 +1,236 @@
+package fake
+
+import (
+	"context"
+	"fmt"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/aws/aws-sdk-go-v2/service/cloudwatchlogs"
+	"github.com/aws/aws-sdk-go-v2/service/cloudwatchlogs/types"
+)
+
+// Logs is an in-memory CloudWatch Logs API
+type Logs struct {
+	m sync.Mutex
+
+	errors Errors
+
+	logGroups map[string]*types.LogGroup
+	tags      map[string]map[string]string
+	streams   map[string]map[string]*logStream
+}
+
+type logStream struct {
+	stream types.LogStream
+	events []types.OutputLogEvent
+}
+
+// NewLogs creates an empty in-memory CloudWatch Logs API
+func NewLogs() *Logs {
+	return &Logs{
+		errors:    Errors{},
+		logGroups: map[string]*types.LogGroup{},
+		tags:      map[string]map[string]string{},
+		streams:   map[string]map[string]*logStream{},
+	}
+}
+
+// FailOn makes the given operation return err until it is reset with a nil error
+func (l *Logs) FailOn(operation string, err error) {
+	l.m.Lock()
+	defer l.m.Unlock()
+
+	l.errors.Set(operation, err)
+}
+
+// LogGroup returns a copy of the log group or nil if it doesn't exist
+func (l *Logs) LogGroup(name string) *types.LogGroup {
+	l.m.Lock()
+	defer l.m.Unlock()
+
+	logGroup, ok := l.logGroups[name]
+	if !ok {
+		return nil
+	}
+
+	return ptr(*logGroup)
+}
+
+// PutEvents appends messages to the log stream and creates the stream if needed, the
+// log group must exist
+func (l *Logs) PutEvents(logGroupName, logStreamName string, messages ...string) {
+	l.m.Lock()
+	defer l.m.Unlock()
+
+	if l.streams[logGroupName] == nil {
+		l.streams[logGroupName] = map[string]*logStream{}
+	}
+	stream, ok := l.streams[logGroupName][logStreamName]
+	if !ok {
+		stream = &logStream{stream: types.LogStream{
+			LogStreamName: ptr(logStreamName),
+			CreationTime:  ptr(time.Now().UnixMilli()),
+		}}
+		l.streams[logGroupName][logStreamName] = stream
+	}
+
+	for _, message := range messages {
+		now := time.Now().UnixMilli()
+		stream.events = append(stream.events, types.OutputLogEvent{
+			Message:       ptr(message),
+			Timestamp:     ptr(now),
+			IngestionTime: ptr(now),
+		})
+		stream.stream.LastEventTimestamp = ptr(now)
+	}
+}
+
+func (l *Logs) CreateLogGroup(ctx context.Context, params *cloudwatchlogs.CreateLogGroupInput, optFns ...func(*cloudwatchlogs.Options)) (*cloudwatchlogs.CreateLogGroupOutput, error) {
+	l.m.Lock()
+	defer l.m.Unlock()
+	if err := l.errors.Get("CreateLogGroup"); err != nil {
+		return nil, err
+	} else if _, ok := l.logGroups[*params.LogGroupName]; ok {
+		return nil, &types.ResourceAlreadyExistsException{Message: ptr("The specified log group already exists")}
+	}
+
+	l.logGroups[*params.LogGroupName] = &types.LogGroup{
+		LogGroupName: params.LogGroupName,
+		Arn:          ptr(fmt.Sprintf("arn:aws:logs:%s:%s:log-group:%s:*", Region, AccountID, *params.LogGroupName)),
+		LogGroupArn:  ptr(fmt.Sprintf("arn:aws:logs:%s:%s:log-group:%s", Region, AccountID, *params.LogGroupName)),
+		CreationTime: ptr(time.Now().UnixMilli()),
+	}
+	l.tags[*params.LogGroupName] = params.Tags
+	return &cloudwatchlogs.CreateLogGroupOutput{}, nil
+}
+
+func (l *Logs) DeleteLogGroup(ctx context.Context, params *cloudwatchlogs.DeleteLogGroupInput, optFns ...func(*cloudwatchlogs.Options)) (*cloudwatchlogs.DeleteLogGroupOutput, error) {
+	l.m.Lock()
+	defer l.m.Unlock()
+	if err := l.errors.Get("DeleteLogGroup"); err != nil {
+		return nil, err
+	} else if _, ok := l.logGroups[*params.LogGroupName]; !ok {
+		return nil, &types.ResourceNotFoundException{Message: ptr("The specified log group does not exist.")}
+	}
+
+	delete(l.logGroups, *params.LogGroupName)
+	delete(l.streams, *params.LogGroupName)
+	delete(l.tags, *params.LogGroupName)
+	return &cloudwatchlogs.DeleteLogGroupOutput{}, nil
+}
+
+// ListTagsForResource accepts log group arns without the :* suffix
+func (l *Logs) ListTagsForResource(ctx context.Context, params *cloudwatchlogs.ListTagsForResourceInput, optFns ...func(*cloudwatchlogs.Options)) (*cloudwatchlogs.ListTagsForResourceOutput, error) {
+	l.m.Lock()
+	defer l.m.Unlock()
+	if err := l.errors.Get("ListTagsForResource"); err != nil {
+		return nil, err
+	}
+
+	for name, logGroup := range l.logGroups {
+		if *logGroup.LogGroupArn == *params.ResourceArn {
+			tags := map[string]string{}
+			for key, value := range l.tags[name] {
+				tags[key] = value
+			}
+
+			return &cloudwatchlogs.ListTagsForResourceOutput{
+				Tags: tags,
+			}, nil
+		}
+	}
+
+	return nil, &types.ResourceNotFoundException{Message: ptr("The specified resource does not exist.")}
+}
+
+func (l *Logs) DescribeLogGroups(ctx context.Context, params *cloudwatchlogs.DescribeLogGroupsInput, optFns ...func(*cloudwatchlogs.Options)) (*cloudwatchlogs.DescribeLogGroupsOutput, error) {
+	l.m.Lock()
+	defer l.m.Unlock()
+	if err := l.errors.Get("DescribeLogGroups"); err != nil {
+		return nil, err
+	}
+
+	output := &cloudwatchlogs.DescribeLogGroupsOutput{}
+	for name, logGroup := range l.logGroups {
+		if params.LogGroupNamePrefix == nil || strings.HasPrefix(name, *params.LogGroupNamePrefix) {
+			output.LogGroups = append(output.LogGroups, *logGroup)
+		}
+	}
+
+	return output, nil
+}
+
+func (l *Logs) PutRetentionPolicy(ctx context.Context, params *cloudwatchlogs.PutRetentionPolicyInput, optFns ...func(*cloudwatchlogs.Options)) (*cloudwatchlogs.PutRetentionPolicyOutput, error) {
+	l.m.Lock()
+	defer l.m.Unlock()
+	if err := l.errors.Get("PutRetentionPolicy"); err != nil {
+		return nil, err
+	}
+
+	logGroup, ok := l.logGroups[*params.LogGroupName]
+	if !ok {
+		return nil, &types.ResourceNotFoundException{Message: ptr("The specified log group does not exist.")}
+	}
+
+	logGroup.RetentionInDays = params.RetentionInDays
+	return &cloudwatchlogs.PutRetentionPolicyOutput{}, nil
+}
+
+func (l *Logs) DescribeLogStreams(ctx context.Context, params *cloudwatchlogs.DescribeLogStreamsInput, optFns ...func(*cloudwatchlogs.Options)) (*cloudwatchlogs.DescribeLogStreamsOutput, error) {
+	l.m.Lock()
+	defer l.m.Unlock()
+	if err := l.errors.Get("DescribeLogStreams"); err != nil {
+		return nil, err
+	} else if _, ok := l.logGroups[*params.LogGroupName]; !ok {
+		return nil, &types.ResourceNotFoundException{Message: ptr("The specified log group does not exist.")}
+	}
+
+	output := &cloudwatchlogs.DescribeLogStreamsOutput{}
+	for name, stream := range l.streams[*params.LogGroupName] {
+		if params.LogStreamNamePrefix == nil || strings.HasPrefix(name, *params.LogStreamNamePrefix) {
+			output.LogStreams = append(output.LogStreams, stream.stream)
+		}
+	}
+
+	return output, nil
+}
+
+// GetLogEvents returns forward tokens of the form f/<index of the next event>
+func (l *Logs) GetLogEvents(ctx context.Context, params *cloudwatchlogs.GetLogEventsInput, optFns ...func(*cloudwatchlogs.Options)) (*cloudwatchlogs.GetLogEventsOutput, error) {
+	l.m.Lock()
+	defer l.m.Unlock()
+	if err := l.errors.Get("GetLogEvents"); err != nil {
+		return nil, err
+	}
+
+	stream, ok := l.streams[*params.LogGroupName][*params.LogStreamName]
+	if !ok {
+		return nil, &types.ResourceNotFoundException{Message: ptr("The specified log stream does not exist.")}
+	}
+
+	limit := len(stream.events)
+	if params.Limit != nil && int(*params.Limit) < limit {
+		limit = int(*params.Limit)
+	}
+
+	start, end := 0, 0
+	if params.NextToken != nil {
+		index, err := strconv.Atoi(strings.TrimPrefix(*params.NextToken, "f/"))
+		if err != nil {
+			return nil, &types.InvalidParameterException{Message: ptr("The specified nextToken is invalid.")}
+		}
+		start, end = index, min(index+limit, len(stream.events))
+	} else if params.StartFromHead != nil && *params.StartFromHead {
+		start, end = 0, limit
+	} else {
+		start, end = len(stream.events)-limit, len(stream.events)
+	}
+
+	return &cloudwatchlogs.GetLogEventsOutput{
+		Events:           append([]types.OutputLogEvent{}, stream.events[start:end]...),
+		NextForwardToken: ptr(fmt.Sprintf("f/%d", end)),
+	}, nil
+}
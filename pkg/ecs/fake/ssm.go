@@ -0,0 +1,109 @@
+package fake
+
+import (
+	"context"
+	"fmt"
+	"sync"
+
+	"github.com/aws/aws-sdk-go-v2/service/ssm"
+	"github.com/aws/aws-sdk-go-v2/service/ssm/types"
+)
+
+// SSM is an in-memory SSM API that runs commands through a callback
+type SSM struct {
+	m sync.Mutex
+
+	errors Errors
+
+	// RunCommand is called for every instance a command is sent to and returns its output
+	RunCommand func(instanceID string, commands []string) string
+
+	invocations map[string]*ssm.GetCommandInvocationOutput
+}
+
+// NewSSM creates an empty in-memory SSM API
+func NewSSM() *SSM {
+	return &SSM{
+		errors:      Errors{},
+		invocations: map[string]*ssm.GetCommandInvocationOutput{},
+	}
+}
+
+// FailOn makes the given operation return err until it is reset with a nil error
+func (s *SSM) FailOn(operation string, err error) {
+	s.m.Lock()
+	defer s.m.Unlock()
+
+	s.errors.Set(operation, err)
+}
+
+func (s *SSM) StartSession(ctx context.Context, params *ssm.StartSessionInput, optFns ...func(*ssm.Options)) (*ssm.StartSessionOutput, error) {
+	s.m.Lock()
+	defer s.m.Unlock()
+	if err := s.errors.Get("StartSession"); err != nil {
+		return nil, err
+	}
+
+	return &ssm.StartSessionOutput{
+		SessionId:  ptr(fmt.Sprintf("session-%d", len(s.invocations))),
+		StreamUrl:  ptr("wss://ssmmessages." + Region + ".amazonaws.com/v1/data-channel/session"),
+		TokenValue: ptr("token"),
+	}, nil
+}
+
+func (s *SSM) TerminateSession(ctx context.Context, params *ssm.TerminateSessionInput, optFns ...func(*ssm.Options)) (*ssm.TerminateSessionOutput, error) {
+	s.m.Lock()
+	defer s.m.Unlock()
+	if err := s.errors.Get("TerminateSession"); err != nil {
+		return nil, err
+	}
+
+	return &ssm.TerminateSessionOutput{
+		SessionId: params.SessionId,
+	}, nil
+}
+
+func (s *SSM) SendCommand(ctx context.Context, params *ssm.SendCommandInput, optFns ...func(*ssm.Options)) (*ssm.SendCommandOutput, error) {
+	s.m.Lock()
+	defer s.m.Unlock()
+	if err := s.errors.Get("SendCommand"); err != nil {
+		return nil, err
+	}
+
+	commandID := fmt.Sprintf("command-%d", len(s.invocations))
+	for _, instanceID := range params.InstanceIds {
+		output := ""
+		if s.RunCommand != nil {
+			output = s.RunCommand(instanceID, params.Parameters["commands"])
+		}
+
+		s.invocations[commandID+"/"+instanceID] = &ssm.GetCommandInvocationOutput{
+			CommandId:             ptr(commandID),
+			InstanceId:            ptr(instanceID),
+			Status:                types.CommandInvocationStatusSuccess,
+			StandardOutputContent: ptr(output),
+		}
+	}
+
+	return &ssm.SendCommandOutput{
+		Command: &types.Command{
+			CommandId:   ptr(commandID),
+			InstanceIds: params.InstanceIds,
+		},
+	}, nil
+}
+
+func (s *SSM) GetCommandInvocation(ctx context.Context, params *ssm.GetCommandInvocationInput, optFns ...func(*ssm.Options)) (*ssm.GetCommandInvocationOutput, error) {
+	s.m.Lock()
+	defer s.m.Unlock()
+	if err := s.errors.Get("GetCommandInvocation"); err != nil {
+		return nil, err
+	}
+
+	invocation, ok := s.invocations[*params.CommandId+"/"+*params.InstanceId]
+	if !ok {
+		return nil, &types.InvocationDoesNotExist{Message: ptr("invocation does not exist")}
+	}
+
+	return ptr(*invocation), nil
+}
@@ -2,95 +2,662 @@ package ecs
 
 import (
 	"context"
+	"encoding/json"
 	"errors"
 	"fmt"
-	"net/http"
+	"net/url"
+	"reflect"
+	"sort"
+	"strings"
 
-	awshttp "github.com/aws/aws-sdk-go-v2/aws/transport/http"
+	"github.com/aws/aws-sdk-go-v2/aws"
+	"github.com/aws/aws-sdk-go-v2/service/ecs"
 	"github.com/aws/aws-sdk-go-v2/service/iam"
+	iamtypes "github.com/aws/aws-sdk-go-v2/service/iam/types"
+	"github.com/loft-sh/devpod-provider-ecs/pkg/hash"
 	"github.com/loft-sh/devpod-provider-ecs/pkg/options"
 )
 
-var (
-	devPodRoleName   = "devpod-ecs-role"
-	devPodPolicyName = "devpod-ecs-policy"
-)
+// maxRoleNameLength is the longest role name IAM accepts
+const maxRoleNameLength = 64
 
-func (p *EcsProvider) createIamRole(ctx context.Context) (string, error) {
-	// check for role
-	iamClient := iam.NewFromConfig(p.AwsConfig)
-	role, err := iamClient.GetRole(ctx, &iam.GetRoleInput{
-		RoleName: &devPodRoleName,
-	})
+// iamRole is a role devpod creates together with a customer managed policy that is
+// attached to it
+type iamRole struct {
+	Name        string
+	PolicyName  string
+	TrustPolicy policyDocument
+	Policy      policyDocument
+}
+
+type policyDocument struct {
+	Version   string            `json:"Version"`
+	Statement []policyStatement `json:"Statement"`
+}
+
+type policyStatement struct {
+	Effect    string                    `json:"Effect"`
+	Principal map[string]string         `json:"Principal,omitempty"`
+	Action    []string                  `json:"Action"`
+	Resource  []string                  `json:"Resource,omitempty"`
+	Condition map[string]map[string]any `json:"Condition,omitempty"`
+}
+
+// clusterArn holds the fields of the cluster arn the policies are scoped with
+type clusterArn struct {
+	Partition string
+	Region    string
+	AccountID string
+	Name      string
+}
+
+// ensureRoles creates or repairs the task role of the cluster and the execution role of the
+// workspace if TASK_ROLE_ARN or EXECUTION_ROLE_ARN are unset
+func (p *EcsProvider) ensureRoles(ctx context.Context, workspaceId string) error {
+	if p.Config.TaskRoleARN != "" && p.Config.ExecutionRoleARN != "" {
+		return nil
+	}
+
+	cluster, err := p.getClusterArn(ctx)
+	if err != nil {
+		return err
+	}
+
+	if p.Config.TaskRoleARN == "" {
+		p.Config.TaskRoleARN, err = p.ensureRole(ctx, cluster, p.getTaskRole(cluster))
+		if err != nil {
+			return err
+		}
+	}
+	if p.Config.ExecutionRoleARN == "" {
+		role := p.getExecutionRole(cluster, newWorkspace(workspaceId))
+		p.Config.ExecutionRoleARN, err = p.ensureRole(ctx, cluster, role)
+		if err != nil {
+			return err
+		}
+
+		err = p.ensureWorkspacePolicy(ctx, role.Name, newWorkspace(workspaceId), p.getWorkspacePolicy(cluster))
+		if err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+// getTaskRole returns the role the workspace container runs with, which only needs to open
+// the ssm channels for ECS Exec and to mount the efs file system
+func (p *EcsProvider) getTaskRole(cluster clusterArn) iamRole {
+	name := p.getRoleName(cluster, "task")
+	role := iamRole{
+		Name:       name,
+		PolicyName: name + "-policy",
+		TrustPolicy: policyDocument{
+			Version: "2012-10-17",
+			Statement: []policyStatement{
+				{
+					Effect:    "Allow",
+					Principal: map[string]string{"Service": "ecs-tasks.amazonaws.com"},
+					Action:    []string{"sts:AssumeRole"},
+					Condition: map[string]map[string]any{
+						// only tasks of this cluster can assume the role
+						"ArnLike": {
+							"aws:SourceArn": []string{
+								fmt.Sprintf("arn:%s:ecs:%s:%s:cluster/%s", cluster.Partition, cluster.Region, cluster.AccountID, cluster.Name),
+								fmt.Sprintf("arn:%s:ecs:%s:%s:task/%s/*", cluster.Partition, cluster.Region, cluster.AccountID, cluster.Name),
+							},
+						},
+						"StringEquals": {
+							"aws:SourceAccount": cluster.AccountID,
+						},
+					},
+				},
+			},
+		},
+		Policy: policyDocument{
+			Version: "2012-10-17",
+			Statement: []policyStatement{
+				{
+					Effect: "Allow",
+					Action: []string{
+						"ssmmessages:CreateControlChannel",
+						"ssmmessages:CreateDataChannel",
+						"ssmmessages:OpenControlChannel",
+						"ssmmessages:OpenDataChannel",
+					},
+					Resource: []string{"*"},
+				},
+			},
+		},
+	}
+	if p.Config.EfsFileSystemID != "" {
+		role.Policy.Statement = append(role.Policy.Statement, policyStatement{
+			Effect: "Allow",
+			Action: []string{
+				"elasticfilesystem:ClientMount",
+				"elasticfilesystem:ClientWrite",
+				"elasticfilesystem:ClientRootAccess",
+			},
+			Resource: []string{
+				fmt.Sprintf("arn:%s:elasticfilesystem:%s:%s:file-system/%s", cluster.Partition, cluster.Region, cluster.AccountID, p.Config.EfsFileSystemID),
+			},
+		})
+	}
+
+	return role
+}
+
+// getExecutionRole returns the role ECS pulls the image, writes the logs and reads the
+// secrets of the workspace with. Every workspace gets its own role, as IAM limits the size
+// of the inline policies of a role. The managed policy holds what all workspaces of the
+// cluster need and is shared, see getWorkspacePolicy for the rest.
+func (p *EcsProvider) getExecutionRole(cluster clusterArn, workspace workspace) iamRole {
+	role := iamRole{
+		Name:       p.getRoleName(cluster, "execution-"+hash.String(workspace.Family)[:8]),
+		PolicyName: p.getRoleName(cluster, "execution") + "-policy",
+		TrustPolicy: policyDocument{
+			Version: "2012-10-17",
+			Statement: []policyStatement{
+				{
+					Effect:    "Allow",
+					Principal: map[string]string{"Service": "ecs-tasks.amazonaws.com"},
+					Action:    []string{"sts:AssumeRole"},
+				},
+			},
+		},
+		Policy: policyDocument{
+			Version: "2012-10-17",
+			Statement: []policyStatement{
+				{
+					Effect: "Allow",
+					Action: []string{
+						"ecr:GetAuthorizationToken",
+						"ecr:BatchCheckLayerAvailability",
+						"ecr:GetDownloadUrlForLayer",
+						"ecr:BatchGetImage",
+					},
+					Resource: []string{"*"},
+				},
+			},
+		},
+	}
+
+	return role
+}
+
+// getWorkspacePolicy returns the permissions of the execution role that depend on the
+// options of the workspace: writing to its log group and reading its secrets
+func (p *EcsProvider) getWorkspacePolicy(cluster clusterArn) policyDocument {
+	document := policyDocument{
+		Version:   "2012-10-17",
+		Statement: []policyStatement{},
+	}
+	if p.Config.CloudWatchLogs {
+		document.Statement = append(document.Statement, policyStatement{
+			Effect: "Allow",
+			Action: []string{
+				"logs:CreateLogStream",
+				"logs:PutLogEvents",
+			},
+			Resource: []string{
+				fmt.Sprintf("arn:%s:logs:%s:%s:log-group:%s:*", cluster.Partition, cluster.Region, cluster.AccountID, p.Config.LogGroup),
+			},
+		})
+	}
+
+	// allow the role to pull the image with the registry credentials and read the secrets
+	secretArns, parameterArns := []string{}, []string{}
+	valueFroms := []string{}
+	if p.Config.RegistryCredentialsSecretARN != "" {
+		valueFroms = append(valueFroms, p.Config.RegistryCredentialsSecretARN)
+	}
+	for _, secret := range p.Config.Secrets {
+		valueFroms = append(valueFroms, secret.ValueFrom)
+	}
+	for _, valueFrom := range valueFroms {
+		if strings.Contains(valueFrom, ":ssm:") {
+			parameterArns = appendUnique(parameterArns, valueFrom)
+		} else if splitted := strings.Split(valueFrom, ":"); len(splitted) > 7 {
+			// strip the json key, version stage and version id
+			secretArns = appendUnique(secretArns, strings.Join(splitted[:7], ":"))
+		} else {
+			secretArns = appendUnique(secretArns, valueFrom)
+		}
+	}
+	if len(secretArns) > 0 {
+		document.Statement = append(document.Statement, policyStatement{
+			Effect:   "Allow",
+			Action:   []string{"secretsmanager:GetSecretValue"},
+			Resource: secretArns,
+		})
+	}
+	if len(parameterArns) > 0 {
+		document.Statement = append(document.Statement, policyStatement{
+			Effect:   "Allow",
+			Action:   []string{"ssm:GetParameters"},
+			Resource: parameterArns,
+		})
+	}
+	// a secret encrypted with a customer managed key can only be read with access to the key
+	if p.Config.RegistryCredentialsKmsKeyARN != "" {
+		document.Statement = append(document.Statement, policyStatement{
+			Effect:   "Allow",
+			Action:   []string{"kms:Decrypt"},
+			Resource: []string{p.Config.RegistryCredentialsKmsKeyARN},
+		})
+	}
+
+	return document
+}
+
+// getRoleName returns <prefix>-<cluster>-<kind> or, if that is too long for IAM, uses a
+// hash of the cluster name instead
+func (p *EcsProvider) getRoleName(cluster clusterArn, kind string) string {
+	// leave room for the -policy suffix of the policy name
+	name := p.Config.RoleNamePrefix + "-" + cluster.Name + "-" + kind
+	if len(name+"-policy") > maxRoleNameLength {
+		name = p.Config.RoleNamePrefix + "-" + hash.String(cluster.Name)[:8] + "-" + kind
+	}
+
+	return name
+}
+
+// ensureRole creates the role and its policy if they don't exist. An existing role is
+// repaired: a missing policy is created, a policy with a stale document gets a new
+// default version and the policy is attached if it was detached.
+func (p *EcsProvider) ensureRole(ctx context.Context, cluster clusterArn, role iamRole) (string, error) {
+	policyArn, created, err := p.ensurePolicy(ctx, cluster, role.PolicyName, role.Policy)
+	if err != nil {
+		return "", err
+	}
+
+	roleArn, err := p.ensureRoleExists(ctx, role.Name, role.TrustPolicy, p.getClusterIamTags())
 	if err != nil {
-		var re *awshttp.ResponseError
-		if !errors.As(err, &re) || re.HTTPStatusCode() != http.StatusNotFound {
-			return "", err
+		// don't leave a new policy behind
+		if created {
+			_, _ = p.iamClient.DeletePolicy(ctx, &iam.DeletePolicyInput{PolicyArn: options.Ptr(policyArn)})
 		}
-	} else {
+		return "", err
+	}
+
+	err = p.ensurePolicyAttached(ctx, role.Name, policyArn)
+	if err != nil {
+		return "", err
+	}
+
+	return roleArn, nil
+}
+
+// ensureWorkspacePolicy puts the permissions of the workspace into an inline policy of its
+// execution role named after the workspace family, or removes it if there are none
+func (p *EcsProvider) ensureWorkspacePolicy(ctx context.Context, roleName string, workspace workspace, document policyDocument) error {
+	if len(document.Statement) == 0 {
+		return p.deleteWorkspacePolicy(ctx, roleName, workspace)
+	}
+
+	rawDocument, err := json.Marshal(document)
+	if err != nil {
+		return err
+	}
+
+	_, err = p.iamClient.PutRolePolicy(ctx, &iam.PutRolePolicyInput{
+		RoleName:       options.Ptr(roleName),
+		PolicyName:     options.Ptr(workspace.Family),
+		PolicyDocument: options.Ptr(string(rawDocument)),
+	})
+	if err != nil {
+		return fmt.Errorf("put iam policy %s of role %s: %w", workspace.Family, roleName, err)
+	}
+
+	return nil
+}
+
+// deleteExecutionRole deletes the execution role of the workspace if devpod manages it.
+// The shared managed policy of the role is kept for the other workspaces.
+func (p *EcsProvider) deleteExecutionRole(ctx context.Context, workspaceId string) error {
+	cluster, err := p.getClusterArn(ctx)
+	if err != nil {
+		return err
+	}
+
+	// EXECUTION_ROLE_ARN is set, or was set by ensureRoles for this workspace
+	roleName := p.getExecutionRole(cluster, newWorkspace(workspaceId)).Name
+	if p.Config.ExecutionRoleARN != "" && getIDFromArn(p.Config.ExecutionRoleARN) != roleName {
+		return nil
+	}
+
+	err = p.deleteRole(ctx, roleName)
+	var notFound *iamtypes.NoSuchEntityException
+	if err != nil && !errors.As(err, &notFound) {
+		return fmt.Errorf("delete iam role %s: %w", roleName, err)
+	}
+
+	return nil
+}
+
+func (p *EcsProvider) deleteWorkspacePolicy(ctx context.Context, roleName string, workspace workspace) error {
+	_, err := p.iamClient.DeleteRolePolicy(ctx, &iam.DeleteRolePolicyInput{
+		RoleName:   options.Ptr(roleName),
+		PolicyName: options.Ptr(workspace.Family),
+	})
+	var notFound *iamtypes.NoSuchEntityException
+	if err != nil && !errors.As(err, &notFound) {
+		return fmt.Errorf("delete iam policy %s of role %s: %w", workspace.Family, roleName, err)
+	}
+
+	return nil
+}
+
+// ensureRoleExists returns the arn of the role and creates it with the given tags if it
+// doesn't exist
+func (p *EcsProvider) ensureRoleExists(ctx context.Context, roleName string, trustPolicy policyDocument, tags []iamtypes.Tag) (string, error) {
+	role, err := p.iamClient.GetRole(ctx, &iam.GetRoleInput{
+		RoleName: options.Ptr(roleName),
+	})
+	if err == nil {
 		return *role.Role.Arn, nil
 	}
 
-	// create policy
-	p.Log.Infof("Create iam policy %s...", devPodPolicyName)
-	policyOutput, err := iamClient.CreatePolicy(ctx, &iam.CreatePolicyInput{
-		PolicyName: &devPodPolicyName,
-		PolicyDocument: options.Ptr(`{
-    "Version": "2012-10-17",
-    "Statement": [
-        {
-            "Action": [
-                "ecs:ExecuteCommand",
-                "ssmmessages:CreateControlChannel",
-                "ssmmessages:CreateDataChannel",
-                "ssmmessages:OpenControlChannel",
-                "ssmmessages:OpenDataChannel",
-                "logs:CreateLogStream",
-                "logs:PutLogEvents"
-            ],
-            "Effect": "Allow",
-            "Resource": "*"
-        }
-    ]
-}`),
+	var notFound *iamtypes.NoSuchEntityException
+	if !errors.As(err, &notFound) {
+		return "", fmt.Errorf("get iam role %s: %w", roleName, err)
+	}
+
+	document, err := json.Marshal(trustPolicy)
+	if err != nil {
+		return "", err
+	}
+
+	p.Log.Infof("Create iam role %s...", roleName)
+	createRoleInput := &iam.CreateRoleInput{
+		RoleName:                 options.Ptr(roleName),
+		Path:                     options.Ptr(p.Config.IamPath),
+		AssumeRolePolicyDocument: options.Ptr(string(document)),
+		Tags:                     tags,
+	}
+	if p.Config.PermissionsBoundaryARN != "" {
+		createRoleInput.PermissionsBoundary = options.Ptr(p.Config.PermissionsBoundaryARN)
+	}
+	roleOutput, err := p.iamClient.CreateRole(ctx, createRoleInput)
+	if err != nil {
+		return "", fmt.Errorf("create iam role %s: %w", roleName, err)
+	}
+
+	return *roleOutput.Role.Arn, nil
+}
+
+// ensurePolicy creates the customer managed policy or updates its document if it changed
+// and returns its arn and if it was created
+func (p *EcsProvider) ensurePolicy(ctx context.Context, cluster clusterArn, policyName string, document policyDocument) (string, bool, error) {
+	policyArn := fmt.Sprintf("arn:%s:iam::%s:policy%s%s", cluster.Partition, cluster.AccountID, p.Config.IamPath, policyName)
+	rawDocument, err := json.Marshal(document)
+	if err != nil {
+		return "", false, err
+	}
+
+	policy, err := p.iamClient.GetPolicy(ctx, &iam.GetPolicyInput{
+		PolicyArn: options.Ptr(policyArn),
+	})
+	if err != nil {
+		var notFound *iamtypes.NoSuchEntityException
+		if !errors.As(err, &notFound) {
+			return "", false, fmt.Errorf("get iam policy %s: %w", policyName, err)
+		}
+
+		p.Log.Infof("Create iam policy %s...", policyName)
+		policyOutput, err := p.iamClient.CreatePolicy(ctx, &iam.CreatePolicyInput{
+			PolicyName:     options.Ptr(policyName),
+			Path:           options.Ptr(p.Config.IamPath),
+			PolicyDocument: options.Ptr(string(rawDocument)),
+			Tags:           p.getClusterIamTags(),
+		})
+		if err != nil {
+			return "", false, fmt.Errorf("create iam policy %s: %w", policyName, err)
+		}
+
+		return *policyOutput.Policy.Arn, true, nil
+	}
+
+	// check if the policy is up to date
+	version, err := p.iamClient.GetPolicyVersion(ctx, &iam.GetPolicyVersionInput{
+		PolicyArn: options.Ptr(policyArn),
+		VersionId: policy.Policy.DefaultVersionId,
+	})
+	if err != nil {
+		return "", false, fmt.Errorf("get iam policy version %s: %w", policyName, err)
+	}
+	upToDate, err := isSamePolicyDocument(aws.ToString(version.PolicyVersion.Document), document)
+	if err != nil {
+		return "", false, fmt.Errorf("compare iam policy %s: %w", policyName, err)
+	} else if upToDate {
+		return policyArn, false, nil
+	}
+
+	// a policy has at most 5 versions, so delete the oldest non default one first
+	versions, err := p.iamClient.ListPolicyVersions(ctx, &iam.ListPolicyVersionsInput{
+		PolicyArn: options.Ptr(policyArn),
 	})
 	if err != nil {
-		return "", fmt.Errorf("create policy: %w", err)
-	}
-
-	// create role
-	p.Log.Infof("Create iam role %s...", devPodRoleName)
-	roleOutput, err := iamClient.CreateRole(ctx, &iam.CreateRoleInput{
-		RoleName: &devPodRoleName,
-		AssumeRolePolicyDocument: options.Ptr(`{
-  "Version": "2012-10-17",
-  "Statement": [
-    {
-      "Effect": "Allow",
-      "Principal": {
-        "Service": "ecs-tasks.amazonaws.com"
-      },
-      "Action": "sts:AssumeRole"
-    }
-  ]
-}`),
+		return "", false, fmt.Errorf("list iam policy versions %s: %w", policyName, err)
+	}
+	oldVersions := []iamtypes.PolicyVersion{}
+	for _, version := range versions.Versions {
+		if !version.IsDefaultVersion {
+			oldVersions = append(oldVersions, version)
+		}
+	}
+	sort.SliceStable(oldVersions, func(i, j int) bool {
+		return aws.ToTime(oldVersions[i].CreateDate).Before(aws.ToTime(oldVersions[j].CreateDate))
+	})
+	if len(versions.Versions) >= 5 && len(oldVersions) > 0 {
+		_, err = p.iamClient.DeletePolicyVersion(ctx, &iam.DeletePolicyVersionInput{
+			PolicyArn: options.Ptr(policyArn),
+			VersionId: oldVersions[0].VersionId,
+		})
+		if err != nil {
+			return "", false, fmt.Errorf("delete iam policy version %s: %w", policyName, err)
+		}
+	}
+
+	p.Log.Infof("Update iam policy %s...", policyName)
+	_, err = p.iamClient.CreatePolicyVersion(ctx, &iam.CreatePolicyVersionInput{
+		PolicyArn:      options.Ptr(policyArn),
+		PolicyDocument: options.Ptr(string(rawDocument)),
+		SetAsDefault:   true,
 	})
 	if err != nil {
-		_, _ = iamClient.DeletePolicy(ctx, &iam.DeletePolicyInput{PolicyArn: policyOutput.Policy.Arn})
-		return "", fmt.Errorf("create iam role: %w", err)
+		return "", false, fmt.Errorf("update iam policy %s: %w", policyName, err)
 	}
 
-	// attach policy
-	p.Log.Infof("Attach iam policy %s to role %s...", devPodPolicyName, devPodRoleName)
-	_, err = iamClient.AttachRolePolicy(ctx, &iam.AttachRolePolicyInput{
-		PolicyArn: policyOutput.Policy.Arn,
-		RoleName:  &devPodRoleName,
+	return policyArn, false, nil
+}
+
+// ensurePolicyAttached attaches the policy to the role if it isn't attached and detaches
+// stale policies devpod created and attached before, e.g. with an older name. Policies
+// without the managed tag were attached by someone else and are kept.
+func (p *EcsProvider) ensurePolicyAttached(ctx context.Context, roleName, policyArn string) error {
+	attached := false
+	paginator := iam.NewListAttachedRolePoliciesPaginator(p.iamClient, &iam.ListAttachedRolePoliciesInput{
+		RoleName: options.Ptr(roleName),
+	})
+	for paginator.HasMorePages() {
+		output, err := paginator.NextPage(ctx)
+		if err != nil {
+			return fmt.Errorf("list attached iam policies of role %s: %w", roleName, err)
+		}
+
+		for _, policy := range output.AttachedPolicies {
+			if aws.ToString(policy.PolicyArn) == policyArn {
+				attached = true
+			} else if strings.HasPrefix(aws.ToString(policy.PolicyName), p.Config.RoleNamePrefix+"-") {
+				tags, err := p.iamClient.ListPolicyTags(ctx, &iam.ListPolicyTagsInput{
+					PolicyArn: policy.PolicyArn,
+				})
+				if err != nil {
+					return fmt.Errorf("list tags of iam policy %s: %w", aws.ToString(policy.PolicyName), err)
+				} else if getIamTag(tags.Tags, managedTagKey) != managedTagValue {
+					continue
+				}
+
+				p.Log.Infof("Detach stale iam policy %s from role %s...", aws.ToString(policy.PolicyName), roleName)
+				_, err = p.iamClient.DetachRolePolicy(ctx, &iam.DetachRolePolicyInput{
+					RoleName:  options.Ptr(roleName),
+					PolicyArn: policy.PolicyArn,
+				})
+				if err != nil {
+					return fmt.Errorf("detach iam policy from role %s: %w", roleName, err)
+				}
+			}
+		}
+	}
+	if attached {
+		return nil
+	}
+
+	p.Log.Infof("Attach iam policy %s to role %s...", policyArn, roleName)
+	_, err := p.iamClient.AttachRolePolicy(ctx, &iam.AttachRolePolicyInput{
+		PolicyArn: options.Ptr(policyArn),
+		RoleName:  options.Ptr(roleName),
 	})
 	if err != nil {
-		_, _ = iamClient.DeletePolicy(ctx, &iam.DeletePolicyInput{PolicyArn: policyOutput.Policy.Arn})
-		_, _ = iamClient.DeleteRole(ctx, &iam.DeleteRoleInput{RoleName: &devPodRoleName})
-		return "", fmt.Errorf("attach iam policy to role: %w", err)
+		return fmt.Errorf("attach iam policy to role %s: %w", roleName, err)
 	}
 
-	return *roleOutput.Role.Arn, nil
+	return nil
+}
+
+func (p *EcsProvider) createInfrastructureRole(ctx context.Context) (string, error) {
+	// the role isn't scoped to the cluster and shared with the other clusters
+	roleName := p.Config.RoleNamePrefix + "-infrastructure-role"
+	roleArn, err := p.ensureRoleExists(ctx, roleName, policyDocument{
+		Version: "2012-10-17",
+		Statement: []policyStatement{
+			{
+				Effect:    "Allow",
+				Principal: map[string]string{"Service": "ecs.amazonaws.com"},
+				Action:    []string{"sts:AssumeRole"},
+			},
+		},
+	}, p.getIamTags())
+	if err != nil {
+		return "", err
+	}
+
+	// attach the aws managed policy for ecs managed volumes
+	policyArn := "arn:" + getPartitionFromArn(roleArn) + ":iam::aws:policy/service-role/AmazonECSInfrastructureRolePolicyForVolumes"
+	err = p.ensurePolicyAttached(ctx, roleName, policyArn)
+	if err != nil {
+		return "", err
+	}
+
+	return roleArn, nil
+}
+
+// getClusterArn describes the cluster to get the partition, region and account the
+// policies are scoped with
+func (p *EcsProvider) getClusterArn(ctx context.Context) (clusterArn, error) {
+	output, err := p.client.DescribeClusters(ctx, &ecs.DescribeClustersInput{
+		Clusters: []string{p.Config.ClusterID},
+	})
+	if err != nil {
+		return clusterArn{}, fmt.Errorf("describe cluster: %w", err)
+	} else if len(output.Clusters) == 0 {
+		return clusterArn{}, fmt.Errorf("cluster %s not found", p.Config.ClusterID)
+	}
+
+	// arn:partition:ecs:region:account:cluster/name
+	arn := aws.ToString(output.Clusters[0].ClusterArn)
+	splitted := strings.Split(arn, ":")
+	if len(splitted) != 6 {
+		return clusterArn{}, fmt.Errorf("unexpected cluster arn %s", arn)
+	}
+
+	return clusterArn{
+		Partition: splitted[1],
+		Region:    splitted[3],
+		AccountID: splitted[4],
+		Name:      getIDFromArn(arn),
+	}, nil
+}
+
+// getClusterIamTags returns the tags of the iam resources that belong to the cluster, which
+// teardown only deletes for this cluster
+func (p *EcsProvider) getClusterIamTags() []iamtypes.Tag {
+	tags := append(p.getIamTags(), iamtypes.Tag{
+		Key:   options.Ptr(clusterTagKey),
+		Value: options.Ptr(p.Config.ClusterID),
+	})
+	sort.Slice(tags, func(i, j int) bool {
+		return *tags[i].Key < *tags[j].Key
+	})
+
+	return tags
+}
+
+// getIamTags returns the tags of the iam resources that are shared with other clusters
+func (p *EcsProvider) getIamTags() []iamtypes.Tag {
+	tags := []iamtypes.Tag{
+		{
+			Key:   options.Ptr(managedTagKey),
+			Value: options.Ptr(managedTagValue),
+		},
+	}
+	for key, value := range p.Config.IamTags {
+		if key == managedTagKey || key == clusterTagKey {
+			continue
+		}
+		tags = append(tags, iamtypes.Tag{
+			Key:   options.Ptr(key),
+			Value: options.Ptr(value),
+		})
+	}
+	sort.Slice(tags, func(i, j int) bool {
+		return *tags[i].Key < *tags[j].Key
+	})
+
+	return tags
+}
+
+// isSamePolicyDocument compares the url encoded document IAM returns with the expected one
+func isSamePolicyDocument(encoded string, expected policyDocument) (bool, error) {
+	decoded, err := url.QueryUnescape(encoded)
+	if err != nil {
+		return false, err
+	}
+
+	raw, err := json.Marshal(expected)
+	if err != nil {
+		return false, err
+	}
+
+	var actualObj, expectedObj any
+	err = json.Unmarshal([]byte(decoded), &actualObj)
+	if err != nil {
+		return false, err
+	}
+	err = json.Unmarshal(raw, &expectedObj)
+	if err != nil {
+		return false, err
+	}
+
+	return reflect.DeepEqual(actualObj, expectedObj), nil
+}
+
+func appendUnique(list []string, value string) []string {
+	for _, existing := range list {
+		if existing == value {
+			return list
+		}
+	}
+
+	return append(list, value)
+}
+
+func getPartitionFromArn(arn string) string {
+	splitted := strings.Split(arn, ":")
+	if len(splitted) < 2 || splitted[1] == "" {
+		return "aws"
+	}
+
+	return splitted[1]
 }
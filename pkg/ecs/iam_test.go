@@ -0,0 +1,222 @@
+package ecs
+
+import (
+	"context"
+	"errors"
+	"slices"
+	"strings"
+	"testing"
+
+	"github.com/aws/aws-sdk-go-v2/service/iam"
+	iamtypes "github.com/aws/aws-sdk-go-v2/service/iam/types"
+	"github.com/loft-sh/devpod-provider-ecs/pkg/ecs/fake"
+	"github.com/loft-sh/devpod-provider-ecs/pkg/hash"
+	"github.com/loft-sh/devpod-provider-ecs/pkg/options"
+)
+
+var (
+	taskPolicyArn      = "arn:aws:iam::" + fake.AccountID + ":policy/devpod-ecs-devpod-task-policy"
+	executionPolicyArn = "arn:aws:iam::" + fake.AccountID + ":policy/devpod-ecs-devpod-execution-policy"
+)
+
+// testExecutionRoleName returns the name of the execution role of the workspace on the devpod cluster
+func testExecutionRoleName(workspaceId string) string {
+	return "devpod-ecs-devpod-execution-" + hash.String(newWorkspace(workspaceId).Family)[:8]
+}
+
+func TestEnsureRoles(t *testing.T) {
+	env := newTestEnv(t, func(o *options.Options) {
+		o.TaskRoleARN = ""
+		o.ExecutionRoleARN = ""
+		o.IamPath = "/devpod/"
+		o.PermissionsBoundaryARN = "arn:aws:iam::" + fake.AccountID + ":policy/boundary"
+		o.IamTags = map[string]string{"team": "platform"}
+		o.CloudWatchLogs = true
+		o.LogGroup = "/devpod/ecs"
+	})
+	ctx := context.Background()
+
+	err := env.provider.ensureRoles(ctx, "workspace")
+	if err != nil {
+		t.Fatalf("ensure roles: %v", err)
+	}
+
+	if env.provider.Config.TaskRoleARN != "arn:aws:iam::"+fake.AccountID+":role/devpod/devpod-ecs-devpod-task" {
+		t.Fatalf("unexpected task role %s", env.provider.Config.TaskRoleARN)
+	} else if env.provider.Config.ExecutionRoleARN != "arn:aws:iam::"+fake.AccountID+":role/devpod/"+testExecutionRoleName("workspace") {
+		t.Fatalf("unexpected execution role %s", env.provider.Config.ExecutionRoleARN)
+	}
+
+	role, err := env.iam.GetRole(ctx, &iam.GetRoleInput{RoleName: options.Ptr("devpod-ecs-devpod-task")})
+	if err != nil {
+		t.Fatalf("get task role: %v", err)
+	} else if role.Role.PermissionsBoundary == nil || *role.Role.PermissionsBoundary.PermissionsBoundaryArn != env.provider.Config.PermissionsBoundaryARN {
+		t.Fatalf("expected permissions boundary on the task role")
+	} else if len(role.Role.Tags) != 3 || getIamTag(role.Role.Tags, clusterTagKey) != "devpod" || getIamTag(role.Role.Tags, managedTagKey) != managedTagValue || getIamTag(role.Role.Tags, "team") != "platform" {
+		t.Fatalf("expected tags on the task role, got %v", role.Role.Tags)
+	} else if !strings.Contains(*role.Role.AssumeRolePolicyDocument, `"aws:SourceAccount":"`+fake.AccountID+`"`) {
+		t.Fatalf("expected task role trust to be scoped to the account, got %s", *role.Role.AssumeRolePolicyDocument)
+	} else if !strings.Contains(*role.Role.AssumeRolePolicyDocument, `"arn:aws:ecs:`+fake.Region+`:`+fake.AccountID+`:task/devpod/*"`) || strings.Contains(*role.Role.AssumeRolePolicyDocument, fake.AccountID+`:*"`) {
+		t.Fatalf("expected task role trust to be scoped to the cluster, got %s", *role.Role.AssumeRolePolicyDocument)
+	}
+
+	executionPolicyArn := "arn:aws:iam::" + fake.AccountID + ":policy/devpod/devpod-ecs-devpod-execution-policy"
+	if !slices.Contains(env.iam.AttachedPolicies(testExecutionRoleName("workspace")), executionPolicyArn) {
+		t.Fatalf("expected execution policy to be attached, got %v", env.iam.AttachedPolicies(testExecutionRoleName("workspace")))
+	}
+	document := env.iam.PolicyDocument(executionPolicyArn)
+	if strings.Contains(document, "logs:") {
+		t.Fatalf("expected the shared execution policy to not hold the log group, got %s", document)
+	} else if strings.Contains(document, "ssmmessages") {
+		t.Fatalf("expected execution policy to not allow ecs exec, got %s", document)
+	}
+	document = env.iam.RolePolicy(testExecutionRoleName("workspace"), "devpod-workspace")
+	if !strings.Contains(document, `"arn:aws:logs:`+fake.Region+`:`+fake.AccountID+`:log-group:/devpod/ecs:*"`) {
+		t.Fatalf("expected workspace policy to be scoped to the log group, got %s", document)
+	}
+}
+
+func TestEnsureRolesWorkspacePolicy(t *testing.T) {
+	env := newTestEnv(t, func(o *options.Options) {
+		o.TaskRoleARN = ""
+		o.ExecutionRoleARN = ""
+	})
+	ctx := context.Background()
+
+	// two workspaces with different secrets get their own execution role
+	fooSecret := "arn:aws:secretsmanager:us-east-1:" + fake.AccountID + ":secret:foo-AbCdEf"
+	barSecret := "arn:aws:secretsmanager:us-east-1:" + fake.AccountID + ":secret:bar-AbCdEf"
+	for workspaceId, secret := range map[string]string{"foo": fooSecret, "bar": barSecret} {
+		env.provider.Config.ExecutionRoleARN = ""
+		env.provider.Config.Secrets = []options.Secret{{Name: "SECRET", ValueFrom: secret}}
+		err := env.provider.ensureRoles(ctx, workspaceId)
+		if err != nil {
+			t.Fatalf("ensure roles of %s: %v", workspaceId, err)
+		} else if !slices.Contains(env.iam.AttachedPolicies(testExecutionRoleName(workspaceId)), executionPolicyArn) {
+			t.Fatalf("expected the shared execution policy to be attached to the role of %s", workspaceId)
+		}
+	}
+
+	if document := env.iam.RolePolicy(testExecutionRoleName("foo"), "devpod-foo"); !strings.Contains(document, fooSecret) || strings.Contains(document, barSecret) {
+		t.Fatalf("expected foo to only read its secret, got %s", document)
+	} else if document := env.iam.RolePolicy(testExecutionRoleName("bar"), "devpod-bar"); !strings.Contains(document, barSecret) || strings.Contains(document, fooSecret) {
+		t.Fatalf("expected bar to only read its secret, got %s", document)
+	}
+
+	// a workspace without secrets or logs has no workspace policy
+	env.provider.Config.ExecutionRoleARN = ""
+	env.provider.Config.Secrets = nil
+	err := env.provider.ensureRoles(ctx, "foo")
+	if err != nil {
+		t.Fatalf("ensure roles: %v", err)
+	} else if document := env.iam.RolePolicy(testExecutionRoleName("foo"), "devpod-foo"); document != "" {
+		t.Fatalf("expected the policy of foo to be removed, got %s", document)
+	}
+
+	// deleting a workspace deletes its role, but keeps the shared policy
+	env.provider.Config.ExecutionRoleARN = ""
+	err = env.provider.deleteExecutionRole(ctx, "bar")
+	if err != nil {
+		t.Fatalf("delete execution role: %v", err)
+	} else if roles := env.iam.Roles(); slices.Contains(roles, testExecutionRoleName("bar")) || !slices.Contains(roles, testExecutionRoleName("foo")) {
+		t.Fatalf("expected only the role of bar to be deleted, got %v", roles)
+	} else if !slices.Contains(env.iam.Policies(), executionPolicyArn) {
+		t.Fatalf("expected the shared execution policy to be kept, got %v", env.iam.Policies())
+	}
+
+	// a role the user configured is kept
+	env.provider.Config.ExecutionRoleARN = "arn:aws:iam::" + fake.AccountID + ":role/custom"
+	err = env.provider.deleteExecutionRole(ctx, "foo")
+	if err != nil {
+		t.Fatalf("delete execution role: %v", err)
+	} else if !slices.Contains(env.iam.Roles(), testExecutionRoleName("foo")) {
+		t.Fatalf("expected the role of foo to be kept, got %v", env.iam.Roles())
+	}
+}
+
+func TestRunTaskRollbackDeletesExecutionRole(t *testing.T) {
+	env := newTestEnv(t, func(o *options.Options) {
+		o.ExecutionRoleARN = ""
+	})
+	ctx := context.Background()
+
+	env.ecs.FailOn("RunTask", errors.New("AccessDeniedException"))
+	err := env.provider.RunTask(ctx, "workspace", testRunOptions())
+	if err == nil {
+		t.Fatalf("expected run task to fail")
+	} else if slices.Contains(env.iam.Roles(), testExecutionRoleName("workspace")) {
+		t.Fatalf("expected the execution role to be deleted, got %v", env.iam.Roles())
+	}
+}
+
+func TestEnsureRolesRepair(t *testing.T) {
+	env := newTestEnv(t, func(o *options.Options) {
+		o.TaskRoleARN = ""
+		o.ExecutionRoleARN = ""
+	})
+	ctx := context.Background()
+
+	err := env.provider.ensureRoles(ctx, "workspace")
+	if err != nil {
+		t.Fatalf("ensure roles: %v", err)
+	}
+	expectedDocument := env.iam.PolicyDocument(taskPolicyArn)
+
+	// a detached policy with a stale document and a leftover policy are repaired
+	env.iam.DetachPolicy("devpod-ecs-devpod-task", taskPolicyArn)
+	for i := 0; i < 4; i++ {
+		env.iam.SetPolicyDocument(taskPolicyArn, `{"Version":"2012-10-17","Statement":[]}`)
+	}
+	stalePolicy, err := env.iam.CreatePolicy(ctx, &iam.CreatePolicyInput{
+		PolicyName:     options.Ptr("devpod-ecs-old-policy"),
+		PolicyDocument: options.Ptr(`{"Version":"2012-10-17","Statement":[]}`),
+		Tags:           []iamtypes.Tag{{Key: options.Ptr(managedTagKey), Value: options.Ptr(managedTagValue)}},
+	})
+	if err != nil {
+		t.Fatalf("create stale policy: %v", err)
+	}
+	// a policy the user attached isn't touched, even if it has the prefix
+	userPolicy, err := env.iam.CreatePolicy(ctx, &iam.CreatePolicyInput{
+		PolicyName:     options.Ptr("devpod-ecs-extra"),
+		PolicyDocument: options.Ptr(`{"Version":"2012-10-17","Statement":[]}`),
+	})
+	if err != nil {
+		t.Fatalf("create user policy: %v", err)
+	}
+	for _, policyArn := range []*string{stalePolicy.Policy.Arn, userPolicy.Policy.Arn} {
+		_, err = env.iam.AttachRolePolicy(ctx, &iam.AttachRolePolicyInput{
+			RoleName:  options.Ptr("devpod-ecs-devpod-task"),
+			PolicyArn: policyArn,
+		})
+		if err != nil {
+			t.Fatalf("attach policy: %v", err)
+		}
+	}
+
+	env.provider.Config.TaskRoleARN = ""
+	env.provider.Config.ExecutionRoleARN = ""
+	err = env.provider.ensureRoles(ctx, "workspace")
+	if err != nil {
+		t.Fatalf("repair roles: %v", err)
+	}
+
+	if document := env.iam.PolicyDocument(taskPolicyArn); document != expectedDocument {
+		t.Fatalf("expected task policy to be updated, got %s", document)
+	} else if attached := env.iam.AttachedPolicies("devpod-ecs-devpod-task"); len(attached) != 2 || !slices.Contains(attached, taskPolicyArn) || !slices.Contains(attached, *userPolicy.Policy.Arn) {
+		t.Fatalf("expected the task and the user policy to be attached, got %v", attached)
+	}
+}
+
+func TestEnsureRolesCleansUpPolicy(t *testing.T) {
+	env := newTestEnv(t, func(o *options.Options) {
+		o.TaskRoleARN = ""
+	})
+	env.iam.FailOn("CreateRole", errors.New("AccessDenied"))
+
+	err := env.provider.ensureRoles(context.Background(), "workspace")
+	if err == nil {
+		t.Fatalf("expected ensure roles to fail")
+	} else if policies := env.iam.Policies(); len(policies) != 0 {
+		t.Fatalf("expected no policy to be left behind, got %v", policies)
+	}
+}
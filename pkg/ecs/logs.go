@@ -0,0 +1,184 @@
+package ecs
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"strings"
+
+	"github.com/aws/aws-sdk-go-v2/aws"
+	"github.com/aws/aws-sdk-go-v2/service/cloudwatchlogs"
+	logstypes "github.com/aws/aws-sdk-go-v2/service/cloudwatchlogs/types"
+	"github.com/aws/aws-sdk-go-v2/service/ecs/types"
+	"github.com/loft-sh/devpod-provider-ecs/pkg/options"
+)
+
+// getLogConfiguration sends the container output to cloudwatch logs. The streams are named
+// devpod-<workspace>/devpod/<task>, so the logs command can find them by workspace.
+func (p *EcsProvider) getLogConfiguration(ctx context.Context, workspaceId string) (*types.LogConfiguration, error) {
+	if !p.Config.CloudWatchLogs {
+		return nil, nil
+	}
+
+	err := p.ensureLogGroup(ctx)
+	if err != nil {
+		return nil, err
+	}
+
+	return &types.LogConfiguration{
+		LogDriver: types.LogDriverAwslogs,
+		Options: map[string]string{
+			"awslogs-group":         p.Config.LogGroup,
+			"awslogs-region":        p.AwsConfig.Region,
+			"awslogs-stream-prefix": "devpod-" + workspaceId,
+		},
+	}, nil
+}
+
+// ensureLogGroup creates the log group if it doesn't exist and updates its retention
+func (p *EcsProvider) ensureLogGroup(ctx context.Context) error {
+	logGroup, err := p.getLogGroup(ctx)
+	if err != nil {
+		return err
+	} else if logGroup == nil {
+		p.Log.Infof("Create log group %s...", p.Config.LogGroup)
+		_, err = p.logsClient.CreateLogGroup(ctx, &cloudwatchlogs.CreateLogGroupInput{
+			LogGroupName: options.Ptr(p.Config.LogGroup),
+			Tags: map[string]string{
+				managedTagKey: managedTagValue,
+			},
+		})
+		if err != nil {
+			var alreadyExists *logstypes.ResourceAlreadyExistsException
+			if !errors.As(err, &alreadyExists) {
+				return fmt.Errorf("create log group: %w", err)
+			}
+		}
+	}
+
+	if p.Config.LogRetentionDays > 0 && (logGroup == nil || aws.ToInt32(logGroup.RetentionInDays) != p.Config.LogRetentionDays) {
+		_, err = p.logsClient.PutRetentionPolicy(ctx, &cloudwatchlogs.PutRetentionPolicyInput{
+			LogGroupName:    options.Ptr(p.Config.LogGroup),
+			RetentionInDays: options.Ptr(p.Config.LogRetentionDays),
+		})
+		if err != nil {
+			return fmt.Errorf("put log group retention: %w", err)
+		}
+	}
+
+	return nil
+}
+
+// getLogGroup returns the configured log group or nil if it doesn't exist. The name is
+// only a prefix filter, so all pages are searched for the exact match.
+func (p *EcsProvider) getLogGroup(ctx context.Context) (*logstypes.LogGroup, error) {
+	paginator := cloudwatchlogs.NewDescribeLogGroupsPaginator(p.logsClient, &cloudwatchlogs.DescribeLogGroupsInput{
+		LogGroupNamePrefix: options.Ptr(p.Config.LogGroup),
+	})
+	for paginator.HasMorePages() {
+		output, err := paginator.NextPage(ctx)
+		if err != nil {
+			return nil, fmt.Errorf("describe log groups: %w", err)
+		}
+
+		for i := range output.LogGroups {
+			if aws.ToString(output.LogGroups[i].LogGroupName) == p.Config.LogGroup {
+				return &output.LogGroups[i], nil
+			}
+		}
+	}
+
+	return nil, nil
+}
+
+// Logs writes the last tail lines of the newest container log stream of the workspace to
+// writer, or all lines if tail is 0. If follow is true, it keeps writing new lines until ctx is done.
+func (p *EcsProvider) Logs(ctx context.Context, workspaceId string, tail int32, follow bool, writer io.Writer) error {
+	logStream, err := p.getLatestLogStream(ctx, workspaceId)
+	if err != nil {
+		return err
+	} else if logStream == "" {
+		return fmt.Errorf("no logs for workspace %s found in log group %s", workspaceId, p.Config.LogGroup)
+	}
+
+	return p.printLogs(ctx, logStream, tail, follow, writer)
+}
+
+func (p *EcsProvider) printLogs(ctx context.Context, logStream string, tail int32, follow bool, writer io.Writer) error {
+	input := &cloudwatchlogs.GetLogEventsInput{
+		LogGroupName:  options.Ptr(p.Config.LogGroup),
+		LogStreamName: options.Ptr(logStream),
+		StartFromHead: options.Ptr(tail == 0),
+	}
+	if tail > 0 {
+		input.Limit = options.Ptr(tail)
+	}
+	for {
+		output, err := p.logsClient.GetLogEvents(ctx, input)
+		if err != nil {
+			return fmt.Errorf("get log events: %w", err)
+		}
+
+		for _, event := range output.Events {
+			_, err = fmt.Fprintln(writer, aws.ToString(event.Message))
+			if err != nil {
+				return err
+			}
+		}
+
+		// the forward token stays the same once the end of the stream is reached
+		endOfStream := aws.ToString(output.NextForwardToken) == aws.ToString(input.NextToken)
+		input.NextToken = output.NextForwardToken
+		input.StartFromHead = options.Ptr(true)
+		input.Limit = nil
+		if !endOfStream {
+			continue
+		} else if !follow {
+			return nil
+		}
+
+		if sleep(ctx, p.pollInterval) != nil {
+			return nil
+		}
+	}
+}
+
+// getLogStreamName returns the name of the log stream of the devpod container of the task
+func getLogStreamName(workspaceId string, task *types.Task) string {
+	taskArn := aws.ToString(task.TaskArn)
+	return "devpod-" + workspaceId + "/devpod/" + taskArn[strings.LastIndex(taskArn, "/")+1:]
+}
+
+func (p *EcsProvider) getLatestLogStream(ctx context.Context, workspaceId string) (string, error) {
+	logStream := ""
+	latest := int64(0)
+	paginator := cloudwatchlogs.NewDescribeLogStreamsPaginator(p.logsClient, &cloudwatchlogs.DescribeLogStreamsInput{
+		LogGroupName:        options.Ptr(p.Config.LogGroup),
+		LogStreamNamePrefix: options.Ptr("devpod-" + workspaceId + "/"),
+	})
+	for paginator.HasMorePages() {
+		output, err := paginator.NextPage(ctx)
+		if err != nil {
+			var notFound *logstypes.ResourceNotFoundException
+			if errors.As(err, &notFound) {
+				return "", nil
+			}
+
+			return "", fmt.Errorf("describe log streams: %w", err)
+		}
+
+		for _, stream := range output.LogStreams {
+			timestamp := aws.ToInt64(stream.LastEventTimestamp)
+			if timestamp == 0 {
+				timestamp = aws.ToInt64(stream.CreationTime)
+			}
+			if timestamp > latest {
+				latest = timestamp
+				logStream = aws.ToString(stream.LogStreamName)
+			}
+		}
+	}
+
+	return logStream, nil
+}
@@ -0,0 +1,239 @@
+package ecs
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"slices"
+	"strings"
+
+	"github.com/aws/aws-sdk-go-v2/aws"
+	"github.com/aws/aws-sdk-go-v2/service/ecs"
+	"github.com/aws/aws-sdk-go-v2/service/ecs/types"
+)
+
+// listKeys are the fields that identify the entries of lists without a name, e.g. a mount
+// point is identified by its container path
+var listKeys = map[string]string{
+	".containerdefinitions.dependson":               "containerName",
+	".containerdefinitions.extrahosts":              "hostname",
+	".containerdefinitions.linuxparameters.devices": "hostPath",
+	".containerdefinitions.linuxparameters.tmpfs":   "containerPath",
+	".containerdefinitions.mountpoints":             "containerPath",
+	".containerdefinitions.portmappings":            "containerPort",
+	".containerdefinitions.resourcerequirements":    "type",
+	".containerdefinitions.systemcontrols":          "namespace",
+	".containerdefinitions.volumesfrom":             "sourceContainer",
+	".inferenceaccelerators":                        "deviceName",
+}
+
+// applyOverlay merges TASK_DEFINITION_OVERLAY onto the generated task definition. Objects
+// are merged recursively and lists of objects are merged by the name of their entries, like
+// containerDefinitions or environment, or by the field in listKeys, like mountPoints by
+// containerPath. All other values are replaced. Container definitions without a name are
+// merged onto the devpod container.
+func (p *EcsProvider) applyOverlay(workspace workspace, taskDefinition *ecs.RegisterTaskDefinitionInput) error {
+	if p.Config.TaskDefinitionOverlay == "" {
+		return nil
+	}
+
+	overlay := map[string]any{}
+	err := unmarshalJSON([]byte(p.Config.TaskDefinitionOverlay), &overlay)
+	if err != nil {
+		return fmt.Errorf("parse task definition overlay: %w", err)
+	}
+
+	// the sdk types marshal with their go field names, which the api names match case
+	// insensitively
+	raw, err := json.Marshal(taskDefinition)
+	if err != nil {
+		return fmt.Errorf("marshal task definition: %w", err)
+	}
+	generated := map[string]any{}
+	err = unmarshalJSON(raw, &generated)
+	if err != nil {
+		return fmt.Errorf("unmarshal task definition: %w", err)
+	}
+
+	raw, err = json.Marshal(mergeObjects(generated, overlay, ""))
+	if err != nil {
+		return fmt.Errorf("marshal task definition: %w", err)
+	}
+	merged := ecs.RegisterTaskDefinitionInput{}
+	decoder := json.NewDecoder(bytes.NewReader(raw))
+	decoder.DisallowUnknownFields()
+	err = decoder.Decode(&merged)
+	if err != nil {
+		return fmt.Errorf("apply task definition overlay: %w", err)
+	}
+
+	err = validateTaskDefinition(workspace, &merged, p.hasVolumes())
+	if err != nil {
+		return fmt.Errorf("apply task definition overlay: %w", err)
+	}
+
+	*taskDefinition = merged
+	return nil
+}
+
+// validateTaskDefinition makes sure the overlay didn't change what the provider relies on.
+// With hasVolumes the devpod container must still mount the workspace volume.
+func validateTaskDefinition(workspace workspace, taskDefinition *ecs.RegisterTaskDefinitionInput, hasVolumes bool) error {
+	if aws.ToString(taskDefinition.Family) != workspace.Family {
+		return fmt.Errorf("family must be %s, got %s", workspace.Family, aws.ToString(taskDefinition.Family))
+	} else if taskDefinition.NetworkMode != types.NetworkModeAwsvpc {
+		return fmt.Errorf("network mode must be %s, got %s", types.NetworkModeAwsvpc, taskDefinition.NetworkMode)
+	} else if !workspace.owns(taskDefinition.Tags) {
+		return fmt.Errorf("tag %s must be %s", workspaceTagKey, workspace.ID)
+	}
+
+	var devpodContainer *types.ContainerDefinition
+	names := map[string]bool{}
+	for i, containerDefinition := range taskDefinition.ContainerDefinitions {
+		name := aws.ToString(containerDefinition.Name)
+		if name == "" {
+			return fmt.Errorf("container definition %d has no name", i)
+		} else if names[name] {
+			return fmt.Errorf("container definition %s is defined twice", name)
+		} else if aws.ToString(containerDefinition.Image) == "" {
+			return fmt.Errorf("container definition %s has no image", name)
+		}
+
+		names[name] = true
+		if name == "devpod" {
+			devpodContainer = &taskDefinition.ContainerDefinitions[i]
+		}
+	}
+	if devpodContainer == nil {
+		return fmt.Errorf("container definition devpod is missing")
+	} else if !aws.ToBool(devpodContainer.Essential) {
+		return fmt.Errorf("container definition devpod must be essential")
+	} else if !hasVolumes {
+		return nil
+	}
+
+	// the workspace is lost on the next start if it isn't on the volume
+	if !slices.ContainsFunc(taskDefinition.Volumes, func(volume types.Volume) bool {
+		return aws.ToString(volume.Name) == workspace.Family
+	}) {
+		return fmt.Errorf("volume %s is missing", workspace.Family)
+	} else if !slices.ContainsFunc(devpodContainer.MountPoints, func(mountPoint types.MountPoint) bool {
+		return aws.ToString(mountPoint.SourceVolume) == workspace.Family
+	}) {
+		return fmt.Errorf("container definition devpod must mount volume %s", workspace.Family)
+	}
+
+	return nil
+}
+
+// mergeObjects merges overlay onto base. Keys are matched case insensitively and a null in
+// the overlay resets the field.
+func mergeObjects(base, overlay map[string]any, path string) map[string]any {
+	for overlayKey, overlayValue := range overlay {
+		key := overlayKey
+		for baseKey := range base {
+			if strings.EqualFold(baseKey, overlayKey) {
+				key = baseKey
+				break
+			}
+		}
+
+		base[key] = mergeValues(base[key], overlayValue, path+"."+strings.ToLower(key))
+	}
+
+	return base
+}
+
+func mergeValues(base, overlay any, path string) any {
+	switch overlay := overlay.(type) {
+	case map[string]any:
+		if base, ok := base.(map[string]any); ok {
+			return mergeObjects(base, overlay, path)
+		}
+	case []any:
+		key := "name"
+		if listKey, ok := listKeys[path]; ok {
+			key = listKey
+		}
+		if base, ok := base.([]any); ok && isKeyedList(base, key) && isObjectList(overlay) {
+			return mergeKeyedLists(base, overlay, path, key)
+		}
+	}
+
+	return overlay
+}
+
+// mergeKeyedLists merges entries with the same key and appends the others
+func mergeKeyedLists(base, overlay []any, path, key string) []any {
+	for _, overlayEntry := range overlay {
+		overlayEntry := overlayEntry.(map[string]any)
+		value := getKey(overlayEntry, key)
+		if value == "" && path == ".containerdefinitions" {
+			value = "devpod"
+		}
+
+		merged := false
+		for i, baseEntry := range base {
+			baseEntry := baseEntry.(map[string]any)
+			if value != "" && getKey(baseEntry, key) == value {
+				base[i] = mergeObjects(baseEntry, overlayEntry, path)
+				merged = true
+				break
+			}
+		}
+		if !merged {
+			base = append(base, overlayEntry)
+		}
+	}
+
+	return base
+}
+
+// isKeyedList returns true if all entries are objects with the key
+func isKeyedList(list []any, key string) bool {
+	for _, entry := range list {
+		object, ok := entry.(map[string]any)
+		if !ok || getKey(object, key) == "" {
+			return false
+		}
+	}
+
+	return true
+}
+
+// isObjectList returns true if all entries are objects
+func isObjectList(list []any) bool {
+	for _, entry := range list {
+		if _, ok := entry.(map[string]any); !ok {
+			return false
+		}
+	}
+
+	return true
+}
+
+// getKey returns the value of the key as a string, so numbers like a container port can be
+// compared too
+func getKey(object map[string]any, key string) string {
+	for objectKey, value := range object {
+		if !strings.EqualFold(objectKey, key) {
+			continue
+		}
+
+		switch value := value.(type) {
+		case string:
+			return value
+		case json.Number:
+			return value.String()
+		}
+	}
+
+	return ""
+}
+
+// unmarshalJSON keeps numbers as they are, so large integers don't lose precision
+func unmarshalJSON(raw []byte, v any) error {
+	decoder := json.NewDecoder(bytes.NewReader(raw))
+	decoder.UseNumber()
+	return decoder.Decode(v)
+}
@@ -0,0 +1,168 @@
+package ecs
+
+import (
+	"context"
+	"encoding/json"
+	"strings"
+	"testing"
+
+	"github.com/aws/aws-sdk-go-v2/service/ecs/types"
+	"github.com/loft-sh/devpod-provider-ecs/pkg/options"
+)
+
+func TestRunTaskOverlay(t *testing.T) {
+	env := newTestEnv(t, func(o *options.Options) {
+		o.TaskDefinitionOverlay = `{
+			"cpu": "4 vcpu",
+			"containerDefinitions": [
+				{
+					"linuxParameters": {"sharedMemorySize": 1024, "tmpfs": [{"containerPath": "/tmp", "size": 512}]},
+					"ulimits": [{"name": "nofile", "softLimit": 65536, "hardLimit": 65536}],
+					"environment": [{"name": "FOO", "value": "overridden"}, {"name": "BAR", "value": "baz"}]
+				},
+				{"name": "sidecar", "image": "busybox", "essential": false}
+			]
+		}`
+	})
+	ctx := context.Background()
+
+	err := env.provider.RunTask(ctx, "workspace", testRunOptions())
+	if err != nil {
+		t.Fatalf("run task: %v", err)
+	}
+
+	taskDefinition := activeTaskDefinitions(env.ecs)[0]
+	if *taskDefinition.Cpu != "4 vcpu" {
+		t.Fatalf("expected overlay cpu, got %s", *taskDefinition.Cpu)
+	} else if len(taskDefinition.ContainerDefinitions) != 2 || *taskDefinition.ContainerDefinitions[1].Name != "sidecar" {
+		t.Fatalf("expected the sidecar container to be added, got %d containers", len(taskDefinition.ContainerDefinitions))
+	}
+
+	container := taskDefinition.ContainerDefinitions[0]
+	if *container.Name != "devpod" || *container.Image != testRunOptions().Image {
+		t.Fatalf("expected the devpod container to be kept, got %s", *container.Name)
+	} else if container.LinuxParameters.SharedMemorySize == nil || *container.LinuxParameters.SharedMemorySize != 1024 {
+		t.Fatalf("expected shared memory size 1024, got %v", container.LinuxParameters.SharedMemorySize)
+	} else if container.LinuxParameters.InitProcessEnabled == nil || !*container.LinuxParameters.InitProcessEnabled {
+		t.Fatalf("expected generated linux parameters to be kept")
+	} else if len(container.LinuxParameters.Tmpfs) != 1 {
+		t.Fatalf("expected tmpfs mount, got %v", container.LinuxParameters.Tmpfs)
+	} else if len(container.Ulimits) != 1 || container.Ulimits[0].Name != types.UlimitNameNofile {
+		t.Fatalf("expected nofile ulimit, got %v", container.Ulimits)
+	} else if len(container.EntryPoint) == 0 {
+		t.Fatalf("expected generated entrypoint to be kept")
+	}
+
+	environment := map[string]string{}
+	for _, keyValue := range container.Environment {
+		environment[*keyValue.Name] = *keyValue.Value
+	}
+	if len(environment) != 2 || environment["FOO"] != "overridden" || environment["BAR"] != "baz" {
+		t.Fatalf("expected environment to be merged by name, got %v", environment)
+	}
+}
+
+func TestRunTaskOverlayValidation(t *testing.T) {
+	for overlay, expected := range map[string]string{
+		`{"family": "other"}`:                                          "family must be devpod-workspace",
+		`{"networkMode": "bridge"}`:                                    "network mode must be awsvpc",
+		`{"containerDefinitions": [{"essential": false}]}`:             "devpod must be essential",
+		`{"containerDefinitions": [{"name": "sidecar"}]}`:              "sidecar has no image",
+		`{"containerDefinitions": [{"linuxParameters": {"shm": 1}}]}`:  "unknown field",
+		`{"containerDefinitions": [{"name": "devpod", "image": 123}]}`: "cannot unmarshal number",
+	} {
+		env := newTestEnv(t, func(o *options.Options) {
+			o.TaskRoleARN = ""
+			o.ExecutionRoleARN = ""
+			o.EfsFileSystemID = "fs-1"
+			o.CloudWatchLogs = true
+			o.LogGroup = "/devpod/ecs"
+			o.TaskDefinitionOverlay = overlay
+		})
+
+		err := env.provider.RunTask(context.Background(), "workspace", testRunOptions())
+		if err == nil || !strings.Contains(err.Error(), expected) {
+			t.Errorf("expected overlay %s to fail with %q, got %v", overlay, expected, err)
+		} else if len(env.ecs.TaskDefinitions()) != 0 {
+			t.Errorf("expected no task definition to be registered for overlay %s", overlay)
+		} else if len(env.iam.Roles()) != 0 || len(env.efs.AccessPoints()) != 0 || env.logs.LogGroup("/devpod/ecs") != nil {
+			t.Errorf("expected the overlay %s to fail before any resource is created", overlay)
+		}
+	}
+}
+
+func TestRunTaskOverlayMountPoints(t *testing.T) {
+	env := newTestEnv(t, func(o *options.Options) {
+		o.LaunchType = string(types.LaunchTypeEc2)
+		o.TaskDefinitionOverlay = `{
+			"volumes": [{"name": "cache", "host": {"sourcePath": "/var/cache/devpod"}}],
+			"containerDefinitions": [
+				{
+					"mountPoints": [
+						{"containerPath": "/workspaces", "readOnly": false},
+						{"sourceVolume": "cache", "containerPath": "/cache"}
+					],
+					"portMappings": [{"containerPort": 8080}]
+				}
+			]
+		}`
+	})
+	env.ecs.ContainerInstances = []types.ContainerInstance{testContainerInstance("instance-1", "i-1", "x86_64")}
+	ctx := context.Background()
+
+	err := env.provider.RunTask(ctx, "workspace", testRunOptions())
+	if err != nil {
+		t.Fatalf("run task: %v", err)
+	}
+
+	mountPoints := activeTaskDefinitions(env.ecs)[0].ContainerDefinitions[0].MountPoints
+	if len(mountPoints) != 2 {
+		t.Fatalf("expected the mount points to be merged by container path, got %v", mountPoints)
+	} else if *mountPoints[0].SourceVolume != "devpod-workspace" || *mountPoints[0].ContainerPath != "/workspaces" || mountPoints[0].ReadOnly == nil || *mountPoints[0].ReadOnly {
+		t.Fatalf("expected the workspace mount point to be kept and merged, got %v", mountPoints[0])
+	} else if *mountPoints[1].SourceVolume != "cache" {
+		t.Fatalf("expected the cache mount point to be appended, got %v", mountPoints[1])
+	}
+}
+
+func TestRunTaskOverlayWorkspaceVolume(t *testing.T) {
+	for overlay, expected := range map[string]string{
+		`{"containerDefinitions": [{"mountPoints": null}]}`: "devpod must mount volume devpod-workspace",
+		`{"volumes": null}`: "volume devpod-workspace is missing",
+	} {
+		env := newTestEnv(t, func(o *options.Options) {
+			o.LaunchType = string(types.LaunchTypeEc2)
+			o.TaskDefinitionOverlay = overlay
+		})
+		env.ecs.ContainerInstances = []types.ContainerInstance{testContainerInstance("instance-1", "i-1", "x86_64")}
+
+		err := env.provider.RunTask(context.Background(), "workspace", testRunOptions())
+		if err == nil || !strings.Contains(err.Error(), expected) {
+			t.Errorf("expected overlay %s to fail with %q, got %v", overlay, expected, err)
+		}
+	}
+}
+
+func TestMergeValuesKeyedLists(t *testing.T) {
+	base := []any{
+		map[string]any{"ContainerPort": json.Number("80"), "Protocol": "tcp"},
+		map[string]any{"ContainerPort": json.Number("443"), "Protocol": "tcp"},
+	}
+	overlay := []any{
+		map[string]any{"containerPort": json.Number("443"), "protocol": "udp"},
+		map[string]any{"containerPort": json.Number("8080")},
+	}
+
+	merged := mergeValues(base, overlay, ".containerdefinitions.portmappings").([]any)
+	if len(merged) != 3 {
+		t.Fatalf("expected 3 port mappings, got %v", merged)
+	} else if merged[1].(map[string]any)["Protocol"] != "udp" {
+		t.Fatalf("expected port 443 to be merged, got %v", merged[1])
+	}
+
+	// lists without a known key are still replaced
+	merged = mergeValues([]any{map[string]any{"Type": "memberOf"}}, []any{map[string]any{"type": "distinctInstance"}}, ".placementconstraints").([]any)
+	if len(merged) != 1 || merged[0].(map[string]any)["type"] != "distinctInstance" {
+		t.Fatalf("expected placement constraints to be replaced, got %v", merged)
+	}
+}
@@ -0,0 +1,187 @@
+package ecs
+
+import (
+	"context"
+	"fmt"
+
+	"github.com/aws/aws-sdk-go-v2/service/ecs"
+	"github.com/aws/aws-sdk-go-v2/service/ecs/types"
+	"github.com/loft-sh/devpod-provider-ecs/pkg/options"
+)
+
+const containerInstanceTagKey = "devpod-container-instance"
+
+// getPlacementConstraints places the task on a container instance with the architecture of
+// the task definition and pins it to the container instance that holds the docker volumes of
+// the workspace. The instance is recorded as a tag on the task definition and as a custom
+// attribute on the container instance, which the constraint matches on.
+func (p *EcsProvider) getPlacementConstraints(ctx context.Context, workspaceId, taskDefinitionArn string) ([]types.PlacementConstraint, error) {
+	if p.Config.LaunchType == string(types.LaunchTypeFargate) {
+		return nil, nil
+	}
+
+	taskDefinition, err := p.client.DescribeTaskDefinition(ctx, &ecs.DescribeTaskDefinitionInput{
+		TaskDefinition: options.Ptr(taskDefinitionArn),
+		Include:        []types.TaskDefinitionField{types.TaskDefinitionFieldTags},
+	})
+	if err != nil {
+		return nil, fmt.Errorf("describe task definition: %w", err)
+	}
+
+	placementConstraints := []types.PlacementConstraint{}
+	if runtimePlatform := taskDefinition.TaskDefinition.RuntimePlatform; runtimePlatform != nil && runtimePlatform.CpuArchitecture != "" {
+		cpuArchitecture := "x86_64"
+		if runtimePlatform.CpuArchitecture == types.CPUArchitectureArm64 {
+			cpuArchitecture = "arm64"
+		}
+
+		placementConstraints = append(placementConstraints, types.PlacementConstraint{
+			Type:       types.PlacementConstraintTypeMemberOf,
+			Expression: options.Ptr("attribute:" + cpuArchitectureAttribute + " == " + cpuArchitecture),
+		})
+	}
+
+	containerInstanceArn := getTag(taskDefinition.Tags, containerInstanceTagKey)
+	if !p.hasDockerVolumes() || containerInstanceArn == "" {
+		// first run, the task gets pinned once it is running
+		return placementConstraints, nil
+	}
+
+	containerInstances, err := p.describeContainerInstances(ctx, []string{containerInstanceArn})
+	if err != nil {
+		return nil, err
+	} else if len(containerInstances) == 0 || containerInstances[0].Status == nil || *containerInstances[0].Status != "ACTIVE" {
+		if !p.Config.AllowInstanceMigration {
+			return nil, fmt.Errorf("container instance %s that holds the workspace volumes is not available anymore. Set ALLOW_INSTANCE_MIGRATION to true to start the workspace on another instance with empty volumes", containerInstanceArn)
+		}
+
+		p.Log.Warnf("Container instance %s that holds the workspace volumes is not available anymore, starting the workspace on another instance with empty volumes", containerInstanceArn)
+		return placementConstraints, nil
+	}
+
+	// make sure the attribute is set, e.g. if the ecs agent was re-registered
+	err = p.putWorkspaceAttribute(ctx, workspaceId, containerInstanceArn)
+	if err != nil {
+		return nil, err
+	}
+
+	return append(placementConstraints, types.PlacementConstraint{
+		Type:       types.PlacementConstraintTypeMemberOf,
+		Expression: options.Ptr("attribute:" + workspaceAttributeName(workspaceId) + " exists"),
+	}), nil
+}
+
+// pinContainerInstance records the container instance the task is running on, so that
+// later runs are placed on the same instance
+func (p *EcsProvider) pinContainerInstance(ctx context.Context, workspaceId, taskDefinitionArn string, task *types.Task) error {
+	if !p.hasDockerVolumes() || task.ContainerInstanceArn == nil {
+		return nil
+	}
+
+	containerInstanceArn, err := p.getPinnedContainerInstance(ctx, taskDefinitionArn)
+	if err != nil {
+		return err
+	} else if containerInstanceArn == *task.ContainerInstanceArn {
+		return nil
+	}
+
+	// remove the attribute from the previous instance
+	err = p.deleteWorkspaceAttributes(ctx, workspaceId)
+	if err != nil {
+		return err
+	}
+
+	err = p.putWorkspaceAttribute(ctx, workspaceId, *task.ContainerInstanceArn)
+	if err != nil {
+		return err
+	}
+
+	_, err = p.client.TagResource(ctx, &ecs.TagResourceInput{
+		ResourceArn: options.Ptr(taskDefinitionArn),
+		Tags: []types.Tag{
+			{
+				Key:   options.Ptr(containerInstanceTagKey),
+				Value: task.ContainerInstanceArn,
+			},
+		},
+	})
+	if err != nil {
+		return fmt.Errorf("tag task definition: %w", err)
+	}
+
+	return nil
+}
+
+func (p *EcsProvider) getPinnedContainerInstance(ctx context.Context, taskDefinitionArn string) (string, error) {
+	taskDefinition, err := p.client.DescribeTaskDefinition(ctx, &ecs.DescribeTaskDefinitionInput{
+		TaskDefinition: options.Ptr(taskDefinitionArn),
+		Include:        []types.TaskDefinitionField{types.TaskDefinitionFieldTags},
+	})
+	if err != nil {
+		return "", fmt.Errorf("describe task definition: %w", err)
+	}
+
+	return getTag(taskDefinition.Tags, containerInstanceTagKey), nil
+}
+
+func (p *EcsProvider) putWorkspaceAttribute(ctx context.Context, workspaceId, containerInstanceArn string) error {
+	_, err := p.client.PutAttributes(ctx, &ecs.PutAttributesInput{
+		Cluster: options.Ptr(p.Config.ClusterID),
+		Attributes: []types.Attribute{
+			{
+				Name:       options.Ptr(workspaceAttributeName(workspaceId)),
+				TargetId:   options.Ptr(containerInstanceArn),
+				TargetType: types.TargetTypeContainerInstance,
+			},
+		},
+	})
+	if err != nil {
+		return fmt.Errorf("put container instance attribute: %w", err)
+	}
+
+	return nil
+}
+
+func (p *EcsProvider) deleteWorkspaceAttributes(ctx context.Context, workspaceId string) error {
+	attributes := []types.Attribute{}
+	paginator := ecs.NewListAttributesPaginator(p.client, &ecs.ListAttributesInput{
+		Cluster:       options.Ptr(p.Config.ClusterID),
+		TargetType:    types.TargetTypeContainerInstance,
+		AttributeName: options.Ptr(workspaceAttributeName(workspaceId)),
+	})
+	for paginator.HasMorePages() {
+		output, err := paginator.NextPage(ctx)
+		if err != nil {
+			return fmt.Errorf("list container instance attributes: %w", err)
+		}
+
+		attributes = append(attributes, output.Attributes...)
+	}
+	if len(attributes) == 0 {
+		return nil
+	}
+
+	_, err := p.client.DeleteAttributes(ctx, &ecs.DeleteAttributesInput{
+		Cluster:    options.Ptr(p.Config.ClusterID),
+		Attributes: attributes,
+	})
+	if err != nil {
+		return fmt.Errorf("delete container instance attributes: %w", err)
+	}
+
+	return nil
+}
+
+func workspaceAttributeName(workspaceId string) string {
+	return "devpod-workspace-" + workspaceId
+}
+
+func getTag(tags []types.Tag, key string) string {
+	for _, tag := range tags {
+		if tag.Key != nil && *tag.Key == key && tag.Value != nil {
+			return *tag.Value
+		}
+	}
+
+	return ""
+}
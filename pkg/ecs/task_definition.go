@@ -2,8 +2,10 @@ package ecs
 
 import (
 	"context"
-	"errors"
+	"encoding/json"
 	"fmt"
+	"path"
+	"sort"
 
 	"github.com/aws/aws-sdk-go-v2/service/ecs"
 	"github.com/aws/aws-sdk-go-v2/service/ecs/types"
@@ -14,64 +16,103 @@ import (
 	"github.com/loft-sh/devpod/pkg/driver"
 )
 
-func (p *EcsProvider) registerTaskDefinition(ctx context.Context, workspaceId string, runOptions *driver.RunOptions) error {
-	taskDefinitionID := "devpod-" + workspaceId
+// hashTagKey is the tag that holds the hash of the rendered task definition
+const hashTagKey = "devpod-hash"
 
-	// delete existing task definition
-	err := p.deleteTaskDefinition(ctx, workspaceId)
-	if err != nil {
-		return fmt.Errorf("delete existing task definition: %w", err)
-	}
+func (p *EcsProvider) registerTaskDefinition(ctx context.Context, workspaceId string, runOptions *driver.RunOptions) error {
+	workspace := newWorkspace(workspaceId)
 
-	// get container definition
+	// get container definition first, so invalid run options fail before touching anything
 	containerDefinition, err := p.getContainerDefinition(workspaceId, runOptions)
 	if err != nil {
 		return fmt.Errorf("get container definition: %w", err)
 	}
 
-	// make sure we have a value for the role arn
-	if p.Config.TaskRoleARN == "" || p.Config.ExecutionRoleARN == "" {
-		roleArn, err := p.createIamRole(ctx)
-		if err != nil {
-			return err
-		}
-
-		if p.Config.TaskRoleARN == "" {
-			p.Config.TaskRoleARN = roleArn
-		}
-		if p.Config.ExecutionRoleARN == "" {
-			p.Config.ExecutionRoleARN = roleArn
-		}
-	}
-
 	// create task definition
 	taskDefinition := &ecs.RegisterTaskDefinitionInput{
 		ContainerDefinitions: []types.ContainerDefinition{
 			containerDefinition,
 		},
-		TaskRoleArn:      options.Ptr(p.Config.TaskRoleARN),
-		ExecutionRoleArn: options.Ptr(p.Config.ExecutionRoleARN),
-		Family:           options.Ptr(taskDefinitionID),
-		Cpu:              options.Ptr(p.Config.TaskCpu),
-		Memory:           options.Ptr(p.Config.TaskMemory),
-		NetworkMode:      types.NetworkModeAwsvpc,
+		Family:      options.Ptr(workspace.Family),
+		Cpu:         options.Ptr(p.Config.TaskCpu),
+		Memory:      options.Ptr(p.Config.TaskMemory),
+		NetworkMode: types.NetworkModeAwsvpc,
 		RequiresCompatibilities: []types.Compatibility{
 			types.Compatibility(p.Config.LaunchType),
 		},
-		Tags: getTags(workspaceId),
+		Tags: append(workspace.Tags(), p.getClusterTag()),
+	}
+
+	// apply the overlay to the volume names only, so an invalid overlay fails before the
+	// roles, log group and access points are created
+	preview := *taskDefinition
+	for _, name := range p.getVolumeNames(workspaceId, runOptions) {
+		preview.Volumes = append(preview.Volumes, types.Volume{Name: options.Ptr(name)})
+	}
+	err = p.applyOverlay(workspace, &preview)
+	if err != nil {
+		return err
+	}
+
+	// make sure we have a value for the role arns
+	err = p.ensureRoles(ctx, workspaceId)
+	if err != nil {
+		return err
+	}
+	taskDefinition.TaskRoleArn = options.Ptr(p.Config.TaskRoleARN)
+	taskDefinition.ExecutionRoleArn = options.Ptr(p.Config.ExecutionRoleARN)
+
+	architecture, err := p.getClusterArchitecture(ctx)
+	if err != nil {
+		return err
+	}
+	taskDefinition.RuntimePlatform = getRuntimePlatform(architecture)
+
+	taskDefinition.ContainerDefinitions[0].LogConfiguration, err = p.getLogConfiguration(ctx, workspaceId)
+	if err != nil {
+		return err
 	}
 
 	// add volumes
-	if p.Config.LaunchType != string(types.LaunchTypeFargate) {
+	if p.Config.EbsVolumeSize > 0 {
+		// the ebs volume is configured when running the task
+		taskDefinition.Volumes = append(taskDefinition.Volumes, types.Volume{
+			Name:               options.Ptr("devpod-" + workspaceId),
+			ConfiguredAtLaunch: options.Ptr(true),
+		})
+	}
+	if p.Config.EfsFileSystemID != "" {
+		if p.Config.EbsVolumeSize == 0 {
+			volume, err := p.getEfsVolume(ctx, workspaceId, "devpod-"+workspaceId)
+			if err != nil {
+				return err
+			}
+			taskDefinition.Volumes = append(taskDefinition.Volumes, volume)
+		}
+		for _, mount := range runOptions.Mounts {
+			if mount.Source == "" || mount.Target == "" {
+				continue
+			}
+
+			volume, err := p.getEfsVolume(ctx, workspaceId, volumeName(workspaceId, mount.Source))
+			if err != nil {
+				return err
+			}
+			taskDefinition.Volumes = append(taskDefinition.Volumes, volume)
+		}
+	} else if p.hasDockerVolumes() {
 		dockerVolumeConfiguration := &types.DockerVolumeConfiguration{
 			Autoprovision: options.Ptr(true),
 			Driver:        options.Ptr("local"),
+			Labels:        map[string]string{workspaceTagKey: workspaceId},
 			Scope:         "shared",
 		}
-		taskDefinition.Volumes = append(taskDefinition.Volumes, types.Volume{
-			Name:                      options.Ptr("devpod-" + workspaceId),
-			DockerVolumeConfiguration: dockerVolumeConfiguration,
-		})
+		if p.Config.EbsVolumeSize == 0 {
+			taskDefinition.Volumes = append(taskDefinition.Volumes, types.Volume{
+				Name:                      options.Ptr("devpod-" + workspaceId),
+				DockerVolumeConfiguration: dockerVolumeConfiguration,
+			})
+		}
 		for _, mount := range runOptions.Mounts {
 			if mount.Source == "" || mount.Target == "" {
 				continue
@@ -84,30 +125,79 @@ func (p *EcsProvider) registerTaskDefinition(ctx context.Context, workspaceId st
 		}
 	}
 
+	err = p.applyOverlay(workspace, taskDefinition)
+	if err != nil {
+		return err
+	}
+
+	// reuse the active revision if nothing changed. Revisions registered before the cluster
+	// tag existed are replaced, so teardown finds them.
+	taskDefinitionHash, err := getTaskDefinitionHash(taskDefinition)
+	if err != nil {
+		return err
+	}
+	latest, err := p.getLatestTaskDefinition(ctx, workspace)
+	if err != nil {
+		return err
+	} else if latest != "" {
+		output, err := p.client.DescribeTaskDefinition(ctx, &ecs.DescribeTaskDefinitionInput{
+			TaskDefinition: options.Ptr(latest),
+			Include:        []types.TaskDefinitionField{types.TaskDefinitionFieldTags},
+		})
+		if err != nil {
+			return fmt.Errorf("describe task definition: %w", err)
+		} else if getTag(output.Tags, hashTagKey) == taskDefinitionHash && getTag(output.Tags, clusterTagKey) == p.Config.ClusterID {
+			p.Log.Infof("Task definition %s is up to date", latest)
+			return p.deleteOldTaskDefinitions(ctx, workspace, latest)
+		}
+
+		// keep the workspace on the container instance that holds its docker volumes
+		if containerInstanceArn := getTag(output.Tags, containerInstanceTagKey); containerInstanceArn != "" {
+			taskDefinition.Tags = append(taskDefinition.Tags, types.Tag{
+				Key:   options.Ptr(containerInstanceTagKey),
+				Value: options.Ptr(containerInstanceArn),
+			})
+		}
+	}
+	taskDefinition.Tags = append(taskDefinition.Tags, types.Tag{
+		Key:   options.Ptr(hashTagKey),
+		Value: options.Ptr(taskDefinitionHash),
+	})
+
 	// register task definition
-	_, err = p.client.RegisterTaskDefinition(ctx, taskDefinition)
+	output, err := p.client.RegisterTaskDefinition(ctx, taskDefinition)
 	if err != nil {
 		return err
 	}
 
-	return nil
+	// only remove the old revisions once the new one is active, so a concurrent start
+	// always finds a task definition
+	return p.deleteOldTaskDefinitions(ctx, workspace, *output.TaskDefinition.TaskDefinitionArn)
 }
 
-func (p *EcsProvider) getTaskDefinitionArn(ctx context.Context, workspaceId string) (string, error) {
-	taskDefinitionID := "devpod-" + workspaceId
+// getTaskDefinitionHash hashes the rendered task definition without its tags, which hold
+// the hash itself and the pinned container instance
+func getTaskDefinitionHash(taskDefinition *ecs.RegisterTaskDefinitionInput) (string, error) {
+	withoutTags := *taskDefinition
+	withoutTags.Tags = nil
+	raw, err := json.Marshal(withoutTags)
+	if err != nil {
+		return "", fmt.Errorf("marshal task definition: %w", err)
+	}
 
-	// list existing task definitions
-	output, err := p.client.ListTaskDefinitions(ctx, &ecs.ListTaskDefinitionsInput{
-		FamilyPrefix: options.Ptr(taskDefinitionID),
-		MaxResults:   options.Ptr(int32(10)),
-	})
+	return hash.String(string(raw)), nil
+}
+
+// getTaskDefinitionArn returns the newest active revision of the workspace task definition
+func (p *EcsProvider) getTaskDefinitionArn(ctx context.Context, workspaceId string) (string, error) {
+	arn, err := p.getLatestTaskDefinition(ctx, newWorkspace(workspaceId))
 	if err != nil {
 		return "", err
-	} else if len(output.TaskDefinitionArns) != 1 {
-		return "", fmt.Errorf("unexpected amount of task definitions: %d, expected 1", len(output.TaskDefinitionArns))
+	} else if arn == "" {
+		return "", fmt.Errorf("no active task definition found for workspace %s", workspaceId)
 	}
 
-	return output.TaskDefinitionArns[0], nil
+	return arn, nil
 }
 
 func (p *EcsProvider) getContainerDefinition(workspaceId string, runOptions *driver.RunOptions) (types.ContainerDefinition, error) {
@@ -119,19 +209,42 @@ func (p *EcsProvider) getContainerDefinition(workspaceId string, runOptions *dri
 			InitProcessEnabled: options.Ptr(true),
 		},
 	}
+	if p.Config.RegistryCredentialsSecretARN != "" {
+		retDefinition.RepositoryCredentials = &types.RepositoryCredentials{
+			CredentialsParameter: options.Ptr(p.Config.RegistryCredentialsSecretARN),
+		}
+	}
 	if len(runOptions.Labels) > 0 {
 		retDefinition.DockerLabels = config.ListToObject(runOptions.Labels)
 	}
+	secretNames := map[string]bool{}
+	for _, secret := range p.Config.Secrets {
+		retDefinition.Secrets = append(retDefinition.Secrets, types.Secret{
+			Name:      options.Ptr(secret.Name),
+			ValueFrom: options.Ptr(secret.ValueFrom),
+		})
+		secretNames[secret.Name] = true
+	}
 	if len(runOptions.Env) > 0 {
 		for k, v := range runOptions.Env {
+			// secrets take precedence, ecs doesn't allow the same name twice
+			if secretNames[k] {
+				continue
+			}
+
 			retDefinition.Environment = append(retDefinition.Environment, types.KeyValuePair{
 				Name:  options.Ptr(k),
 				Value: options.Ptr(v),
 			})
 		}
+
+		// the order of the map isn't stable, which would change the task definition hash
+		sort.Slice(retDefinition.Environment, func(i, j int) bool {
+			return *retDefinition.Environment[i].Name < *retDefinition.Environment[j].Name
+		})
 	}
 
-	entrypoint, cmd, err := inject.GetContainerEntrypoint([]string{runOptions.Entrypoint}, runOptions.Cmd)
+	entrypoint, cmd, err := inject.GetContainerEntrypoint([]string{runOptions.Entrypoint}, runOptions.Cmd, getInstallDir(runOptions))
 	if err != nil {
 		return types.ContainerDefinition{}, err
 	}
@@ -143,13 +256,19 @@ func (p *EcsProvider) getContainerDefinition(workspaceId string, runOptions *dri
 	}
 	retDefinition.DockerSecurityOptions = runOptions.SecurityOpt
 	retDefinition.Privileged = runOptions.Privileged
+	retDefinition.LinuxParameters.Capabilities, err = p.getCapabilities(runOptions.CapAdd)
+	if err != nil {
+		return types.ContainerDefinition{}, err
+	}
 
 	// mount points
-	if p.Config.LaunchType != string(types.LaunchTypeFargate) {
+	if p.hasVolumes() {
 		retDefinition.MountPoints = append(retDefinition.MountPoints, types.MountPoint{
-			ContainerPath: options.Ptr("/workspaces"),
+			ContainerPath: options.Ptr(getWorkspaceDir(runOptions)),
 			SourceVolume:  options.Ptr("devpod-" + workspaceId),
 		})
+	}
+	if p.hasMountVolumes() {
 		for _, mount := range runOptions.Mounts {
 			if mount.Source == "" || mount.Target == "" {
 				continue
@@ -165,51 +284,63 @@ func (p *EcsProvider) getContainerDefinition(workspaceId string, runOptions *dri
 	return retDefinition, nil
 }
 
-func volumeName(workspaceId, source string) string {
-	return "devpod-" + workspaceId + "-" + hash.String(source)[:5]
+// getWorkspaceDir returns the directory the workspace volume is mounted at, which is the
+// target of the workspace mount or /workspaces if there is none
+func getWorkspaceDir(runOptions *driver.RunOptions) string {
+	if runOptions.WorkspaceMount == nil || !path.IsAbs(runOptions.WorkspaceMount.Target) {
+		return "/workspaces"
+	}
+
+	return path.Clean(runOptions.WorkspaceMount.Target)
 }
 
-func (p *EcsProvider) deleteTaskDefinition(ctx context.Context, workspaceId string) error {
-	taskDefinitionID := "devpod-" + workspaceId
+// getInstallDir returns where the entrypoint installs the provider binary, which is the parent
+// of the workspace mount target, so the binary doesn't end up in the sources, or /workspaces if
+// there is no such parent
+func getInstallDir(runOptions *driver.RunOptions) string {
+	installDir := path.Dir(getWorkspaceDir(runOptions))
+	if installDir == "/" {
+		return "/workspaces"
+	}
 
-	// list existing task definitions
-	output, err := p.client.ListTaskDefinitions(ctx, &ecs.ListTaskDefinitionsInput{
-		FamilyPrefix: options.Ptr(taskDefinitionID),
-		MaxResults:   options.Ptr(int32(10)),
-	})
-	if err != nil {
-		return err
-	} else if len(output.TaskDefinitionArns) > 0 {
-		// deregister task definitions
-		for _, taskDefinition := range output.TaskDefinitionArns {
-			_, err = p.client.DeregisterTaskDefinition(ctx, &ecs.DeregisterTaskDefinitionInput{
-				TaskDefinition: &taskDefinition,
-			})
-			if err != nil {
-				return fmt.Errorf("deregister task definition %s: %w", taskDefinition, err)
-			}
-		}
+	return installDir
+}
 
-		// delete existing task definitions
-		p.Log.Info("Deleting task definition...")
-		output, err := p.client.DeleteTaskDefinitions(ctx, &ecs.DeleteTaskDefinitionsInput{
-			TaskDefinitions: output.TaskDefinitionArns,
-		})
-		if err != nil {
-			return err
-		} else if len(output.Failures) > 0 {
-			return errors.New(*output.Failures[0].Reason)
+// getVolumeNames returns the names of the volumes the task definition gets for the workspace
+// and the mounts
+func (p *EcsProvider) getVolumeNames(workspaceId string, runOptions *driver.RunOptions) []string {
+	names := []string{}
+	if p.hasVolumes() {
+		names = append(names, "devpod-"+workspaceId)
+	}
+	if p.hasMountVolumes() {
+		for _, mount := range runOptions.Mounts {
+			if mount.Source != "" && mount.Target != "" {
+				names = append(names, volumeName(workspaceId, mount.Source))
+			}
 		}
 	}
 
-	return nil
+	return names
 }
 
-func getTags(workspaceId string) []types.Tag {
-	return []types.Tag{
-		{
-			Key:   options.Ptr("devpod-workspace-id"),
-			Value: options.Ptr(workspaceId),
-		},
-	}
+// hasVolumes returns true if the task definition gets a volume for the workspace
+func (p *EcsProvider) hasVolumes() bool {
+	return p.Config.EbsVolumeSize > 0 || p.hasMountVolumes()
+}
+
+// hasMountVolumes returns true if the task definition gets volumes for the mounts, which
+// are either docker volumes on EC2 / EXTERNAL or EFS access points on any launch type
+func (p *EcsProvider) hasMountVolumes() bool {
+	return p.Config.EfsFileSystemID != "" || p.Config.LaunchType != string(types.LaunchTypeFargate)
+}
+
+// hasDockerVolumes returns true if the volumes are shared docker volumes that live on a
+// single container instance
+func (p *EcsProvider) hasDockerVolumes() bool {
+	return p.Config.EfsFileSystemID == "" && p.Config.LaunchType != string(types.LaunchTypeFargate)
+}
+
+func volumeName(workspaceId, source string) string {
+	return "devpod-" + workspaceId + "-" + hash.String(source)[:5]
 }
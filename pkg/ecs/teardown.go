@@ -0,0 +1,461 @@
+package ecs
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"slices"
+	"strings"
+
+	"github.com/aws/aws-sdk-go-v2/aws"
+	"github.com/aws/aws-sdk-go-v2/service/cloudwatchlogs"
+	"github.com/aws/aws-sdk-go-v2/service/ecs"
+	"github.com/aws/aws-sdk-go-v2/service/ecs/types"
+	"github.com/aws/aws-sdk-go-v2/service/efs"
+	"github.com/aws/aws-sdk-go-v2/service/iam"
+	iamtypes "github.com/aws/aws-sdk-go-v2/service/iam/types"
+	"github.com/loft-sh/devpod-provider-ecs/pkg/options"
+)
+
+// the tag teardown uses to find the iam roles, policies and log groups the provider created
+const (
+	managedTagKey   = "devpod-managed-by"
+	managedTagValue = "devpod-provider-ecs"
+)
+
+// legacy iam resources older versions of the provider created without tags. They are shared
+// by all clusters.
+var (
+	legacyRoleNames   = []string{"devpod-ecs-role", "devpod-ecs-infrastructure-role"}
+	legacyPolicyNames = []string{"devpod-ecs-policy"}
+)
+
+// Resource is an AWS resource the provider created
+type Resource struct {
+	Type string
+	ID   string
+
+	// shared is set for resources other clusters might use, e.g. the default log group
+	shared bool
+	delete func(ctx context.Context) error
+}
+
+// ListResources returns the resources the provider created for the cluster, in the order
+// they have to be deleted in: task definitions and access points first, the roles before
+// their policies. Workspaces with a running task are skipped and, as they still need them,
+// so are the log group and the iam resources. Resources shared with other clusters are only
+// returned if includeShared is set, as their workspaces aren't checked.
+func (p *EcsProvider) ListResources(ctx context.Context, includeShared bool) ([]Resource, error) {
+	running, err := p.getRunningFamilies(ctx)
+	if err != nil {
+		return nil, err
+	}
+	for _, family := range running {
+		p.Log.Warnf("Skipping workspace %s as it still has a running task, delete the workspace first", strings.TrimPrefix(family, "devpod-"))
+	}
+
+	resources, shared := []Resource{}, 0
+	listers := []func(ctx context.Context, running []string) ([]Resource, error){
+		p.listTaskDefinitionResources,
+		p.listAccessPointResources,
+	}
+	if len(running) == 0 {
+		listers = append(listers, p.listLogGroupResources, p.listRoleResources, p.listPolicyResources)
+	} else {
+		p.Log.Warnf("Keeping the log group and the iam roles and policies, as %d workspaces are still running", len(running))
+	}
+	for _, lister := range listers {
+		found, err := lister(ctx, running)
+		if err != nil {
+			return nil, err
+		}
+
+		for _, resource := range found {
+			if resource.shared && !includeShared {
+				shared++
+				continue
+			}
+
+			resources = append(resources, resource)
+		}
+	}
+	if shared > 0 {
+		p.Log.Warnf("Keeping %d resources that other clusters might use, e.g. the log group, use --include-shared to delete them too", shared)
+	}
+
+	return resources, nil
+}
+
+// getRunningFamilies returns the task definition families of the tasks that should be
+// running in the cluster
+func (p *EcsProvider) getRunningFamilies(ctx context.Context) ([]string, error) {
+	taskArns, err := p.listTaskArns(ctx, "", types.DesiredStatusRunning)
+	if err != nil {
+		return nil, fmt.Errorf("list running tasks: %w", err)
+	}
+	tasks, err := p.describeTasks(ctx, taskArns)
+	if err != nil {
+		return nil, err
+	}
+
+	families := []string{}
+	for _, task := range tasks {
+		family := getFamilyFromArn(aws.ToString(task.TaskDefinitionArn))
+		if strings.HasPrefix(family, "devpod-") && !slices.Contains(families, family) {
+			families = append(families, family)
+		}
+	}
+	slices.Sort(families)
+
+	return families, nil
+}
+
+// DeleteResources deletes the given resources. It continues with the remaining resources
+// if one can't be deleted and returns all errors.
+func (p *EcsProvider) DeleteResources(ctx context.Context, resources []Resource) error {
+	errs := []error{}
+	for _, resource := range resources {
+		p.Log.Infof("Deleting %s %s...", resource.Type, resource.ID)
+		err := resource.delete(ctx)
+		if err != nil {
+			errs = append(errs, fmt.Errorf("delete %s %s: %w", resource.Type, resource.ID, err))
+		}
+	}
+
+	return errors.Join(errs...)
+}
+
+func (p *EcsProvider) listTaskDefinitionResources(ctx context.Context, running []string) ([]Resource, error) {
+	resources := []Resource{}
+	for _, status := range []types.TaskDefinitionStatus{types.TaskDefinitionStatusActive, types.TaskDefinitionStatusInactive} {
+		paginator := ecs.NewListTaskDefinitionsPaginator(p.client, &ecs.ListTaskDefinitionsInput{
+			FamilyPrefix: options.Ptr("devpod-"),
+			Status:       status,
+		})
+		for paginator.HasMorePages() {
+			output, err := paginator.NextPage(ctx)
+			if err != nil {
+				return nil, fmt.Errorf("list task definitions: %w", err)
+			}
+
+			for _, arn := range output.TaskDefinitionArns {
+				if slices.Contains(running, getFamilyFromArn(arn)) {
+					continue
+				}
+
+				// only delete task definitions devpod registered for this cluster
+				taskDefinition, err := p.client.DescribeTaskDefinition(ctx, &ecs.DescribeTaskDefinitionInput{
+					TaskDefinition: options.Ptr(arn),
+					Include:        []types.TaskDefinitionField{types.TaskDefinitionFieldTags},
+				})
+				if err != nil {
+					return nil, fmt.Errorf("describe task definition %s: %w", arn, err)
+				} else if getTag(taskDefinition.Tags, workspaceTagKey) == "" || getTag(taskDefinition.Tags, clusterTagKey) != p.Config.ClusterID {
+					continue
+				}
+
+				active := status == types.TaskDefinitionStatusActive
+				resources = append(resources, Resource{
+					Type: "task definition",
+					ID:   arn,
+					delete: func(ctx context.Context) error {
+						return p.deleteTaskDefinitionArn(ctx, arn, active)
+					},
+				})
+			}
+		}
+	}
+
+	return resources, nil
+}
+
+func (p *EcsProvider) deleteTaskDefinitionArn(ctx context.Context, arn string, active bool) error {
+	if active {
+		_, err := p.client.DeregisterTaskDefinition(ctx, &ecs.DeregisterTaskDefinitionInput{
+			TaskDefinition: options.Ptr(arn),
+		})
+		if err != nil {
+			return err
+		}
+	}
+
+	output, err := p.client.DeleteTaskDefinitions(ctx, &ecs.DeleteTaskDefinitionsInput{
+		TaskDefinitions: []string{arn},
+	})
+	if err != nil {
+		return err
+	} else if len(output.Failures) > 0 {
+		return errors.New(aws.ToString(output.Failures[0].Reason))
+	}
+
+	return nil
+}
+
+func (p *EcsProvider) listAccessPointResources(ctx context.Context, running []string) ([]Resource, error) {
+	if p.Config.EfsFileSystemID == "" {
+		return nil, nil
+	}
+
+	resources := []Resource{}
+	paginator := efs.NewDescribeAccessPointsPaginator(p.efsClient, &efs.DescribeAccessPointsInput{
+		FileSystemId: options.Ptr(p.Config.EfsFileSystemID),
+	})
+	for paginator.HasMorePages() {
+		output, err := paginator.NextPage(ctx)
+		if err != nil {
+			return nil, fmt.Errorf("list efs access points: %w", err)
+		}
+
+		for _, accessPoint := range output.AccessPoints {
+			workspaceId := getEfsTag(accessPoint.Tags, workspaceTagKey)
+			if workspaceId == "" || getEfsTag(accessPoint.Tags, clusterTagKey) != p.Config.ClusterID {
+				continue
+			} else if slices.Contains(running, newWorkspace(workspaceId).Family) {
+				continue
+			}
+
+			accessPointID := aws.ToString(accessPoint.AccessPointId)
+			resources = append(resources, Resource{
+				Type: "efs access point",
+				ID:   accessPointID,
+				delete: func(ctx context.Context) error {
+					_, err := p.efsClient.DeleteAccessPoint(ctx, &efs.DeleteAccessPointInput{
+						AccessPointId: options.Ptr(accessPointID),
+					})
+					return err
+				},
+			})
+		}
+	}
+
+	return resources, nil
+}
+
+func (p *EcsProvider) listLogGroupResources(ctx context.Context, _ []string) ([]Resource, error) {
+	if p.Config.LogGroup == "" {
+		return nil, nil
+	}
+
+	logGroup, err := p.getLogGroup(ctx)
+	if err != nil {
+		return nil, err
+	} else if logGroup == nil {
+		return nil, nil
+	}
+
+	// don't delete a log group the user created
+	tags, err := p.logsClient.ListTagsForResource(ctx, &cloudwatchlogs.ListTagsForResourceInput{
+		ResourceArn: logGroup.LogGroupArn,
+	})
+	if err != nil {
+		return nil, fmt.Errorf("list log group tags: %w", err)
+	} else if tags.Tags[managedTagKey] != managedTagValue {
+		return nil, nil
+	}
+
+	// the log group isn't scoped to the cluster
+	return []Resource{
+		{
+			Type:   "log group",
+			ID:     p.Config.LogGroup,
+			shared: true,
+			delete: func(ctx context.Context) error {
+				_, err := p.logsClient.DeleteLogGroup(ctx, &cloudwatchlogs.DeleteLogGroupInput{
+					LogGroupName: options.Ptr(p.Config.LogGroup),
+				})
+				return err
+			},
+		},
+	}, nil
+}
+
+func (p *EcsProvider) listRoleResources(ctx context.Context, _ []string) ([]Resource, error) {
+	resources := []Resource{}
+	paginator := iam.NewListRolesPaginator(p.iamClient, &iam.ListRolesInput{
+		PathPrefix: options.Ptr(p.Config.IamPath),
+	})
+	for paginator.HasMorePages() {
+		output, err := paginator.NextPage(ctx)
+		if err != nil {
+			return nil, fmt.Errorf("list iam roles: %w", err)
+		}
+
+		for _, role := range output.Roles {
+			roleName := aws.ToString(role.RoleName)
+			shared := slices.Contains(legacyRoleNames, roleName)
+			if !shared {
+				if !strings.HasPrefix(roleName, p.Config.RoleNamePrefix+"-") {
+					continue
+				}
+
+				// ListRoles doesn't return the tags
+				tags, err := p.iamClient.ListRoleTags(ctx, &iam.ListRoleTagsInput{
+					RoleName: options.Ptr(roleName),
+				})
+				if err != nil {
+					return nil, fmt.Errorf("list tags of iam role %s: %w", roleName, err)
+				} else if getIamTag(tags.Tags, managedTagKey) != managedTagValue {
+					continue
+				}
+
+				// roles without the cluster tag, like the infrastructure role, are shared
+				cluster := getIamTag(tags.Tags, clusterTagKey)
+				if cluster != "" && cluster != p.Config.ClusterID {
+					continue
+				}
+				shared = cluster == ""
+			}
+
+			resources = append(resources, Resource{
+				Type:   "iam role",
+				ID:     roleName,
+				shared: shared,
+				delete: func(ctx context.Context) error {
+					return p.deleteRole(ctx, roleName)
+				},
+			})
+		}
+	}
+
+	return resources, nil
+}
+
+// deleteRole detaches the managed and deletes the inline policies of the role, which IAM
+// requires before the role can be deleted
+func (p *EcsProvider) deleteRole(ctx context.Context, roleName string) error {
+	attachedPaginator := iam.NewListAttachedRolePoliciesPaginator(p.iamClient, &iam.ListAttachedRolePoliciesInput{
+		RoleName: options.Ptr(roleName),
+	})
+	for attachedPaginator.HasMorePages() {
+		output, err := attachedPaginator.NextPage(ctx)
+		if err != nil {
+			return fmt.Errorf("list attached policies: %w", err)
+		}
+
+		for _, policy := range output.AttachedPolicies {
+			_, err = p.iamClient.DetachRolePolicy(ctx, &iam.DetachRolePolicyInput{
+				RoleName:  options.Ptr(roleName),
+				PolicyArn: policy.PolicyArn,
+			})
+			if err != nil {
+				return fmt.Errorf("detach policy %s: %w", aws.ToString(policy.PolicyArn), err)
+			}
+		}
+	}
+
+	inlinePaginator := iam.NewListRolePoliciesPaginator(p.iamClient, &iam.ListRolePoliciesInput{
+		RoleName: options.Ptr(roleName),
+	})
+	for inlinePaginator.HasMorePages() {
+		output, err := inlinePaginator.NextPage(ctx)
+		if err != nil {
+			return fmt.Errorf("list inline policies: %w", err)
+		}
+
+		for _, policyName := range output.PolicyNames {
+			_, err = p.iamClient.DeleteRolePolicy(ctx, &iam.DeleteRolePolicyInput{
+				RoleName:   options.Ptr(roleName),
+				PolicyName: options.Ptr(policyName),
+			})
+			if err != nil {
+				return fmt.Errorf("delete inline policy %s: %w", policyName, err)
+			}
+		}
+	}
+
+	_, err := p.iamClient.DeleteRole(ctx, &iam.DeleteRoleInput{
+		RoleName: options.Ptr(roleName),
+	})
+	return err
+}
+
+func (p *EcsProvider) listPolicyResources(ctx context.Context, _ []string) ([]Resource, error) {
+	resources := []Resource{}
+	paginator := iam.NewListPoliciesPaginator(p.iamClient, &iam.ListPoliciesInput{
+		Scope:      iamtypes.PolicyScopeTypeLocal,
+		PathPrefix: options.Ptr(p.Config.IamPath),
+	})
+	for paginator.HasMorePages() {
+		output, err := paginator.NextPage(ctx)
+		if err != nil {
+			return nil, fmt.Errorf("list iam policies: %w", err)
+		}
+
+		for _, policy := range output.Policies {
+			policyName, policyArn := aws.ToString(policy.PolicyName), aws.ToString(policy.Arn)
+			shared := slices.Contains(legacyPolicyNames, policyName)
+			if !shared {
+				if !strings.HasPrefix(policyName, p.Config.RoleNamePrefix+"-") {
+					continue
+				}
+
+				// ListPolicies doesn't return the tags
+				tags, err := p.iamClient.ListPolicyTags(ctx, &iam.ListPolicyTagsInput{
+					PolicyArn: options.Ptr(policyArn),
+				})
+				if err != nil {
+					return nil, fmt.Errorf("list tags of iam policy %s: %w", policyName, err)
+				} else if getIamTag(tags.Tags, managedTagKey) != managedTagValue {
+					continue
+				}
+
+				cluster := getIamTag(tags.Tags, clusterTagKey)
+				if cluster != "" && cluster != p.Config.ClusterID {
+					continue
+				}
+				shared = cluster == ""
+			}
+
+			resources = append(resources, Resource{
+				Type:   "iam policy",
+				ID:     policyArn,
+				shared: shared,
+				delete: func(ctx context.Context) error {
+					return p.deletePolicy(ctx, policyArn)
+				},
+			})
+		}
+	}
+
+	return resources, nil
+}
+
+// deletePolicy deletes the non default versions of the policy, which IAM requires before
+// the policy can be deleted
+func (p *EcsProvider) deletePolicy(ctx context.Context, policyArn string) error {
+	output, err := p.iamClient.ListPolicyVersions(ctx, &iam.ListPolicyVersionsInput{
+		PolicyArn: options.Ptr(policyArn),
+	})
+	if err != nil {
+		return fmt.Errorf("list policy versions: %w", err)
+	}
+
+	for _, version := range output.Versions {
+		if version.IsDefaultVersion {
+			continue
+		}
+
+		_, err = p.iamClient.DeletePolicyVersion(ctx, &iam.DeletePolicyVersionInput{
+			PolicyArn: options.Ptr(policyArn),
+			VersionId: version.VersionId,
+		})
+		if err != nil {
+			return fmt.Errorf("delete policy version %s: %w", aws.ToString(version.VersionId), err)
+		}
+	}
+
+	_, err = p.iamClient.DeletePolicy(ctx, &iam.DeletePolicyInput{
+		PolicyArn: options.Ptr(policyArn),
+	})
+	return err
+}
+
+func getIamTag(tags []iamtypes.Tag, key string) string {
+	for _, tag := range tags {
+		if aws.ToString(tag.Key) == key {
+			return aws.ToString(tag.Value)
+		}
+	}
+
+	return ""
+}
@@ -0,0 +1,182 @@
+package ecs
+
+import (
+	"context"
+	"slices"
+	"testing"
+
+	"github.com/aws/aws-sdk-go-v2/service/cloudwatchlogs"
+	"github.com/aws/aws-sdk-go-v2/service/ecs"
+	"github.com/aws/aws-sdk-go-v2/service/ecs/types"
+	"github.com/aws/aws-sdk-go-v2/service/iam"
+	"github.com/loft-sh/devpod-provider-ecs/pkg/ecs/fake"
+	"github.com/loft-sh/devpod-provider-ecs/pkg/hash"
+	"github.com/loft-sh/devpod-provider-ecs/pkg/options"
+)
+
+func TestTeardown(t *testing.T) {
+	env := newTestEnv(t, func(o *options.Options) {
+		o.TaskRoleARN = ""
+		o.ExecutionRoleARN = ""
+		o.EfsFileSystemID = "fs-1"
+		o.CloudWatchLogs = true
+		o.LogGroup = "/devpod/ecs"
+	})
+	ctx := context.Background()
+
+	err := env.provider.RunTask(ctx, "workspace", testRunOptions())
+	if err != nil {
+		t.Fatalf("run task: %v", err)
+	}
+
+	// a role of an older provider version with an inline policy
+	_, err = env.iam.CreateRole(ctx, &iam.CreateRoleInput{
+		RoleName:                 options.Ptr("devpod-ecs-role"),
+		AssumeRolePolicyDocument: options.Ptr("{}"),
+	})
+	if err != nil {
+		t.Fatalf("create legacy role: %v", err)
+	}
+	_, err = env.iam.PutRolePolicy(ctx, &iam.PutRolePolicyInput{
+		RoleName:       options.Ptr("devpod-ecs-role"),
+		PolicyName:     options.Ptr("devpod-ecs-secrets"),
+		PolicyDocument: options.Ptr("{}"),
+	})
+	if err != nil {
+		t.Fatalf("put legacy inline policy: %v", err)
+	}
+
+	// resources the provider didn't create must be left alone
+	_, err = env.iam.CreateRole(ctx, &iam.CreateRoleInput{
+		RoleName:                 options.Ptr("devpod-ecs-custom"),
+		AssumeRolePolicyDocument: options.Ptr("{}"),
+	})
+	if err != nil {
+		t.Fatalf("create custom role: %v", err)
+	}
+	_, err = env.logs.CreateLogGroup(ctx, &cloudwatchlogs.CreateLogGroupInput{LogGroupName: options.Ptr("/devpod/ecs-custom")})
+	if err != nil {
+		t.Fatalf("create custom log group: %v", err)
+	}
+
+	// the same workspace on another cluster sharing the file system and the log group
+	otherProvider := *env.provider
+	otherConfig := *env.provider.Config
+	otherConfig.ClusterID = "other"
+	otherConfig.TaskRoleARN = ""
+	otherConfig.ExecutionRoleARN = ""
+	otherProvider.Config = &otherConfig
+	_, err = otherProvider.ensureAccessPoint(ctx, "workspace", "devpod-workspace")
+	if err != nil {
+		t.Fatalf("create access point of other cluster: %v", err)
+	}
+	_, err = env.ecs.RegisterTaskDefinition(ctx, &ecs.RegisterTaskDefinitionInput{
+		Family: options.Ptr("devpod-other"),
+		ContainerDefinitions: []types.ContainerDefinition{
+			{Name: options.Ptr("devpod"), Image: options.Ptr("alpine")},
+		},
+		Tags: append(newWorkspace("other").Tags(), otherProvider.getClusterTag()),
+	})
+	if err != nil {
+		t.Fatalf("register task definition of other cluster: %v", err)
+	}
+	err = otherProvider.ensureRoles(ctx, "other")
+	if err != nil {
+		t.Fatalf("create roles of other cluster: %v", err)
+	}
+	_, err = env.ecs.RunTask(ctx, &ecs.RunTaskInput{
+		Cluster:        options.Ptr("other"),
+		TaskDefinition: options.Ptr("devpod-other"),
+	})
+	if err != nil {
+		t.Fatalf("run task on other cluster: %v", err)
+	}
+	otherRoles := []string{"devpod-ecs-other-execution-" + hash.String("devpod-other")[:8], "devpod-ecs-other-task"}
+	otherPolicies := []string{
+		"arn:aws:iam::" + fake.AccountID + ":policy/devpod-ecs-other-execution-policy",
+		"arn:aws:iam::" + fake.AccountID + ":policy/devpod-ecs-other-task-policy",
+	}
+
+	// a running workspace keeps its resources and the shared ones
+	resources, err := env.provider.ListResources(ctx, false)
+	if err != nil {
+		t.Fatalf("list resources: %v", err)
+	} else if len(resources) != 0 {
+		t.Fatalf("expected no resources while the workspace is running, got %v", resources)
+	}
+
+	err = env.provider.StopTask(ctx, "workspace")
+	if err != nil {
+		t.Fatalf("stop task: %v", err)
+	}
+	// the running task of the other cluster doesn't keep the resources of this one
+	resources, err = env.provider.ListResources(ctx, false)
+	if err != nil {
+		t.Fatalf("list resources: %v", err)
+	}
+
+	found := []string{}
+	for _, resource := range resources {
+		found = append(found, resource.Type+" "+resource.ID)
+	}
+	expected := []string{
+		"task definition " + *activeTaskDefinitions(env.ecs)[0].TaskDefinitionArn,
+		"efs access point " + *env.efs.AccessPoints()[0].AccessPointId,
+		"iam role " + testExecutionRoleName("workspace"),
+		"iam role devpod-ecs-devpod-task",
+		"iam policy " + executionPolicyArn,
+		"iam policy " + taskPolicyArn,
+	}
+	if !slices.Equal(found, expected) {
+		t.Fatalf("expected resources %v, got %v", expected, found)
+	}
+
+	err = env.provider.DeleteResources(ctx, resources)
+	if err != nil {
+		t.Fatalf("delete resources: %v", err)
+	}
+
+	if taskDefinitions := activeTaskDefinitions(env.ecs); len(taskDefinitions) != 1 || *taskDefinitions[0].Family != "devpod-other" {
+		t.Fatalf("expected only the task definition of the other cluster to be kept, got %v", taskDefinitions)
+	} else if accessPoints := env.efs.AccessPoints(); len(accessPoints) != 1 || getEfsTag(accessPoints[0].Tags, clusterTagKey) != "other" {
+		t.Fatalf("expected only the efs access point of the other cluster to be kept, got %v", accessPoints)
+	} else if env.logs.LogGroup("/devpod/ecs") == nil {
+		t.Fatalf("expected the shared log group to be kept")
+	} else if roles := slices.Sorted(slices.Values(env.iam.Roles())); !slices.Equal(roles, append([]string{"devpod-ecs-custom"}, append(otherRoles, "devpod-ecs-role")...)) {
+		t.Fatalf("expected the custom, shared and other cluster roles to be kept, got %v", roles)
+	} else if policies := slices.Sorted(slices.Values(env.iam.Policies())); !slices.Equal(policies, otherPolicies) {
+		t.Fatalf("expected only the policies of the other cluster to be kept, got %v", policies)
+	}
+
+	// the shared resources are only deleted if requested
+	resources, err = env.provider.ListResources(ctx, true)
+	if err != nil {
+		t.Fatalf("list shared resources: %v", err)
+	}
+	found = []string{}
+	for _, resource := range resources {
+		found = append(found, resource.Type+" "+resource.ID)
+	}
+	expected = []string{"log group /devpod/ecs", "iam role devpod-ecs-role"}
+	if !slices.Equal(found, expected) {
+		t.Fatalf("expected shared resources %v, got %v", expected, found)
+	}
+	err = env.provider.DeleteResources(ctx, resources)
+	if err != nil {
+		t.Fatalf("delete shared resources: %v", err)
+	} else if env.logs.LogGroup("/devpod/ecs") != nil {
+		t.Fatalf("expected log group to be deleted")
+	} else if env.logs.LogGroup("/devpod/ecs-custom") == nil {
+		t.Fatalf("expected custom log group to be kept")
+	} else if roles := slices.Sorted(slices.Values(env.iam.Roles())); !slices.Equal(roles, append([]string{"devpod-ecs-custom"}, otherRoles...)) {
+		t.Fatalf("expected the custom and other cluster roles to be kept, got %v", roles)
+	}
+
+	// a second teardown finds nothing
+	resources, err = env.provider.ListResources(ctx, true)
+	if err != nil {
+		t.Fatalf("list resources again: %v", err)
+	} else if len(resources) != 0 {
+		t.Fatalf("expected no resources after teardown, got %v", resources)
+	}
+}
@@ -0,0 +1,75 @@
+package ecs
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"time"
+
+	"github.com/aws/aws-sdk-go-v2/aws"
+	"github.com/aws/aws-sdk-go-v2/service/ecs"
+	"github.com/aws/aws-sdk-go-v2/service/ecs/types"
+	"github.com/loft-sh/devpod-provider-ecs/pkg/options"
+)
+
+// maxPollInterval is the longest time between two polls while waiting for a task
+const maxPollInterval = time.Second * 10
+
+// errWaitTimeout is returned by waitForTask if START_TIMEOUT passed
+var errWaitTimeout = errors.New("timed out waiting for task")
+
+// waitForTask polls the task until done returns true, START_TIMEOUT passed or ctx is done.
+// done receives nil if ECS doesn't know the task (anymore). The poll interval starts at the
+// provider poll interval and doubles after every poll up to maxPollInterval. Every status
+// transition of the task is logged. The last seen task is returned on errors as well.
+func (p *EcsProvider) waitForTask(ctx context.Context, taskArn string, done func(task *types.Task) bool) (*types.Task, error) {
+	start := time.Now()
+	interval := p.pollInterval
+	lastStatus := ""
+
+	var task *types.Task
+	for {
+		output, err := p.client.DescribeTasks(ctx, &ecs.DescribeTasksInput{
+			Tasks:   []string{taskArn},
+			Cluster: options.Ptr(p.Config.ClusterID),
+		})
+		if err != nil {
+			return task, fmt.Errorf("describe task: %w", err)
+		}
+
+		var current *types.Task
+		if len(output.Tasks) > 0 {
+			current = &output.Tasks[0]
+			task = current
+			if status := aws.ToString(task.LastStatus); status != lastStatus {
+				p.Log.Infof("Task is %s (%s)", status, time.Since(start).Round(time.Second))
+				lastStatus = status
+			}
+		}
+		if done(current) {
+			return task, nil
+		}
+
+		remaining := p.Config.StartTimeout - time.Since(start)
+		if remaining <= 0 {
+			return task, errWaitTimeout
+		}
+
+		select {
+		case <-ctx.Done():
+			return task, ctx.Err()
+		case <-time.After(min(interval, remaining)):
+		}
+		interval = min(interval*2, maxPollInterval)
+	}
+}
+
+// sleep waits for the duration or until ctx is done
+func sleep(ctx context.Context, duration time.Duration) error {
+	select {
+	case <-ctx.Done():
+		return ctx.Err()
+	case <-time.After(duration):
+		return nil
+	}
+}
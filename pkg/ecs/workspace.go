@@ -0,0 +1,223 @@
+package ecs
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"sort"
+	"strconv"
+	"strings"
+
+	"github.com/aws/aws-sdk-go-v2/aws"
+	"github.com/aws/aws-sdk-go-v2/service/ecs"
+	"github.com/aws/aws-sdk-go-v2/service/ecs/types"
+	"github.com/loft-sh/devpod-provider-ecs/pkg/options"
+)
+
+// workspaceTagKey is the tag every resource of a workspace carries
+const workspaceTagKey = "devpod-workspace-id"
+
+// clusterTagKey is the tag that scopes resources found by tag to a cluster, as the same
+// workspace id can exist on several clusters of an account
+const clusterTagKey = "devpod-cluster"
+
+// maxDeleteTaskDefinitions is the most task definitions DeleteTaskDefinitions accepts at once
+const maxDeleteTaskDefinitions = 10
+
+// workspace identifies the resources of a workspace. ECS only filters task definitions by
+// family prefix, so devpod-api would also match devpod-api-v2. The family is compared
+// exactly instead and must not carry the tag of another workspace before it is touched.
+type workspace struct {
+	ID     string
+	Family string
+}
+
+func newWorkspace(workspaceId string) workspace {
+	return workspace{
+		ID:     workspaceId,
+		Family: "devpod-" + workspaceId,
+	}
+}
+
+// Tags returns the tags of the task definitions, tasks and volumes of the workspace
+func (w workspace) Tags() []types.Tag {
+	return []types.Tag{
+		{
+			Key:   options.Ptr(workspaceTagKey),
+			Value: options.Ptr(w.ID),
+		},
+	}
+}
+
+// getClusterTag returns the tag that marks resources as created for the configured cluster
+func (p *EcsProvider) getClusterTag() types.Tag {
+	return types.Tag{
+		Key:   options.Ptr(clusterTagKey),
+		Value: options.Ptr(p.Config.ClusterID),
+	}
+}
+
+// owns returns true if the tags belong to the workspace
+func (w workspace) owns(tags []types.Tag) bool {
+	return getTag(tags, workspaceTagKey) == w.ID
+}
+
+// listTaskDefinitions returns the task definitions of the workspace family with the given
+// status, oldest revision first
+func (p *EcsProvider) listTaskDefinitions(ctx context.Context, workspace workspace, status types.TaskDefinitionStatus) ([]string, error) {
+	arns := []string{}
+	paginator := ecs.NewListTaskDefinitionsPaginator(p.client, &ecs.ListTaskDefinitionsInput{
+		FamilyPrefix: options.Ptr(workspace.Family),
+		Status:       status,
+	})
+	for paginator.HasMorePages() {
+		output, err := paginator.NextPage(ctx)
+		if err != nil {
+			return nil, fmt.Errorf("list task definitions: %w", err)
+		}
+
+		for _, arn := range output.TaskDefinitionArns {
+			if getFamilyFromArn(arn) == workspace.Family {
+				arns = append(arns, arn)
+			}
+		}
+	}
+
+	// don't rely on the listing order to find the newest revision
+	sort.SliceStable(arns, func(i, j int) bool {
+		return getRevisionFromArn(arns[i]) < getRevisionFromArn(arns[j])
+	})
+
+	return arns, nil
+}
+
+// checkOwnership fails if the task definition isn't tagged with the workspace, so a family
+// that merely has the same name is never changed. All revisions of a family belong to the
+// same workspace, so only the newest one is checked.
+func (p *EcsProvider) checkOwnership(ctx context.Context, workspace workspace, arn string) error {
+	taskDefinition, err := p.client.DescribeTaskDefinition(ctx, &ecs.DescribeTaskDefinitionInput{
+		TaskDefinition: options.Ptr(arn),
+		Include:        []types.TaskDefinitionField{types.TaskDefinitionFieldTags},
+	})
+	if err != nil {
+		return fmt.Errorf("describe task definition %s: %w", arn, err)
+	} else if !workspace.owns(taskDefinition.Tags) {
+		return fmt.Errorf("task definition %s isn't tagged with %s=%s, refusing to touch it", arn, workspaceTagKey, workspace.ID)
+	}
+
+	return nil
+}
+
+// getLatestTaskDefinition returns the newest active revision of the workspace family or an
+// empty string if there is none
+func (p *EcsProvider) getLatestTaskDefinition(ctx context.Context, workspace workspace) (string, error) {
+	arns, err := p.listTaskDefinitions(ctx, workspace, types.TaskDefinitionStatusActive)
+	if err != nil {
+		return "", err
+	} else if len(arns) == 0 {
+		return "", nil
+	}
+
+	latest := arns[len(arns)-1]
+	err = p.checkOwnership(ctx, workspace, latest)
+	if err != nil {
+		return "", err
+	}
+
+	return latest, nil
+}
+
+// deleteTaskDefinitions deregisters the active and deletes all task definitions of the
+// workspace family
+func (p *EcsProvider) deleteTaskDefinitions(ctx context.Context, workspace workspace) error {
+	return p.deleteOldTaskDefinitions(ctx, workspace, "")
+}
+
+// deleteOldTaskDefinitions deletes all task definitions of the workspace family except keep
+func (p *EcsProvider) deleteOldTaskDefinitions(ctx context.Context, workspace workspace, keep string) error {
+	active, err := p.listTaskDefinitions(ctx, workspace, types.TaskDefinitionStatusActive)
+	if err != nil {
+		return err
+	}
+	// this includes revisions a previous delete deregistered but didn't delete
+	inactive, err := p.listTaskDefinitions(ctx, workspace, types.TaskDefinitionStatusInactive)
+	if err != nil {
+		return err
+	}
+
+	// check the newest revision of either status
+	newest := ""
+	for _, arn := range append(active, inactive...) {
+		if newest == "" || getRevisionFromArn(arn) > getRevisionFromArn(newest) {
+			newest = arn
+		}
+	}
+	if newest == "" || (newest == keep && len(active) == 1 && len(inactive) == 0) {
+		// nothing to delete
+		return nil
+	}
+	err = p.checkOwnership(ctx, workspace, newest)
+	if err != nil {
+		return err
+	}
+
+	deleted := inactive
+	for _, arn := range active {
+		if arn == keep {
+			continue
+		}
+
+		_, err = p.client.DeregisterTaskDefinition(ctx, &ecs.DeregisterTaskDefinitionInput{
+			TaskDefinition: options.Ptr(arn),
+		})
+		if err != nil {
+			return fmt.Errorf("deregister task definition %s: %w", arn, err)
+		}
+		deleted = append(deleted, arn)
+	}
+	if len(deleted) == 0 {
+		return nil
+	}
+
+	p.Log.Info("Deleting task definition...")
+	for start := 0; start < len(deleted); start += maxDeleteTaskDefinitions {
+		end := min(start+maxDeleteTaskDefinitions, len(deleted))
+		output, err := p.client.DeleteTaskDefinitions(ctx, &ecs.DeleteTaskDefinitionsInput{
+			TaskDefinitions: deleted[start:end],
+		})
+		if err != nil {
+			return err
+		} else if len(output.Failures) > 0 {
+			return errors.New(aws.ToString(output.Failures[0].Reason))
+		}
+	}
+
+	return nil
+}
+
+// getFamilyFromArn returns the family of a task definition arn like
+// arn:aws:ecs:region:account:task-definition/family:revision
+func getFamilyFromArn(arn string) string {
+	id := getIDFromArn(arn)
+	if index := strings.LastIndex(id, ":"); index >= 0 {
+		return id[:index]
+	}
+
+	return id
+}
+
+// getRevisionFromArn returns the revision of a task definition arn or 0 if it has none
+func getRevisionFromArn(arn string) int {
+	id := getIDFromArn(arn)
+	index := strings.LastIndex(id, ":")
+	if index < 0 {
+		return 0
+	}
+
+	revision, err := strconv.Atoi(id[index+1:])
+	if err != nil {
+		return 0
+	}
+
+	return revision
+}
@@ -0,0 +1,182 @@
+package ecs
+
+import (
+	"context"
+	"testing"
+
+	"github.com/aws/aws-sdk-go-v2/service/ecs"
+	"github.com/aws/aws-sdk-go-v2/service/ecs/types"
+	"github.com/loft-sh/devpod-provider-ecs/pkg/options"
+)
+
+func TestDeleteTaskKeepsOtherWorkspaces(t *testing.T) {
+	env := newTestEnv(t)
+	ctx := context.Background()
+
+	for _, workspaceId := range []string{"api", "api-v2"} {
+		err := env.provider.RunTask(ctx, workspaceId, testRunOptions())
+		if err != nil {
+			t.Fatalf("run task %s: %v", workspaceId, err)
+		}
+	}
+
+	err := env.provider.DeleteTask(ctx, "api")
+	if err != nil {
+		t.Fatalf("delete task: %v", err)
+	}
+
+	taskDefinitions := activeTaskDefinitions(env.ecs)
+	if len(taskDefinitions) != 1 || *taskDefinitions[0].Family != "devpod-api-v2" {
+		t.Fatalf("expected only the devpod-api-v2 task definition to be left, got %d", len(taskDefinitions))
+	}
+
+	_, err = env.provider.getTaskDefinitionArn(ctx, "api-v2")
+	if err != nil {
+		t.Fatalf("get task definition of api-v2: %v", err)
+	}
+}
+
+func TestTaskDefinitionsRefuseOtherWorkspace(t *testing.T) {
+	env := newTestEnv(t)
+	ctx := context.Background()
+
+	// a family with the same name that belongs to another workspace
+	_, err := env.ecs.RegisterTaskDefinition(ctx, &ecs.RegisterTaskDefinitionInput{
+		Family:               options.Ptr("devpod-api"),
+		ContainerDefinitions: []types.ContainerDefinition{{Name: options.Ptr("app")}},
+		Tags:                 newWorkspace("other").Tags(),
+	})
+	if err != nil {
+		t.Fatalf("register task definition: %v", err)
+	}
+
+	err = env.provider.deleteTaskDefinitions(ctx, newWorkspace("api"))
+	if err == nil {
+		t.Fatalf("expected deleting a family of another workspace to fail")
+	} else if len(activeTaskDefinitions(env.ecs)) != 1 {
+		t.Fatalf("expected the task definition to be kept")
+	}
+}
+
+func TestTaskDefinitionsRefuseUntagged(t *testing.T) {
+	env := newTestEnv(t)
+	ctx := context.Background()
+
+	// a family with the same name that wasn't created by the provider
+	_, err := env.ecs.RegisterTaskDefinition(ctx, &ecs.RegisterTaskDefinitionInput{
+		Family:               options.Ptr("devpod-api"),
+		ContainerDefinitions: []types.ContainerDefinition{{Name: options.Ptr("app")}},
+	})
+	if err != nil {
+		t.Fatalf("register task definition: %v", err)
+	}
+
+	err = env.provider.deleteTaskDefinitions(ctx, newWorkspace("api"))
+	if err == nil {
+		t.Fatalf("expected deleting an untagged family to fail")
+	} else if len(activeTaskDefinitions(env.ecs)) != 1 {
+		t.Fatalf("expected the task definition to be kept")
+	}
+
+	err = env.provider.RunTask(ctx, "api", testRunOptions())
+	if err == nil {
+		t.Fatalf("expected running a workspace with an untagged family to fail")
+	} else if len(env.ecs.TaskDefinitions()) != 1 {
+		t.Fatalf("expected no task definition to be registered, got %d", len(env.ecs.TaskDefinitions()))
+	}
+}
+
+// describeCountingECS counts the task definitions that are described
+type describeCountingECS struct {
+	ECSAPI
+
+	describes int
+}
+
+func (c *describeCountingECS) DescribeTaskDefinition(ctx context.Context, params *ecs.DescribeTaskDefinitionInput, optFns ...func(*ecs.Options)) (*ecs.DescribeTaskDefinitionOutput, error) {
+	c.describes++
+	return c.ECSAPI.DescribeTaskDefinition(ctx, params, optFns...)
+}
+
+func TestDeleteTaskDefinitionsDescribesNewestRevision(t *testing.T) {
+	env := newTestEnv(t)
+	ctx := context.Background()
+
+	workspace := newWorkspace("workspace")
+	for i := 0; i < 5; i++ {
+		_, err := env.ecs.RegisterTaskDefinition(ctx, &ecs.RegisterTaskDefinitionInput{
+			Family:               options.Ptr(workspace.Family),
+			ContainerDefinitions: []types.ContainerDefinition{{Name: options.Ptr("devpod")}},
+			Tags:                 workspace.Tags(),
+		})
+		if err != nil {
+			t.Fatalf("register task definition: %v", err)
+		}
+	}
+
+	client := &describeCountingECS{ECSAPI: env.ecs}
+	env.provider.client = client
+	err := env.provider.deleteTaskDefinitions(ctx, workspace)
+	if err != nil {
+		t.Fatalf("delete task definitions: %v", err)
+	} else if client.describes != 1 {
+		t.Fatalf("expected only the newest revision to be described, got %d describes", client.describes)
+	}
+}
+
+func TestDeleteTaskDefinitionsBatches(t *testing.T) {
+	env := newTestEnv(t)
+	ctx := context.Background()
+
+	workspace := newWorkspace("workspace")
+	for i := 0; i < 12; i++ {
+		_, err := env.ecs.RegisterTaskDefinition(ctx, &ecs.RegisterTaskDefinitionInput{
+			Family:               options.Ptr(workspace.Family),
+			ContainerDefinitions: []types.ContainerDefinition{{Name: options.Ptr("devpod")}},
+			Tags:                 workspace.Tags(),
+		})
+		if err != nil {
+			t.Fatalf("register task definition: %v", err)
+		}
+	}
+
+	err := env.provider.deleteTaskDefinitions(ctx, workspace)
+	if err != nil {
+		t.Fatalf("delete task definitions: %v", err)
+	} else if len(env.ecs.TaskDefinitions()) != 0 {
+		t.Fatalf("expected all task definitions to be deleted, got %d", len(env.ecs.TaskDefinitions()))
+	}
+}
+
+func TestGetTaskDefinitionArnPicksNewestRevision(t *testing.T) {
+	env := newTestEnv(t)
+	ctx := context.Background()
+
+	// stale revisions of older runs, more than fit on a single page
+	workspace := newWorkspace("workspace")
+	for i := 0; i < 105; i++ {
+		_, err := env.ecs.RegisterTaskDefinition(ctx, &ecs.RegisterTaskDefinitionInput{
+			Family:               options.Ptr(workspace.Family),
+			ContainerDefinitions: []types.ContainerDefinition{{Name: options.Ptr("devpod")}},
+			Tags:                 workspace.Tags(),
+		})
+		if err != nil {
+			t.Fatalf("register task definition: %v", err)
+		}
+	}
+
+	arn, err := env.provider.getTaskDefinitionArn(ctx, "workspace")
+	if err != nil {
+		t.Fatalf("get task definition arn: %v", err)
+	} else if getRevisionFromArn(arn) != 105 {
+		t.Fatalf("expected the newest revision 105, got %s", arn)
+	}
+
+	// a new run cleans up all stale revisions
+	err = env.provider.RunTask(ctx, "workspace", testRunOptions())
+	if err != nil {
+		t.Fatalf("run task: %v", err)
+	} else if len(env.ecs.TaskDefinitions()) != 1 {
+		t.Fatalf("expected only the new task definition to be left, got %d", len(env.ecs.TaskDefinitions()))
+	}
+}
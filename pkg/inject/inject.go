@@ -5,6 +5,7 @@ import (
 	"encoding/base64"
 	"encoding/json"
 	"fmt"
+	"path"
 	"strings"
 	"text/template"
 
@@ -18,7 +19,9 @@ const BaseURL = "https://github.com/loft-sh/devpod-provider-ecs/releases/downloa
 
 const LatestBaseURL = "https://github.com/loft-sh/devpod-provider-ecs/releases/latest/download/devpod-provider-ecs-linux-%s"
 
-func GetContainerEntrypoint(entrypoint []string, cmd []string) ([]string, []string, error) {
+// GetContainerEntrypoint returns the entrypoint that installs the provider binary into
+// installDir and starts the original entrypoint and cmd through it
+func GetContainerEntrypoint(entrypoint []string, cmd []string, installDir string) ([]string, []string, error) {
 	downloadAmd := ""
 	downloadArm := ""
 	if version.Version == "latest" {
@@ -29,7 +32,7 @@ func GetContainerEntrypoint(entrypoint []string, cmd []string) ([]string, []stri
 		downloadArm = fmt.Sprintf(BaseURL, version.Version, "arm64")
 	}
 
-	command := "/workspaces/devpod-provider-ecs entrypoint"
+	command := path.Join(installDir, "devpod-provider-ecs") + " entrypoint"
 	if len(entrypoint) > 0 {
 		out, err := json.Marshal(entrypoint)
 		if err != nil {
@@ -51,7 +54,7 @@ func GetContainerEntrypoint(entrypoint []string, cmd []string) ([]string, []stri
 		"DownloadAmd":     downloadAmd,
 		"DownloadArm":     downloadArm,
 		"InstallFilename": "devpod-provider-ecs",
-		"InstallDir":      "/workspaces",
+		"InstallDir":      installDir,
 		"Command":         command,
 	})
 	if err != nil {
@@ -1,52 +1,138 @@
 package options
 
 import (
+	"encoding/json"
+	"errors"
 	"fmt"
+	"io/fs"
+	"net/url"
 	"os"
+	"slices"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/ghodss/yaml"
 )
 
 var DefaultSSHPort int = 19583
 
+// logRetentionDays are the retention periods cloudwatch logs accepts
+var logRetentionDays = []int32{1, 3, 5, 7, 14, 30, 60, 90, 120, 150, 180, 365, 400, 545, 731, 1096, 1827, 2192, 2557, 2922, 3288, 3653}
+
 type Options struct {
 	DevContainerID string
 
 	ClusterID           string
 	ClusterArchitecture string
 
-	SubnetID        string
-	SecurityGroupID string
+	SubnetIDs        []string
+	SecurityGroupIDs []string
 
 	TaskRoleARN      string
 	ExecutionRoleARN string
 
+	RoleNamePrefix         string
+	IamPath                string
+	PermissionsBoundaryARN string
+	IamTags                map[string]string
+
+	RegistryCredentialsSecretARN string
+	RegistryCredentialsKmsKeyARN string
+	Secrets                      []Secret
+
 	TaskCpu    string
 	TaskMemory string
 
-	LaunchType     string
-	AssignPublicIp string
+	LaunchType               string
+	CapacityProviderStrategy []CapacityProvider
+	AssignPublicIp           string
+
+	EfsFileSystemID     string
+	EfsRootDirectory    string
+	EfsTransitEncrypted bool
+	EfsIamAuthorization bool
+
+	EbsVolumeSize            int32
+	EbsVolumeType            string
+	EbsIops                  int32
+	EbsThroughput            int32
+	EbsKmsKeyID              string
+	EbsInfrastructureRoleARN string
+
+	AllowInstanceMigration bool
+
+	StartTimeout time.Duration
+
+	CloudWatchLogs   bool
+	LogGroup         string
+	LogRetentionDays int32
+
+	// TaskDefinitionOverlay is a JSON object that is merged onto the generated task definition
+	TaskDefinitionOverlay string
+
+	EcsEndpoint  string
+	IamEndpoint  string
+	SsmEndpoint  string
+	EfsEndpoint  string
+	Ec2Endpoint  string
+	LogsEndpoint string
+}
+
+// Secret is an environment variable that is read from Secrets Manager or Parameter Store
+type Secret struct {
+	Name      string
+	ValueFrom string
+}
+
+// CapacityProvider is an entry of the capacity provider strategy tasks are run with
+type CapacityProvider struct {
+	Name   string
+	Weight int32
+	Base   int32
 }
 
 func FromEnv() (*Options, error) {
+	retOptions, err := FromProviderEnv()
+	if err != nil {
+		return nil, err
+	}
+
+	retOptions.DevContainerID, err = fromEnvOrError("DEVCONTAINER_ID")
+	if err != nil {
+		return nil, err
+	}
+
+	return retOptions, nil
+}
+
+// FromProviderEnv reads the provider options from the environment without requiring a
+// workspace, e.g. for commands that receive the workspace id as an argument
+func FromProviderEnv() (*Options, error) {
 	retOptions := &Options{}
 
 	var err error
 
 	// required
-	retOptions.DevContainerID, err = fromEnvOrError("DEVCONTAINER_ID")
+	retOptions.ClusterID, err = fromEnvOrError("CLUSTER_ID")
 	if err != nil {
 		return nil, err
 	}
-	retOptions.ClusterID, err = fromEnvOrError("CLUSTER_ID")
+	subnetIDs, err := fromEnvOrError("SUBNET_ID")
 	if err != nil {
 		return nil, err
 	}
-	retOptions.SubnetID, err = fromEnvOrError("SUBNET_ID")
+	retOptions.SubnetIDs, err = parseResourceIDs("SUBNET_ID", subnetIDs, "subnet-")
 	if err != nil {
 		return nil, err
+	} else if len(retOptions.SubnetIDs) == 0 {
+		return nil, fmt.Errorf("SUBNET_ID must contain at least one subnet, got %q", subnetIDs)
 	}
 	retOptions.ClusterArchitecture, err = fromEnvOrError("CLUSTER_ARCHITECTURE")
 	if err != nil {
 		return nil, err
+	} else if retOptions.ClusterArchitecture != "amd64" && retOptions.ClusterArchitecture != "arm64" && retOptions.ClusterArchitecture != "auto" {
+		return nil, fmt.Errorf("CLUSTER_ARCHITECTURE must be amd64, arm64 or auto, got %s", retOptions.ClusterArchitecture)
 	}
 	retOptions.TaskCpu, err = fromEnvOrError("TASK_CPU")
 	if err != nil {
@@ -66,13 +152,280 @@ func FromEnv() (*Options, error) {
 	}
 
 	// optional
-	retOptions.SecurityGroupID = os.Getenv("SECURITY_GROUP_ID")
+	retOptions.SecurityGroupIDs, err = parseResourceIDs("SECURITY_GROUP_ID", os.Getenv("SECURITY_GROUP_ID"), "sg-")
+	if err != nil {
+		return nil, err
+	}
+	retOptions.CapacityProviderStrategy, err = parseCapacityProviderStrategy(os.Getenv("CAPACITY_PROVIDER_STRATEGY"))
+	if err != nil {
+		return nil, err
+	}
 	retOptions.TaskRoleARN = os.Getenv("TASK_ROLE_ARN")
 	retOptions.ExecutionRoleARN = os.Getenv("EXECUTION_ROLE_ARN")
+	retOptions.RoleNamePrefix = fromEnvOrDefault("ROLE_NAME_PREFIX", "devpod-ecs")
+	retOptions.IamPath = fromEnvOrDefault("IAM_PATH", "/")
+	if !strings.HasPrefix(retOptions.IamPath, "/") || !strings.HasSuffix(retOptions.IamPath, "/") {
+		return nil, fmt.Errorf("IAM_PATH must begin and end with a slash, got %s", retOptions.IamPath)
+	}
+	retOptions.PermissionsBoundaryARN = os.Getenv("PERMISSIONS_BOUNDARY_ARN")
+	retOptions.IamTags, err = parseTags(os.Getenv("IAM_TAGS"))
+	if err != nil {
+		return nil, err
+	}
+	retOptions.RegistryCredentialsSecretARN = os.Getenv("REGISTRY_CREDENTIALS_SECRET_ARN")
+	if retOptions.RegistryCredentialsSecretARN != "" && !strings.Contains(retOptions.RegistryCredentialsSecretARN, ":secretsmanager:") {
+		return nil, fmt.Errorf("REGISTRY_CREDENTIALS_SECRET_ARN must be the arn of a secrets manager secret, got %s", retOptions.RegistryCredentialsSecretARN)
+	}
+	retOptions.RegistryCredentialsKmsKeyARN = os.Getenv("REGISTRY_CREDENTIALS_KMS_KEY_ARN")
+	if retOptions.RegistryCredentialsKmsKeyARN != "" && !strings.Contains(retOptions.RegistryCredentialsKmsKeyARN, ":kms:") {
+		return nil, fmt.Errorf("REGISTRY_CREDENTIALS_KMS_KEY_ARN must be the arn of a kms key, got %s", retOptions.RegistryCredentialsKmsKeyARN)
+	}
+	retOptions.Secrets, err = parseSecrets(os.Getenv("SECRETS"))
+	if err != nil {
+		return nil, err
+	}
+	retOptions.EfsFileSystemID = os.Getenv("EFS_FILE_SYSTEM_ID")
+	retOptions.EfsRootDirectory = fromEnvOrDefault("EFS_ROOT_DIRECTORY", "/devpod")
+	retOptions.EfsTransitEncrypted = fromEnvOrDefault("EFS_TRANSIT_ENCRYPTION", "ENABLED") == "ENABLED"
+	retOptions.EfsIamAuthorization = fromEnvOrDefault("EFS_IAM_AUTHORIZATION", "DISABLED") == "ENABLED"
+	if retOptions.EfsIamAuthorization && !retOptions.EfsTransitEncrypted {
+		return nil, fmt.Errorf("EFS_IAM_AUTHORIZATION requires EFS_TRANSIT_ENCRYPTION to be ENABLED")
+	}
+	retOptions.EbsVolumeSize, err = fromEnvInt32("EBS_VOLUME_SIZE")
+	if err != nil {
+		return nil, err
+	}
+	retOptions.EbsVolumeType = fromEnvOrDefault("EBS_VOLUME_TYPE", "gp3")
+	retOptions.EbsIops, err = fromEnvInt32("EBS_IOPS")
+	if err != nil {
+		return nil, err
+	}
+	retOptions.EbsThroughput, err = fromEnvInt32("EBS_THROUGHPUT")
+	if err != nil {
+		return nil, err
+	}
+	retOptions.EbsKmsKeyID = os.Getenv("EBS_KMS_KEY_ID")
+	retOptions.EbsInfrastructureRoleARN = os.Getenv("EBS_INFRASTRUCTURE_ROLE_ARN")
+	retOptions.AllowInstanceMigration = os.Getenv("ALLOW_INSTANCE_MIGRATION") == "true"
+	retOptions.StartTimeout, err = fromEnvDuration("START_TIMEOUT", time.Minute*5)
+	if err != nil {
+		return nil, err
+	}
+	retOptions.CloudWatchLogs = fromEnvOrDefault("CLOUDWATCH_LOGS", "DISABLED") == "ENABLED"
+	retOptions.LogGroup = fromEnvOrDefault("LOG_GROUP", "/devpod/ecs")
+	retOptions.LogRetentionDays, err = fromEnvInt32("LOG_RETENTION_DAYS")
+	if err != nil {
+		return nil, err
+	} else if retOptions.LogRetentionDays != 0 && !slices.Contains(logRetentionDays, retOptions.LogRetentionDays) {
+		return nil, fmt.Errorf("LOG_RETENTION_DAYS must be one of %v, got %d", logRetentionDays, retOptions.LogRetentionDays)
+	}
+	retOptions.TaskDefinitionOverlay, err = parseTaskDefinitionOverlay(os.Getenv("TASK_DEFINITION_OVERLAY"))
+	if err != nil {
+		return nil, err
+	}
+	for name, endpoint := range map[string]*string{
+		"ECS_ENDPOINT":  &retOptions.EcsEndpoint,
+		"IAM_ENDPOINT":  &retOptions.IamEndpoint,
+		"SSM_ENDPOINT":  &retOptions.SsmEndpoint,
+		"EFS_ENDPOINT":  &retOptions.EfsEndpoint,
+		"EC2_ENDPOINT":  &retOptions.Ec2Endpoint,
+		"LOGS_ENDPOINT": &retOptions.LogsEndpoint,
+	} {
+		*endpoint, err = fromEnvURL(name)
+		if err != nil {
+			return nil, err
+		}
+	}
 
 	return retOptions, nil
 }
 
+// parseTags parses a comma separated list of key=value
+func parseTags(val string) (map[string]string, error) {
+	tags := map[string]string{}
+	for _, entry := range splitList(val) {
+		key, value, ok := strings.Cut(entry, "=")
+		key, value = strings.TrimSpace(key), strings.TrimSpace(value)
+		if !ok || key == "" {
+			return nil, fmt.Errorf("parse option IAM_TAGS: invalid entry %q, expected key=value", entry)
+		}
+
+		tags[key] = value
+	}
+
+	return tags, nil
+}
+
+// parseTaskDefinitionOverlay reads the overlay from the file the value points to or, if there
+// is no such file, uses the value itself. JSON and YAML are both converted to JSON.
+func parseTaskDefinitionOverlay(val string) (string, error) {
+	if strings.TrimSpace(val) == "" {
+		return "", nil
+	}
+
+	content := []byte(val)
+	if !strings.ContainsAny(val, "{\n") {
+		file, err := os.ReadFile(val)
+		if err == nil {
+			content = file
+		} else if !errors.Is(err, fs.ErrNotExist) {
+			return "", fmt.Errorf("read option TASK_DEFINITION_OVERLAY: %w", err)
+		}
+	}
+
+	raw, err := yaml.YAMLToJSON(content)
+	if err != nil {
+		return "", fmt.Errorf("parse option TASK_DEFINITION_OVERLAY: %w", err)
+	}
+
+	// make sure it is an object, a file path with a typo ends up as a plain string
+	overlay := map[string]any{}
+	err = json.Unmarshal(raw, &overlay)
+	if err != nil {
+		return "", fmt.Errorf("parse option TASK_DEFINITION_OVERLAY: expected a JSON or YAML object or a path to a file with one: %w", err)
+	}
+
+	return string(raw), nil
+}
+
+// parseSecrets parses a comma separated list of NAME=arn, where arn is either a Secrets
+// Manager secret or a Parameter Store parameter
+func parseSecrets(val string) ([]Secret, error) {
+	secrets := []Secret{}
+	for _, entry := range splitList(val) {
+		name, valueFrom, ok := strings.Cut(entry, "=")
+		name, valueFrom = strings.TrimSpace(name), strings.TrimSpace(valueFrom)
+		if !ok || name == "" {
+			return nil, fmt.Errorf("parse option SECRETS: invalid entry %q, expected NAME=arn", entry)
+		} else if !strings.HasPrefix(valueFrom, "arn:") || (!strings.Contains(valueFrom, ":secretsmanager:") && !strings.Contains(valueFrom, ":ssm:")) {
+			return nil, fmt.Errorf("parse option SECRETS: %s must be the arn of a secrets manager secret or ssm parameter, got %s", name, valueFrom)
+		}
+
+		secrets = append(secrets, Secret{Name: name, ValueFrom: valueFrom})
+	}
+
+	return secrets, nil
+}
+
+// parseCapacityProviderStrategy parses a comma separated list of name[:weight[:base]], e.g.
+// FARGATE_SPOT:3,FARGATE:1:1. The weight defaults to 1 and the base to 0.
+func parseCapacityProviderStrategy(val string) ([]CapacityProvider, error) {
+	strategy := []CapacityProvider{}
+	for _, entry := range strings.Split(val, ",") {
+		entry = strings.TrimSpace(entry)
+		if entry == "" {
+			continue
+		}
+
+		parts := strings.Split(entry, ":")
+		if len(parts) > 3 || parts[0] == "" {
+			return nil, fmt.Errorf("parse option CAPACITY_PROVIDER_STRATEGY: invalid entry %q, expected name[:weight[:base]]", entry)
+		}
+
+		capacityProvider := CapacityProvider{Name: parts[0], Weight: 1}
+		for i, field := range []*int32{&capacityProvider.Weight, &capacityProvider.Base} {
+			if len(parts) <= i+1 {
+				break
+			}
+
+			parsed, err := strconv.ParseInt(parts[i+1], 10, 32)
+			if err != nil || parsed < 0 {
+				return nil, fmt.Errorf("parse option CAPACITY_PROVIDER_STRATEGY: invalid entry %q, expected name[:weight[:base]]", entry)
+			}
+			*field = int32(parsed)
+		}
+
+		strategy = append(strategy, capacityProvider)
+	}
+
+	return strategy, nil
+}
+
+// parseResourceIDs parses a comma separated list of ids or arns of resources whose ids start
+// with prefix, like subnet- or sg-
+func parseResourceIDs(name, val, prefix string) ([]string, error) {
+	ids := splitList(val)
+	for _, id := range ids {
+		resource := id
+		if strings.HasPrefix(id, "arn:") {
+			resource = id[strings.LastIndex(id, "/")+1:]
+		}
+		if !strings.HasPrefix(resource, prefix) || len(resource) == len(prefix) {
+			return nil, fmt.Errorf("%s must be a comma separated list of %s ids, got %s", name, prefix, id)
+		}
+	}
+
+	return ids, nil
+}
+
+// splitList splits a comma separated list and drops empty entries
+func splitList(val string) []string {
+	list := []string{}
+	for _, entry := range strings.Split(val, ",") {
+		entry = strings.TrimSpace(entry)
+		if entry != "" {
+			list = append(list, entry)
+		}
+	}
+
+	return list
+}
+
+func fromEnvOrDefault(name, defaultValue string) string {
+	val := os.Getenv(name)
+	if val == "" {
+		return defaultValue
+	}
+
+	return val
+}
+
+func fromEnvInt32(name string) (int32, error) {
+	val := os.Getenv(name)
+	if val == "" {
+		return 0, nil
+	}
+
+	parsed, err := strconv.ParseInt(val, 10, 32)
+	if err != nil {
+		return 0, fmt.Errorf("parse option %s: %w", name, err)
+	}
+
+	return int32(parsed), nil
+}
+
+func fromEnvDuration(name string, defaultValue time.Duration) (time.Duration, error) {
+	val := os.Getenv(name)
+	if val == "" {
+		return defaultValue, nil
+	}
+
+	parsed, err := time.ParseDuration(val)
+	if err != nil {
+		return 0, fmt.Errorf("parse option %s: %w", name, err)
+	} else if parsed <= 0 {
+		return 0, fmt.Errorf("option %s must be a positive duration such as 10m, got %s", name, val)
+	}
+
+	return parsed, nil
+}
+
+func fromEnvURL(name string) (string, error) {
+	val := os.Getenv(name)
+	if val == "" {
+		return "", nil
+	}
+
+	parsed, err := url.Parse(val)
+	if err != nil {
+		return "", fmt.Errorf("parse option %s: %w", name, err)
+	} else if parsed.Scheme == "" || parsed.Host == "" {
+		return "", fmt.Errorf("option %s must be an url such as http://localhost:4566, got %s", name, val)
+	}
+
+	return val, nil
+}
+
 func fromEnvOrError(name string) (string, error) {
 	val := os.Getenv(name)
 	if val == "" {
@@ -0,0 +1,190 @@
+package options
+
+import (
+	"os"
+	"path/filepath"
+	"reflect"
+	"testing"
+	"time"
+)
+
+func TestParseCapacityProviderStrategy(t *testing.T) {
+	strategy, err := parseCapacityProviderStrategy("FARGATE_SPOT:3, FARGATE:1:1,my-asg")
+	if err != nil {
+		t.Fatalf("parse: %v", err)
+	}
+
+	expected := []CapacityProvider{
+		{Name: "FARGATE_SPOT", Weight: 3},
+		{Name: "FARGATE", Weight: 1, Base: 1},
+		{Name: "my-asg", Weight: 1},
+	}
+	if !reflect.DeepEqual(strategy, expected) {
+		t.Fatalf("expected %v, got %v", expected, strategy)
+	}
+
+	for _, invalid := range []string{"FARGATE:x", ":1", "FARGATE:1:2:3", "FARGATE:-1"} {
+		_, err = parseCapacityProviderStrategy(invalid)
+		if err == nil {
+			t.Errorf("expected %q to be invalid", invalid)
+		}
+	}
+}
+
+func TestParseSecrets(t *testing.T) {
+	secrets, err := parseSecrets("DB_PASSWORD=arn:aws:secretsmanager:us-east-1:123456789012:secret:db-AbCdEf:password::, TOKEN=arn:aws:ssm:us-east-1:123456789012:parameter/devpod/token")
+	if err != nil {
+		t.Fatalf("parse: %v", err)
+	}
+
+	expected := []Secret{
+		{Name: "DB_PASSWORD", ValueFrom: "arn:aws:secretsmanager:us-east-1:123456789012:secret:db-AbCdEf:password::"},
+		{Name: "TOKEN", ValueFrom: "arn:aws:ssm:us-east-1:123456789012:parameter/devpod/token"},
+	}
+	if !reflect.DeepEqual(secrets, expected) {
+		t.Fatalf("expected %v, got %v", expected, secrets)
+	}
+
+	for _, invalid := range []string{"TOKEN", "=arn:aws:ssm:us-east-1:123456789012:parameter/token", "TOKEN=/devpod/token", "TOKEN=arn:aws:s3:::bucket"} {
+		_, err = parseSecrets(invalid)
+		if err == nil {
+			t.Errorf("expected %q to be invalid", invalid)
+		}
+	}
+}
+
+func TestFromEnvDuration(t *testing.T) {
+	t.Setenv("START_TIMEOUT", "")
+	timeout, err := fromEnvDuration("START_TIMEOUT", time.Minute*5)
+	if err != nil || timeout != time.Minute*5 {
+		t.Fatalf("expected default of 5m, got %s: %v", timeout, err)
+	}
+
+	t.Setenv("START_TIMEOUT", "15m")
+	timeout, err = fromEnvDuration("START_TIMEOUT", time.Minute*5)
+	if err != nil || timeout != time.Minute*15 {
+		t.Fatalf("expected 15m, got %s: %v", timeout, err)
+	}
+
+	for _, invalid := range []string{"15", "-1m", "0s"} {
+		t.Setenv("START_TIMEOUT", invalid)
+		_, err = fromEnvDuration("START_TIMEOUT", time.Minute*5)
+		if err == nil {
+			t.Errorf("expected %q to be invalid", invalid)
+		}
+	}
+}
+
+func TestParseTags(t *testing.T) {
+	tags, err := parseTags("team=platform, cost-center=1234,empty=")
+	if err != nil {
+		t.Fatalf("parse: %v", err)
+	}
+
+	expected := map[string]string{"team": "platform", "cost-center": "1234", "empty": ""}
+	if !reflect.DeepEqual(tags, expected) {
+		t.Fatalf("expected %v, got %v", expected, tags)
+	}
+
+	for _, invalid := range []string{"team", "=platform"} {
+		_, err = parseTags(invalid)
+		if err == nil {
+			t.Errorf("expected %q to be invalid", invalid)
+		}
+	}
+}
+
+func TestParseTaskDefinitionOverlay(t *testing.T) {
+	expected := `{"containerDefinitions":[{"linuxParameters":{"sharedMemorySize":1024}}]}`
+
+	// inline json and yaml
+	for _, inline := range []string{
+		expected,
+		"containerDefinitions:\n- linuxParameters:\n    sharedMemorySize: 1024\n",
+	} {
+		overlay, err := parseTaskDefinitionOverlay(inline)
+		if err != nil {
+			t.Fatalf("parse %q: %v", inline, err)
+		} else if overlay != expected {
+			t.Fatalf("expected %s, got %s", expected, overlay)
+		}
+	}
+
+	// file
+	file := filepath.Join(t.TempDir(), "overlay.yaml")
+	err := os.WriteFile(file, []byte("containerDefinitions:\n- linuxParameters:\n    sharedMemorySize: 1024\n"), 0o600)
+	if err != nil {
+		t.Fatalf("write overlay: %v", err)
+	}
+	overlay, err := parseTaskDefinitionOverlay(file)
+	if err != nil {
+		t.Fatalf("parse file: %v", err)
+	} else if overlay != expected {
+		t.Fatalf("expected %s, got %s", expected, overlay)
+	}
+
+	// a missing file isn't an object
+	_, err = parseTaskDefinitionOverlay(filepath.Join(t.TempDir(), "missing.yaml"))
+	if err == nil {
+		t.Fatalf("expected a missing file to be invalid")
+	}
+}
+
+func TestParseResourceIDs(t *testing.T) {
+	ids, err := parseResourceIDs("SUBNET_ID", "subnet-1, ,arn:aws:ec2:us-east-1:123456789012:subnet/subnet-2,", "subnet-")
+	if err != nil {
+		t.Fatalf("parse: %v", err)
+	}
+
+	expected := []string{"subnet-1", "arn:aws:ec2:us-east-1:123456789012:subnet/subnet-2"}
+	if !reflect.DeepEqual(ids, expected) {
+		t.Fatalf("expected %v, got %v", expected, ids)
+	}
+
+	for _, invalid := range []string{"sg-1", "subnet-", "subnet-1,vpc-1", "arn:aws:ec2:us-east-1:123456789012:vpc/vpc-1"} {
+		_, err = parseResourceIDs("SUBNET_ID", invalid, "subnet-")
+		if err == nil {
+			t.Errorf("expected %q to be invalid", invalid)
+		}
+	}
+}
+
+func TestFromProviderEnvEmptySubnets(t *testing.T) {
+	t.Setenv("CLUSTER_ID", "devpod")
+	t.Setenv("SUBNET_ID", " , ")
+
+	_, err := FromProviderEnv()
+	if err == nil {
+		t.Fatal("expected an error for a subnet list without subnets")
+	}
+}
+
+func TestFromProviderEnvCloudWatchLogs(t *testing.T) {
+	for name, val := range map[string]string{
+		"CLUSTER_ID":           "devpod",
+		"SUBNET_ID":            "subnet-1",
+		"CLUSTER_ARCHITECTURE": "amd64",
+		"TASK_CPU":             "1024",
+		"TASK_MEMORY":          "2048",
+		"LAUNCH_TYPE":          "FARGATE",
+		"ASSIGN_PUBLIC_IP":     "ENABLED",
+	} {
+		t.Setenv(name, val)
+	}
+
+	t.Setenv("CLOUDWATCH_LOGS", "")
+	options, err := FromProviderEnv()
+	if err != nil {
+		t.Fatalf("from env: %v", err)
+	} else if options.CloudWatchLogs {
+		t.Fatal("expected CloudWatch Logs to be disabled by default")
+	}
+
+	t.Setenv("CLOUDWATCH_LOGS", "ENABLED")
+	options, err = FromProviderEnv()
+	if err != nil {
+		t.Fatalf("from env: %v", err)
+	} else if !options.CloudWatchLogs {
+		t.Fatal("expected CloudWatch Logs to be enabled")
+	}
+}